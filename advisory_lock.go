@@ -0,0 +1,346 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"hash/fnv"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrAdvisoryLockHeld is returned by TryLock when another session holds the lock
+var ErrAdvisoryLockHeld = errors.New("the advisory lock is held by another session")
+
+// ErrAdvisoryLockNotTransactional is returned when a MySQL lock is taken in a transaction. GET_LOCK is held by the
+// connection, not the transaction, so it would be released before the transaction commits, or left behind after it.
+// Take it in a Session instead
+var ErrAdvisoryLockNotTransactional = errors.New("MySQL advisory locks must be taken in a session")
+
+// ErrAdvisoryLockNotPinned is returned when a lock is taken on something other than a Session or a transaction of an
+// engine set up with InstallSingle. A lock taken on the pool could not be released on the same connection
+var ErrAdvisoryLockNotPinned = errors.New("advisory locks must be taken in a session or a transaction")
+
+// AdvisoryLockOptions configures the lock table used by databases without advisory locks
+type AdvisoryLockOptions struct {
+	// Table holds the locks of databases other than postgres and MySQL. It is created when first needed. Defaults to "vsql_advisory_locks"
+	Table string
+	// PollInterval is how often Lock retries when the lock is held, for databases that cannot wait for it. Defaults to 100ms
+	PollInterval time.Duration
+}
+
+// AdvisoryLocker takes named locks that coordinate work between processes, such as making sure only one instance of
+// a cron job runs at a time. Postgres uses pg_advisory_lock, MySQL uses GET_LOCK, and the other databases insert a
+// row into a lock table. Locks are taken in a Session or a transaction and are released when it ends, if they were
+// not unlocked before. MySQL locks are only taken in a Session, see ErrAdvisoryLockNotTransactional
+type AdvisoryLocker struct {
+	dialect Dialect
+	opts    AdvisoryLockOptions
+}
+
+// NewAdvisoryLocker creates a locker for the dialect
+func NewAdvisoryLocker(dialect Dialect, opts AdvisoryLockOptions) *AdvisoryLocker {
+	if opts.Table == "" {
+		opts.Table = "vsql_advisory_locks"
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 100 * time.Millisecond
+	}
+	return &AdvisoryLocker{
+		dialect: dialect,
+		opts:    opts,
+	}
+}
+
+// AdvisoryLock is a lock held by a Session or transaction
+type AdvisoryLock struct {
+	Name   string
+	locker *AdvisoryLocker
+	// scope is the session or transaction the lock was taken in, used directly so that the lock can be released while it ends
+	scope lockScope
+	// transactional locks are released by the database when the transaction ends
+	transactional bool
+	// owner identifies the row of the lock table
+	owner    string
+	released bool
+}
+
+// lockScope is a queryExecTransaction or a queryExecSession
+type lockScope interface {
+	Exec(ctx context.Context, query vparam.Queryer) (vresult.Resulter, error)
+	onEnd(hook func() error)
+}
+
+// scopeRecorder receives the queryExecTransaction that runs a call made with its context.Context. The engine's
+// transaction wrapper does not expose the queryExecTransaction, so the lock finds it with the statements that take it
+type scopeRecorder struct {
+	scope lockScope
+}
+
+func withScopeRecorder(ctx context.Context, recorder *scopeRecorder) context.Context {
+	return context.WithValue(ctx, scopeRecorderContextKey, recorder)
+}
+
+// recordScope gives the scope to the recorder of ctx, if it has one
+func recordScope(ctx context.Context, scope lockScope) {
+	if ctx == nil {
+		return
+	}
+	if recorder, ok := ctx.Value(scopeRecorderContextKey).(*scopeRecorder); ok {
+		recorder.scope = scope
+	}
+}
+
+// TryLock takes the lock if it is free
+// @param q is a Session or a transaction
+// @return err is ErrAdvisoryLockHeld if another session holds the lock
+func (l *AdvisoryLocker) TryLock(ctx context.Context, q vsql.QueryExecer, name string) (*AdvisoryLock, error) {
+	return l.lock(ctx, q, name, false)
+}
+
+// Lock waits for the lock until ctx is done. Postgres and MySQL wait in the database, the lock table is polled
+// @param q is a Session or a transaction
+func (l *AdvisoryLocker) Lock(ctx context.Context, q vsql.QueryExecer, name string) (*AdvisoryLock, error) {
+	return l.lock(ctx, q, name, true)
+}
+
+func (l *AdvisoryLocker) lock(ctx context.Context, q vsql.QueryExecer, name string, wait bool) (lock *AdvisoryLock, err error) {
+	lock = &AdvisoryLock{
+		Name:   name,
+		locker: l,
+	}
+	recorder := &scopeRecorder{}
+	switch s := q.(type) {
+	case *session:
+		recorder.scope = s.qes
+	case vsql.QueryExecTransactioner:
+		if l.dialect == MySQLDialect {
+			return nil, ErrAdvisoryLockNotTransactional
+		}
+		// transaction-level locks cannot be left behind by a transaction that failed and can no longer run the unlock
+		lock.transactional = l.dialect == PostgresDialect
+		ctx = withScopeRecorder(ctx, recorder)
+	default:
+		return nil, ErrAdvisoryLockNotPinned
+	}
+	switch l.dialect {
+	case PostgresDialect:
+		err = l.lockPostgres(ctx, q, lock, wait)
+	case MySQLDialect:
+		err = l.lockMySQL(ctx, q, lock, wait)
+	default:
+		err = l.lockTable(ctx, q, lock, wait)
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	if lock.transactional {
+		return lock, nil
+	}
+	if lock.scope = recorder.scope; lock.scope == nil {
+		// the transaction was not started by InstallSingle, so the lock could not be released when it ends
+		_, _ = q.Exec(ctx, lock.unlockQuery())
+		return nil, ErrAdvisoryLockNotPinned
+	}
+	lock.scope.onEnd(func() error {
+		return lock.Unlock(context.Background())
+	})
+	return lock, nil
+}
+
+// Unlock releases the lock. Postgres releases locks taken in a transaction only when the transaction ends, so Unlock
+// does nothing for them
+func (a *AdvisoryLock) Unlock(ctx context.Context) (err error) {
+	if a.released || a.transactional {
+		return nil
+	}
+	if _, err = a.scope.Exec(ctx, a.unlockQuery()); err == nil {
+		a.released = true
+	}
+	return err
+}
+
+// unlockQuery returns the statement that releases the lock
+func (a *AdvisoryLock) unlockQuery() vparam.Queryer {
+	l := a.locker
+	switch l.dialect {
+	case PostgresDialect:
+		return NewPositionalQuery("SELECT pg_advisory_unlock(?)", postgresLockKey(a.Name))
+	case MySQLDialect:
+		return NewPositionalQuery("SELECT RELEASE_LOCK(?)", mysqlLockName(a.Name))
+	}
+	return NewPositionalQuery(
+		fmt.Sprintf("DELETE FROM %s WHERE name = ? AND owner = ?", l.dialect.QuoteIdentifier(l.opts.Table)), a.Name, a.owner)
+}
+
+func (l *AdvisoryLocker) lockPostgres(ctx context.Context, q vsql.QueryExecer, lock *AdvisoryLock, wait bool) error {
+	function := "pg_advisory_lock"
+	if lock.transactional {
+		function = "pg_advisory_xact_lock"
+	}
+	if wait {
+		_, err := queryScalar(ctx, q, NewPositionalQuery("SELECT "+function+"(?)", postgresLockKey(lock.Name)), nil)
+		return err
+	}
+	var acquired bool
+	if _, err := queryScalar(ctx, q, NewPositionalQuery("SELECT "+strings.Replace(function, "pg_", "pg_try_", 1)+"(?)", postgresLockKey(lock.Name)), &acquired); err != nil {
+		return err
+	}
+	if !acquired {
+		return ErrAdvisoryLockHeld
+	}
+	return nil
+}
+
+func (l *AdvisoryLocker) lockMySQL(ctx context.Context, q vsql.QueryExecer, lock *AdvisoryLock, wait bool) error {
+	// a negative timeout waits forever
+	timeout := 0
+	if wait {
+		timeout = -1
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = int(math.Ceil(time.Until(deadline).Seconds()))
+			if timeout < 0 {
+				timeout = 0
+			}
+		}
+	}
+	var acquired sql.NullInt64
+	if _, err := queryScalar(ctx, q, NewPositionalQuery("SELECT GET_LOCK(?, ?)", mysqlLockName(lock.Name), timeout), &acquired); err != nil {
+		return err
+	}
+	switch {
+	case !acquired.Valid:
+		return fmt.Errorf("unable to take the lock %s", lock.Name)
+	case acquired.Int64 == 1:
+		return nil
+	case wait:
+		return context.DeadlineExceeded
+	}
+	return ErrAdvisoryLockHeld
+}
+
+// lockTable inserts a row for the lock, which fails if another session holds it. In a transaction, the row is not
+// visible to other sessions until the transaction ends, so they wait on the database rather than on the lock
+func (l *AdvisoryLocker) lockTable(ctx context.Context, q vsql.QueryExecer, lock *AdvisoryLock, wait bool) (err error) {
+	table := l.dialect.QuoteIdentifier(l.opts.Table)
+	columns := "name VARCHAR(255) NOT NULL PRIMARY KEY, owner VARCHAR(64) NOT NULL, locked_at TIMESTAMP NOT NULL"
+	var create string
+	if l.dialect == SQLServerDialect {
+		create = fmt.Sprintf("IF OBJECT_ID(N'%s', N'U') IS NULL CREATE TABLE %s (%s)",
+			strings.Replace(l.opts.Table, "'", "''", -1), table, strings.Replace(columns, "TIMESTAMP", "DATETIME2", 1))
+	} else {
+		create = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, columns)
+	}
+	if _, err = q.Exec(ctx, NewPositionalQuery(create)); err != nil {
+		return err
+	}
+	if lock.owner, err = newAdvisoryLockOwner(); err != nil {
+		return err
+	}
+	for {
+		_, err = q.Exec(ctx, NewPositionalQuery(
+			fmt.Sprintf("INSERT INTO %s (name, owner, locked_at) VALUES (?, ?, ?)", table), lock.Name, lock.owner, time.Now().UTC()))
+		if err == nil {
+			return nil
+		}
+		held, heldErr := queryScalar(ctx, q, NewPositionalQuery(fmt.Sprintf("SELECT owner FROM %s WHERE name = ?", table), lock.Name), nil)
+		if heldErr != nil {
+			return heldErr
+		}
+		if !held {
+			// the insert failed for some other reason than the lock row
+			return err
+		}
+		if !wait {
+			return ErrAdvisoryLockHeld
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.opts.PollInterval):
+		}
+	}
+}
+
+// ForceUnlock removes a lock from the lock table regardless of who holds it. Use it only when the process holding it
+// has died without releasing it. Postgres and MySQL release the locks of a connection when it is closed, so they do
+// not need this
+func (l *AdvisoryLocker) ForceUnlock(ctx context.Context, q vsql.QueryExecer, name string) error {
+	if l.dialect == PostgresDialect || l.dialect == MySQLDialect {
+		return nil
+	}
+	_, err := q.Exec(ctx, NewPositionalQuery(fmt.Sprintf("DELETE FROM %s WHERE name = ?", l.dialect.QuoteIdentifier(l.opts.Table)), name))
+	return err
+}
+
+// queryScalar reads the first column of the first row into dest, if dest is not nil
+// @return found is false if the query returned no rows
+func queryScalar(ctx context.Context, q vsql.QueryExecer, query vparam.Queryer, dest interface{}) (found bool, err error) {
+	rows, err := q.Query(ctx, query)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	row := rows.Next()
+	if row == nil {
+		return false, nil
+	}
+	if dest != nil {
+		err = row.Scan(dest)
+	}
+	return true, err
+}
+
+// postgresLockKey maps the name onto the bigint key space of postgres advisory locks
+func postgresLockKey(name string) int64 {
+	h := fnv.New64a()
+	// hash.Hash never returns an error on Write
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// mysqlLockName shortens names longer than the 64 characters MySQL accepts
+func mysqlLockName(name string) string {
+	if len(name) <= 64 {
+		return name
+	}
+	return fmt.Sprintf("vsql_%016x", uint64(postgresLockKey(name)))
+}
+
+// newAdvisoryLockOwner identifies the holder of a row in the lock table so that it only releases its own lock
+func newAdvisoryLockOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,211 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"testing"
+	"time"
+)
+
+func TestAdvisoryLocker_Table(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:advisory_lock_test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(3)
+	engine := vsql_engine.NewSingle()
+	InstallSingle(engine, db, questionMarkFactory)
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	locker := NewAdvisoryLocker(SQLiteDialect, AdvisoryLockOptions{PollInterval: 5 * time.Millisecond})
+
+	if _, err = locker.TryLock(ctx, engine, "nightly"); err != ErrAdvisoryLockNotPinned {
+		t.Errorf("expected locks on the pool to be refused, got: %v", err)
+	}
+
+	first, err := BeginSession(ctx, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := BeginSession(ctx, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = second.Release() }()
+
+	lock, err := locker.TryLock(ctx, first, "nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locker.TryLock(ctx, second, "nightly"); err != ErrAdvisoryLockHeld {
+		t.Errorf("expected the lock to be held, got: %v", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	_, err = locker.Lock(waitCtx, second, "nightly")
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected waiting for the lock to time out, got: %v", err)
+	}
+	if _, err = locker.TryLock(ctx, second, "weekly"); err != nil {
+		t.Errorf("expected other names to be free: %v", err)
+	}
+
+	if err = lock.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locker.TryLock(ctx, first, "nightly"); err != nil {
+		t.Fatalf("expected the unlocked lock to be free: %v", err)
+	}
+
+	// releasing the session releases its locks
+	done := make(chan error, 1)
+	go func() {
+		_, err := locker.Lock(ctx, second, "nightly")
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err = first.Release(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("expected the waiting session to get the lock: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the lock to be released with the session")
+	}
+}
+
+func TestAdvisoryLocker_TableTransaction(t *testing.T) {
+	engine, db := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	locker := NewAdvisoryLocker(SQLiteDialect, AdvisoryLockOptions{})
+
+	tx, err := engine.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locker.TryLock(ctx, tx, "import"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var held int
+	if err = db.QueryRow("SELECT COUNT(*) FROM vsql_advisory_locks").Scan(&held); err != nil || held != 0 {
+		t.Errorf("expected the lock to be released when the transaction ended, %d held: %v", held, err)
+	}
+}
+
+func TestAdvisoryLocker_OnlyRunsTheLockStatements(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	queries := 0
+	engine.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		queries++
+		c.Next(ctx)
+	})
+	ctx := context.Background()
+	locker := NewAdvisoryLocker(SQLiteDialect, AdvisoryLockOptions{})
+
+	s, err := BeginSession(ctx, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locker.TryLock(ctx, s, "nightly"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Release(); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := engine.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err = locker.TryLock(ctx, tx, "weekly"); err != nil {
+		t.Fatal(err)
+	}
+	if queries != 0 {
+		t.Errorf("expected free locks to be taken without querying, got %d queries", queries)
+	}
+}
+
+func TestAdvisoryLocker_MySQLTransaction(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	tx, err := engine.Begin(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err = NewAdvisoryLocker(MySQLDialect, AdvisoryLockOptions{}).TryLock(context.Background(), tx, "nightly"); err != ErrAdvisoryLockNotTransactional {
+		t.Errorf("expected MySQL locks to be refused in transactions, got: %v", err)
+	}
+}
+
+func TestAdvisoryLocker_CommitRollsBackWhenTheLockCannotBeReleased(t *testing.T) {
+	engine, db := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE items (id INTEGER)")); err != nil {
+		t.Fatal(err)
+	}
+	locker := NewAdvisoryLocker(SQLiteDialect, AdvisoryLockOptions{})
+
+	tx, err := engine.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locker.TryLock(ctx, tx, "import"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec(ctx, vparam.New("INSERT INTO items VALUES (1)")); err != nil {
+		t.Fatal(err)
+	}
+	// the lock's release fails once its table is gone
+	if _, err = tx.Exec(ctx, vparam.New("DROP TABLE vsql_advisory_locks")); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err == nil {
+		t.Fatal("expected the commit to fail when the lock could not be released")
+	}
+	var items int
+	if err = db.QueryRow("SELECT COUNT(*) FROM items").Scan(&items); err != nil || items != 0 {
+		t.Errorf("expected a failed commit to commit nothing, %d items: %v", items, err)
+	}
+}
+
+func TestAdvisoryLockNames(t *testing.T) {
+	if postgresLockKey("nightly") != postgresLockKey("nightly") || postgresLockKey("nightly") == postgresLockKey("weekly") {
+		t.Error("expected keys to be stable and distinct")
+	}
+	long := "a lock name that is much too long for the sixty-four characters that MySQL accepts"
+	if name := mysqlLockName(long); len(name) > 64 || name != mysqlLockName(long) {
+		t.Errorf("expected a stable shortened name, got: %s", name)
+	}
+	if mysqlLockName("nightly") != "nightly" {
+		t.Error("expected short names to be kept")
+	}
+}
@@ -0,0 +1,219 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// AllowlistMode is what the allowlist middleware does with queries whose fingerprint is not on the allowlist
+type AllowlistMode int
+
+const (
+	// AllowlistEnforce rejects the query with a *QueryNotAllowedError before it reaches the database
+	AllowlistEnforce AllowlistMode = iota
+	// AllowlistLog reports the query to OnViolation and lets it run
+	AllowlistLog
+	// AllowlistLearn lets the query run and appends its fingerprint to the LearnFile the first time it is seen, so the
+	// file can be reviewed and loaded as the allowlist. Failing to write the file is reported to OnLearnError, the
+	// query still runs
+	AllowlistLearn
+)
+
+// ErrAllowlistOnViolationRequired is returned by InstallAllowlist in AllowlistLog mode without an OnViolation to
+// report to
+var ErrAllowlistOnViolationRequired = errors.New("the allowlist log mode requires OnViolation")
+
+// String returns the name of the mode
+func (m AllowlistMode) String() string {
+	switch m {
+	case AllowlistEnforce:
+		return "enforce"
+	case AllowlistLog:
+		return "log"
+	case AllowlistLearn:
+		return "learn"
+	}
+	return "unknown"
+}
+
+// QueryNotAllowedError is returned in AllowlistEnforce mode for queries that are not on the allowlist
+type QueryNotAllowedError struct {
+	Fingerprint Fingerprint
+}
+
+func (e *QueryNotAllowedError) Error() string {
+	return fmt.Sprintf("query %s is not on the allowlist: %s", e.Fingerprint.Hash, e.Fingerprint.Normalized)
+}
+
+// AllowlistConfig sets up an Allowlist
+type AllowlistConfig struct {
+	Mode AllowlistMode
+	// OnViolation is called in every mode for each query that is not on the allowlist. It is required by the log mode
+	OnViolation func(ctx context.Context, fp Fingerprint)
+	// OnLearnError, when set, is called when the learn mode fails to write a fingerprint to the LearnFile. The first
+	// such error is also returned by Close
+	OnLearnError func(ctx context.Context, fp Fingerprint, err error)
+	// LearnFile is the file that the learn mode appends newly seen fingerprints to. It is created if it does not exist
+	LearnFile string
+}
+
+// Allowlist is the set of reviewed query fingerprints. It is safe to use from multiple goroutines
+type Allowlist struct {
+	cfg     AllowlistConfig
+	mu      sync.RWMutex
+	allowed map[string]struct{}
+	learned *os.File
+	// learnErr is the first error writing the LearnFile
+	learnErr error
+}
+
+// NewAllowlist creates an empty allowlist, use Load, LoadFile or Add to fill it
+func NewAllowlist(cfg AllowlistConfig) *Allowlist {
+	return &Allowlist{
+		cfg:     cfg,
+		allowed: make(map[string]struct{}),
+	}
+}
+
+// Load reads fingerprints, one per line. Each line starts with the fingerprint's Hash, optionally followed by
+// whitespace and the normalized query for the reviewer's benefit, which is the format written by the learn mode.
+// Blank lines and lines starting with "#" are ignored
+func (a *Allowlist) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		a.allowed[strings.Fields(line)[0]] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// LoadFile reads the fingerprints in the file, see Load
+func (a *Allowlist) LoadFile(path string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return a.Load(f)
+}
+
+// Add allows the queries with the fingerprint
+func (a *Allowlist) Add(fp Fingerprint) {
+	a.mu.Lock()
+	a.allowed[fp.Hash] = struct{}{}
+	a.mu.Unlock()
+}
+
+// Allowed is true if the fingerprint hash is on the allowlist
+func (a *Allowlist) Allowed(hash string) bool {
+	a.mu.RLock()
+	_, ok := a.allowed[hash]
+	a.mu.RUnlock()
+	return ok
+}
+
+// Close closes the LearnFile, if it was opened, and returns the first error writing it
+func (a *Allowlist) Close() (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err, a.learnErr = a.learnErr, nil
+	if a.learned == nil {
+		return err
+	}
+	if closeErr := a.learned.Close(); err == nil {
+		err = closeErr
+	}
+	a.learned = nil
+	return err
+}
+
+// check returns the error that rejects the query, or nil if the query may run
+func (a *Allowlist) check(ctx context.Context, query vparam.Queryer) error {
+	if query == nil {
+		return nil
+	}
+	fp := fingerprintOf(ctx, query.SQLQueryUnInterpolated())
+	if a.Allowed(fp.Hash) {
+		return nil
+	}
+	if a.cfg.OnViolation != nil {
+		a.cfg.OnViolation(ctx, fp)
+	}
+	switch a.cfg.Mode {
+	case AllowlistEnforce:
+		return &QueryNotAllowedError{Fingerprint: fp}
+	case AllowlistLearn:
+		if err := a.learn(fp); err != nil && a.cfg.OnLearnError != nil {
+			a.cfg.OnLearnError(ctx, fp, err)
+		}
+	}
+	return nil
+}
+
+// learn appends the fingerprint to the LearnFile and allows it, so that it is only written once
+func (a *Allowlist) learn(fp Fingerprint) (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.allowed[fp.Hash]; ok {
+		return nil
+	}
+	defer func() {
+		if err != nil && a.learnErr == nil {
+			a.learnErr = err
+		}
+	}()
+	if a.learned == nil {
+		a.learned, err = os.OpenFile(a.cfg.LearnFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			a.learned = nil
+			return err
+		}
+	}
+	if _, err = fmt.Fprintf(a.learned, "%s %s\n", fp.Hash, fp.Normalized); err != nil {
+		return err
+	}
+	a.allowed[fp.Hash] = struct{}{}
+	return nil
+}
+
+// InstallAllowlist adds middleware that checks the fingerprint of every query, insert, exec and statement preparation
+// against the allowlist. Prepared statements are checked when they are prepared and again each time they are run, as
+// they may be run with a different allowlist than the one they were prepared with. Nothing is installed if the
+// allowlist's configuration is invalid
+func InstallAllowlist(engine vsql_engine.SQLQueryer, allowlist *Allowlist) error {
+	if allowlist.cfg.Mode == AllowlistLog && allowlist.cfg.OnViolation == nil {
+		return ErrAllowlistOnViolationRequired
+	}
+	installQueryCheck(engine, allowlist.check)
+	installStatementCheck(engine, allowlist.check)
+	return nil
+}
@@ -0,0 +1,174 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAllowlist_Enforce(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE users (id INTEGER, name TEXT)")); err != nil {
+		t.Fatal(err)
+	}
+
+	var violations []Fingerprint
+	allowlist := NewAllowlist(AllowlistConfig{
+		Mode: AllowlistEnforce,
+		OnViolation: func(ctx context.Context, fp Fingerprint) {
+			violations = append(violations, fp)
+		},
+	})
+	reviewed := FingerprintSQL("INSERT INTO users (id, name) VALUES (?, ?)")
+	if err := allowlist.Load(strings.NewReader("# reviewed\n\n" + reviewed.Hash + " " + reviewed.Normalized + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallAllowlist(engine, allowlist); err != nil {
+		t.Fatal(err)
+	}
+
+	// literals and formatting do not change the fingerprint
+	if _, err := engine.Exec(ctx, vparam.New("insert into users (id, name)\nvalues (1, 'alice')")); err != nil {
+		t.Errorf("expected the reviewed insert to run: %v", err)
+	}
+	_, err := engine.Query(ctx, vparam.New("SELECT name FROM users"))
+	if e, ok := err.(*QueryNotAllowedError); !ok || e.Fingerprint.Normalized != "select name from users" {
+		t.Fatalf("expected the select to be rejected, got: %v", err)
+	}
+	if _, err = engine.Prepare(ctx, vparam.New("DELETE FROM users WHERE id = ?")); err == nil {
+		t.Error("expected preparing the delete to be rejected")
+	}
+	if len(violations) != 2 {
+		t.Errorf("expected 2 violations, got: %v", violations)
+	}
+}
+
+func TestAllowlist_ChecksEachQuery(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE users (id INTEGER)")); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := engine.Prepare(ctx, vparam.NewNamed("DELETE FROM users WHERE id = :id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = stmt.Close() }()
+
+	allowlist := NewAllowlist(AllowlistConfig{Mode: AllowlistEnforce})
+	allowed := vparam.New("SELECT id FROM users")
+	allowlist.Add(FingerprintSQL(allowed.SQLQueryUnInterpolated()))
+	if err = InstallAllowlist(engine, allowlist); err != nil {
+		t.Fatal(err)
+	}
+
+	// a context.Context handed down from an allowed query does not allow other queries
+	if _, err = engine.Exec(withFingerprint(ctx, allowed), vparam.New("DROP TABLE users")); !isNotAllowed(err) {
+		t.Error("expected the query to be checked by its own fingerprint")
+	}
+	if _, err = stmt.Exec(ctx, vparam.NewNamedData(map[string]interface{}{"id": 1})); !isNotAllowed(err) {
+		t.Error("expected the statement prepared before the allowlist was installed to be checked when it runs")
+	}
+}
+
+func TestAllowlist_Learn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allowlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	learnFile := filepath.Join(dir, "learned.txt")
+
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	learner := NewAllowlist(AllowlistConfig{Mode: AllowlistLearn, LearnFile: learnFile})
+	if err = InstallAllowlist(engine, learner); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, q := range []string{"CREATE TABLE users (id INTEGER)", "INSERT INTO users VALUES (1)", "INSERT INTO users VALUES (2)"} {
+		if _, err = engine.Exec(ctx, vparam.New(q)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = learner.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	learned, err := ioutil.ReadFile(learnFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(learned)), "\n"); len(lines) != 2 || !strings.HasSuffix(lines[1], " insert into users values (?)") {
+		t.Fatalf("expected each fingerprint to be learned once, got:\n%s", learned)
+	}
+
+	// the learned file is the allowlist for the next run
+	allowlist := NewAllowlist(AllowlistConfig{Mode: AllowlistEnforce})
+	if err = allowlist.LoadFile(learnFile); err != nil {
+		t.Fatal(err)
+	}
+	if !allowlist.Allowed(FingerprintSQL("insert into users values (3)").Hash) {
+		t.Error("expected the learned insert to be allowed")
+	}
+}
+
+func TestAllowlist_LearnError(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	var reported []error
+	learner := NewAllowlist(AllowlistConfig{
+		Mode:      AllowlistLearn,
+		LearnFile: filepath.Join(os.TempDir(), "allowlist-missing-dir", "missing", "learned.txt"),
+		OnLearnError: func(ctx context.Context, fp Fingerprint, err error) {
+			reported = append(reported, err)
+		},
+	})
+	if err := InstallAllowlist(engine, learner); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Exec(context.Background(), vparam.New("CREATE TABLE users (id INTEGER)")); err != nil {
+		t.Fatalf("expected the query to run when it cannot be learned: %v", err)
+	}
+	if len(reported) != 1 {
+		t.Errorf("expected the learn error to be reported, got: %v", reported)
+	}
+	if err := learner.Close(); err == nil {
+		t.Error("expected Close to return the learn error")
+	}
+}
+
+func TestInstallAllowlist_LogRequiresOnViolation(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	if err := InstallAllowlist(engine, NewAllowlist(AllowlistConfig{Mode: AllowlistLog})); err != ErrAllowlistOnViolationRequired {
+		t.Errorf("expected the log mode to require OnViolation, got: %v", err)
+	}
+}
+
+func isNotAllowed(err error) bool {
+	_, ok := err.(*QueryNotAllowedError)
+	return ok
+}
@@ -0,0 +1,270 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"time"
+)
+
+// WithActor returns a context.Context that attributes the changes made with it to the actor, such as a user id or
+// the name of a service, in the audit records
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// ActorFromContext returns the actor set with WithActor
+func ActorFromContext(ctx context.Context) (actor string, ok bool) {
+	if ctx == nil {
+		return
+	}
+	actor, ok = ctx.Value(actorContextKey).(string)
+	return
+}
+
+// AuditRecord describes a single successful insert or exec
+type AuditRecord struct {
+	// Actor is set with WithActor, empty if it was not set
+	Actor       string
+	Fingerprint Fingerprint
+	// Query is the SQL as it was written, without the arguments
+	Query string
+	// Args are the arguments, after redaction
+	Args []interface{}
+	// RowsAffected is -1 if the driver does not report it
+	RowsAffected int64
+	Time         time.Time
+	// TransactionID identifies the transaction the change was made in, unique within the process. Zero if the change
+	// was not made in a transaction, which includes changes made in a Session
+	TransactionID uint64
+}
+
+// AuditSink stores audit records
+type AuditSink interface {
+	// WriteAudit stores the record. tx is the transaction the change was made in, nil if the change was not made in a
+	// transaction. Sinks that store records in the same database should use tx so the record commits and rolls back
+	// with the change
+	WriteAudit(ctx context.Context, tx *sql.Tx, record AuditRecord) error
+}
+
+// AuditSinkFunc is an AuditSink that calls the function
+type AuditSinkFunc func(ctx context.Context, tx *sql.Tx, record AuditRecord) error
+
+// WriteAudit calls the function
+func (f AuditSinkFunc) WriteAudit(ctx context.Context, tx *sql.Tx, record AuditRecord) error {
+	return f(ctx, tx, record)
+}
+
+// ArgRedactor returns the arguments of a query as they should be recorded. It must not modify args
+type ArgRedactor func(fp Fingerprint, args []interface{}) []interface{}
+
+// redacted replaces the values that are not recorded
+const redacted = "[redacted]"
+
+// RedactAll records every argument that is not nil as "[redacted]". It is the default
+func RedactAll(_ Fingerprint, args []interface{}) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, arg := range args {
+		if arg != nil {
+			out[i] = redacted
+		}
+	}
+	return out
+}
+
+// RedactStrings records string and []byte arguments as "[redacted]" and keeps the rest, which are usually ids,
+// amounts, flags and timestamps
+func RedactStrings(_ Fingerprint, args []interface{}) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch arg.(type) {
+		case string, []byte:
+			out[i] = redacted
+		default:
+			out[i] = arg
+		}
+	}
+	return out
+}
+
+// ErrAuditInterpolationStrategyRequired is returned by InstallAudit when the AuditConfig has no InterpolationStrategy
+var ErrAuditInterpolationStrategyRequired = errors.New("the audit middleware requires an InterpolationStrategy")
+
+// ErrAuditSinkRequired is returned by InstallAudit when the AuditConfig has no Sink
+var ErrAuditSinkRequired = errors.New("the audit middleware requires a Sink")
+
+// AuditError is returned by changes that succeeded, but could not be audited. When the change was made in a
+// transaction, rolling it back undoes the change
+type AuditError struct {
+	Err error
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("unable to audit the change: %v", e.Err)
+}
+
+// Unwrap returns the error reported by the AuditSink
+func (e *AuditError) Unwrap() error {
+	return e.Err
+}
+
+// AuditConfig sets up the audit middleware
+type AuditConfig struct {
+	Sink AuditSink
+	// Redact defaults to RedactAll
+	Redact ArgRedactor
+	// InterpolationStrategy is the same interpolation strategy factory given to the installer, used to read the arguments
+	InterpolationStrategy interpolation_strategy.InterpolationStrategyFactory
+}
+
+// InstallAudit adds middleware that writes an AuditRecord to the sink for every successful insert and exec, including
+// those of prepared statements. Reads are not audited. A record that cannot be written fails the call with an
+// *AuditError. Nothing is installed if the configuration is invalid
+func InstallAudit(engine vsql_engine.SQLQueryer, cfg AuditConfig) error {
+	if cfg.InterpolationStrategy == nil {
+		return ErrAuditInterpolationStrategyRequired
+	}
+	if cfg.Sink == nil {
+		return ErrAuditSinkRequired
+	}
+	if cfg.Redact == nil {
+		cfg.Redact = RedactAll
+	}
+	if sink, ok := cfg.Sink.(*TableAuditSink); ok && sink.InterpolationStrategy == nil {
+		withStrategy := *sink
+		withStrategy.InterpolationStrategy = cfg.InterpolationStrategy
+		cfg.Sink = &withStrategy
+	}
+	engine.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		c.Next(ctx)
+		if c.Error() == nil && c.InsertResult() != nil {
+			if err := cfg.audit(ctx, c.QueryExecTransactioner(), c.Query(), c.Query(), c.InsertResult()); err != nil {
+				c.SetError(err)
+			}
+		}
+	})
+	engine.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		c.Next(ctx)
+		if c.Error() == nil && c.Result() != nil {
+			if err := cfg.audit(ctx, c.QueryExecTransactioner(), c.Query(), c.Query(), c.Result()); err != nil {
+				c.SetError(err)
+			}
+		}
+	})
+	engine.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		c.Next(ctx)
+		if c.Error() == nil && c.InsertResult() != nil {
+			if err := cfg.audit(ctx, statementTransaction(c.Statement()), statementQuery(c.Statement()), c.Parameterer(), c.InsertResult()); err != nil {
+				c.SetError(err)
+			}
+		}
+	})
+	engine.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		c.Next(ctx)
+		if c.Error() == nil && c.Result() != nil {
+			if err := cfg.audit(ctx, statementTransaction(c.Statement()), statementQuery(c.Statement()), c.Parameterer(), c.Result()); err != nil {
+				c.SetError(err)
+			}
+		}
+	})
+	return nil
+}
+
+// audit writes the record of a change
+// @param query is the SQL that was run, nil for statements not created by this package, which are not audited
+// @param params are the arguments, the query itself except for prepared statements
+// @param tx is the transaction the change was made in, nil if there is none
+func (cfg AuditConfig) audit(ctx context.Context, tx interface{}, query vparam.Queryer, params vparam.Parameterer, result vresult.Resulter) error {
+	if query == nil {
+		return nil
+	}
+	sqlQ := query.SQLQueryUnInterpolated()
+	record := AuditRecord{
+		Query:        sqlQ,
+		RowsAffected: -1,
+		Time:         time.Now(),
+	}
+	record.Actor, _ = ActorFromContext(ctx)
+	fp := fingerprintOf(ctx, sqlQ)
+	record.Fingerprint = fp
+	if params != nil {
+		_, args, err := params.Interpolate(sqlQ, cfg.InterpolationStrategy())
+		if err != nil {
+			return &AuditError{Err: err}
+		}
+		record.Args = cfg.Redact(fp, args)
+	}
+	if affected, err := result.RowsAffected(); err == nil {
+		record.RowsAffected = int64(affected)
+	}
+	var goTx *sql.Tx
+	if qet, ok := tx.(*queryExecTransaction); ok {
+		goTx = qet.goTransaction
+		record.TransactionID = qet.id
+	}
+	if err := cfg.Sink.WriteAudit(ctx, goTx, record); err != nil {
+		return &AuditError{Err: err}
+	}
+	return nil
+}
+
+// TableAuditSink inserts audit records into a table of the audited database. Changes made in a transaction are
+// recorded in the same transaction, so the record is only kept if the change is committed. Changes made outside of a
+// transaction are recorded right after they succeed. The table must have the columns:
+//
+//	actor VARCHAR, fingerprint VARCHAR, query TEXT, args TEXT, rows_affected BIGINT, transaction_id BIGINT, created_at TIMESTAMP
+//
+// args holds the redacted arguments as a JSON array
+type TableAuditSink struct {
+	// DB is the audited database, used for changes made outside of a transaction
+	DB      *sql.DB
+	Table   string
+	Dialect Dialect
+	// InterpolationStrategy is the same interpolation strategy factory given to the installer. InstallAudit defaults it
+	// to the AuditConfig's
+	InterpolationStrategy interpolation_strategy.InterpolationStrategyFactory
+}
+
+// WriteAudit inserts the record with tx, or DB if tx is nil
+func (s *TableAuditSink) WriteAudit(ctx context.Context, tx *sql.Tx, record AuditRecord) error {
+	args, err := json.Marshal(record.Args)
+	if err != nil {
+		return err
+	}
+	insert := newPositionalQuery(fmt.Sprintf(
+		"INSERT INTO %s (actor, fingerprint, query, args, rows_affected, transaction_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		s.Dialect.QuoteIdentifier(s.Table)),
+		record.Actor, record.Fingerprint.Hash, record.Query, string(args), record.RowsAffected, int64(record.TransactionID), record.Time.UTC())
+	sqlQ, values, err := insert.Interpolate(insert.query, s.InterpolationStrategy())
+	if err != nil {
+		return err
+	}
+	if tx != nil {
+		_, err = tx.ExecContext(ctx, sqlQ, values...)
+	} else {
+		_, err = s.DB.ExecContext(ctx, sqlQ, values...)
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"github.com/wojnosystems/vsql/vparam"
+	"reflect"
+	"testing"
+)
+
+func TestInstallAudit_TableSink(t *testing.T) {
+	engine, db := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	for _, q := range []string{
+		"CREATE TABLE accounts (id INTEGER, owner TEXT, balance INTEGER)",
+		"CREATE TABLE audit_log (actor TEXT, fingerprint TEXT, query TEXT, args TEXT, rows_affected BIGINT, transaction_id BIGINT, created_at TIMESTAMP)",
+	} {
+		if _, err := engine.Exec(ctx, vparam.New(q)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the sink defaults to the configuration's interpolation strategy
+	err := InstallAudit(engine, AuditConfig{
+		Sink:                  &TableAuditSink{DB: db, Table: "audit_log", Dialect: SQLiteDialect},
+		Redact:                RedactStrings,
+		InterpolationStrategy: questionMarkFactory,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := WithActor(ctx, "alice")
+
+	if _, err := engine.Insert(alice, vparam.NewAppendWithData("INSERT INTO accounts VALUES (?, ?, ?)", 1, "alice", 100)); err != nil {
+		t.Fatal(err)
+	}
+	// reads are not audited
+	rows, err := engine.Query(alice, vparam.New("SELECT id FROM accounts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+
+	tx, err := engine.Begin(alice, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec(alice, vparam.New("DELETE FROM accounts")); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = engine.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := tx.Prepare(ctx, vparam.NewNamed("UPDATE accounts SET balance = :balance WHERE owner = :owner"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stmt.Exec(ctx, vparam.NewNamedData(map[string]interface{}{"balance": 50, "owner": "alice"})); err != nil {
+		t.Fatal(err)
+	}
+	_ = stmt.Close()
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	type auditRow struct {
+		actor, query, args string
+		rowsAffected       int64
+		transactionID      int64
+	}
+	var audits []auditRow
+	auditRows, err := db.Query("SELECT actor, query, args, rows_affected, transaction_id FROM audit_log ORDER BY rowid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = auditRows.Close() }()
+	for auditRows.Next() {
+		var a auditRow
+		if err = auditRows.Scan(&a.actor, &a.query, &a.args, &a.rowsAffected, &a.transactionID); err != nil {
+			t.Fatal(err)
+		}
+		audits = append(audits, a)
+	}
+	if len(audits) != 2 {
+		t.Fatalf("expected the insert and the committed update to be audited, got: %+v", audits)
+	}
+	if a := audits[0]; a.actor != "alice" || a.args != `[1,"[redacted]",100]` || a.rowsAffected != 1 || a.transactionID != 0 {
+		t.Errorf("unexpected insert audit: %+v", a)
+	}
+	if a := audits[1]; a.actor != "" || a.query != "UPDATE accounts SET balance = :balance WHERE owner = :owner" ||
+		a.args != `[50,"[redacted]"]` || a.rowsAffected != 1 || a.transactionID == 0 {
+		t.Errorf("unexpected update audit: %+v", a)
+	}
+}
+
+func TestInstallAudit_SinkError(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE items (id INTEGER)")); err != nil {
+		t.Fatal(err)
+	}
+	var records []AuditRecord
+	err := InstallAudit(engine, AuditConfig{
+		Sink: AuditSinkFunc(func(ctx context.Context, tx *sql.Tx, record AuditRecord) error {
+			records = append(records, record)
+			return sql.ErrConnDone
+		}),
+		InterpolationStrategy: questionMarkFactory,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the record is fingerprinted by the audited query, not the one the context.Context was handed down from
+	outer := withFingerprint(ctx, vparam.New("SELECT id FROM items"))
+	_, err = engine.Exec(outer, vparam.NewAppendWithData("INSERT INTO items VALUES (?)", 7))
+	if e, ok := err.(*AuditError); !ok || e.Err != sql.ErrConnDone {
+		t.Errorf("expected the sink's error, got: %v", err)
+	}
+	if len(records) != 1 || !reflect.DeepEqual(records[0].Args, []interface{}{"[redacted]"}) ||
+		records[0].Fingerprint.Normalized != "insert into items values (?)" {
+		t.Errorf("expected the arguments to be redacted by default: %+v", records)
+	}
+}
+
+func TestInstallAudit_InvalidConfig(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	sink := AuditSinkFunc(func(ctx context.Context, tx *sql.Tx, record AuditRecord) error { return nil })
+	if err := InstallAudit(engine, AuditConfig{Sink: sink}); err != ErrAuditInterpolationStrategyRequired {
+		t.Errorf("expected the interpolation strategy to be required, got: %v", err)
+	}
+	if err := InstallAudit(engine, AuditConfig{InterpolationStrategy: questionMarkFactory}); err != ErrAuditSinkRequired {
+		t.Errorf("expected the sink to be required, got: %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vstmt"
+)
+
+// ErrBatchTransactionUnsupported is returned when an implicit transaction was requested for a statement that cannot
+// start one, such as the statements the engine returns from Prepare. Use ExecQueryBatch instead
+var ErrBatchTransactionUnsupported = errors.New("the statement cannot run its batch in an implicit transaction, use ExecQueryBatch instead")
+
+// BatchErrorMode decides what happens to the rest of a batch when one of its items fails
+type BatchErrorMode int
+
+const (
+	// StopOnFirstError does not run the items after the first that failed
+	StopOnFirstError BatchErrorMode = iota
+	// ContinueOnError runs every item and reports the error of each one that failed
+	ContinueOnError
+)
+
+// BatchExecOptions control how a statement runs a batch of parameter sets
+type BatchExecOptions struct {
+	ErrorMode BatchErrorMode
+	// Transaction runs the batch in a transaction that is committed only if every item succeeded, making the batch
+	// all-or-nothing. Supported by ExecQueryBatch, InsertQueryBatch and BatchExecer statements, and ignored for
+	// transactions, which already are
+	Transaction bool
+}
+
+// BatchItemResult is the outcome of running the statement with one parameter set
+type BatchItemResult struct {
+	RowsAffected int64
+	// LastInsertID is only set by BatchExecer.InsertBatch, InsertQueryBatch and InsertStatementBatch, and only if the
+	// driver reports insert ids
+	LastInsertID int64
+	Err          error
+}
+
+// BatchExecResult is the outcome of a batch
+type BatchExecResult struct {
+	// RowsAffected is the sum of the rows affected by the items that succeeded, zero if the batch was rolled back
+	RowsAffected int64
+	// Items has the result of every item that was run, in order. With StopOnFirstError, it ends at the failed item
+	Items []BatchItemResult
+	// RolledBack is true if the batch ran in an implicit transaction that was rolled back. It is false if committing
+	// the transaction failed, as whether the commit took effect is then unknown
+	RolledBack bool
+}
+
+// BatchItemError is the error of the first item of a batch that failed
+type BatchItemError struct {
+	// Index is the position of the parameter set in the batch
+	Index int
+	Err   error
+}
+
+// Error describes the failed item
+func (e *BatchItemError) Error() string {
+	return fmt.Sprintf("batch item %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the error of the item
+func (e *BatchItemError) Unwrap() error {
+	return e.Err
+}
+
+// BatchExecer is implemented by statements that run many parameter sets themselves, such as the statements prepared by
+// this package
+type BatchExecer interface {
+	// ExecBatch runs the statement once per parameter set
+	ExecBatch(ctx context.Context, params []vparam.Parameterer, opts BatchExecOptions) (BatchExecResult, error)
+	// InsertBatch runs the statement once per parameter set, also collecting the insert id of each
+	InsertBatch(ctx context.Context, params []vparam.Parameterer, opts BatchExecOptions) (BatchExecResult, error)
+}
+
+// ExecQueryBatch prepares the query and runs it once per parameter set. The statement, and the implicit transaction
+// if one is requested, are made through db, so every item goes through the engine's middleware
+// @param db is the engine, or a transaction started on it
+// @return err is a *BatchItemError for the first item that failed, or the error of preparing the statement or of the transaction
+func ExecQueryBatch(ctx context.Context, db vsql.QueryExecer, query vparam.Queryer, params []vparam.Parameterer, opts BatchExecOptions) (BatchExecResult, error) {
+	return queryBatch(ctx, db, query, params, opts, execItem)
+}
+
+// InsertQueryBatch is ExecQueryBatch that also collects the insert id of each item
+func InsertQueryBatch(ctx context.Context, db vsql.QueryExecer, query vparam.Queryer, params []vparam.Parameterer, opts BatchExecOptions) (BatchExecResult, error) {
+	return queryBatch(ctx, db, query, params, opts, insertItem)
+}
+
+// ExecStatementBatch runs an already prepared statement once per parameter set. Statements that implement BatchExecer
+// run the batch themselves. Other statements, such as those the engine returns from Prepare, run each item through
+// their Exec, and so through the statement middleware, but cannot be moved into an implicit transaction: Transaction
+// is rejected with ErrBatchTransactionUnsupported
+// @return err is a *BatchItemError for the first item that failed, or the error of the transaction
+func ExecStatementBatch(ctx context.Context, stmt vstmt.Statementer, params []vparam.Parameterer, opts BatchExecOptions) (BatchExecResult, error) {
+	if b, ok := stmt.(BatchExecer); ok {
+		return b.ExecBatch(ctx, params, opts)
+	}
+	return statementBatch(ctx, stmt, params, opts, execItem)
+}
+
+// InsertStatementBatch is ExecStatementBatch that also collects the insert id of each item
+func InsertStatementBatch(ctx context.Context, stmt vstmt.Statementer, params []vparam.Parameterer, opts BatchExecOptions) (BatchExecResult, error) {
+	if b, ok := stmt.(BatchExecer); ok {
+		return b.InsertBatch(ctx, params, opts)
+	}
+	return statementBatch(ctx, stmt, params, opts, insertItem)
+}
+
+// batchItemRunner runs the statement with one parameter set
+type batchItemRunner func(ctx context.Context, stmt vstmt.Statementer, p vparam.Parameterer) BatchItemResult
+
+func execItem(ctx context.Context, stmt vstmt.Statementer, p vparam.Parameterer) (item BatchItemResult) {
+	res, err := stmt.Exec(ctx, p)
+	if err != nil {
+		item.Err = err
+		return
+	}
+	if affected, err := res.RowsAffected(); err == nil {
+		item.RowsAffected = int64(affected)
+	}
+	return
+}
+
+func insertItem(ctx context.Context, stmt vstmt.Statementer, p vparam.Parameterer) (item BatchItemResult) {
+	res, err := stmt.Insert(ctx, p)
+	if err != nil {
+		item.Err = err
+		return
+	}
+	if affected, err := res.RowsAffected(); err == nil {
+		item.RowsAffected = int64(affected)
+	}
+	if id, err := res.LastInsertId(); err == nil {
+		item.LastInsertID = int64(id)
+	}
+	return
+}
+
+func statementBatch(ctx context.Context, stmt vstmt.Statementer, params []vparam.Parameterer, opts BatchExecOptions, run batchItemRunner) (BatchExecResult, error) {
+	if opts.Transaction {
+		return BatchExecResult{}, ErrBatchTransactionUnsupported
+	}
+	return runBatch(params, opts, func(p vparam.Parameterer) BatchItemResult {
+		return run(ctx, stmt, p)
+	})
+}
+
+// queryBatch prepares the query on db, or in a transaction begun on db, and runs the batch with it
+func queryBatch(ctx context.Context, db vsql.QueryExecer, query vparam.Queryer, params []vparam.Parameterer, opts BatchExecOptions, run batchItemRunner) (result BatchExecResult, err error) {
+	starter, canBegin := db.(vsql.TransactionStarter)
+	if !opts.Transaction || !canBegin {
+		stmt, err := db.Prepare(ctx, query)
+		if err != nil {
+			return result, err
+		}
+		defer func() { _ = stmt.Close() }()
+		return runBatch(params, opts, func(p vparam.Parameterer) BatchItemResult {
+			return run(ctx, stmt, p)
+		})
+	}
+	tx, err := starter.Begin(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	stmt, err := tx.Prepare(ctx, query)
+	if err != nil {
+		_ = tx.Rollback()
+		return result, err
+	}
+	result, err = runBatch(params, opts, func(p vparam.Parameterer) BatchItemResult {
+		return run(ctx, stmt, p)
+	})
+	_ = stmt.Close()
+	if err != nil {
+		_ = tx.Rollback()
+		result.RowsAffected = 0
+		result.RolledBack = true
+		return result, err
+	}
+	// a failed commit may still have taken effect, so the batch is not reported as rolled back
+	return result, tx.Commit()
+}
+
+// runBatch runs each item, honoring the error mode
+func runBatch(params []vparam.Parameterer, opts BatchExecOptions, run func(p vparam.Parameterer) BatchItemResult) (result BatchExecResult, err error) {
+	result.Items = make([]BatchItemResult, 0, len(params))
+	for i, p := range params {
+		item := run(p)
+		result.Items = append(result.Items, item)
+		if item.Err != nil {
+			if err == nil {
+				err = &BatchItemError{Index: i, Err: item.Err}
+			}
+			if opts.ErrorMode == StopOnFirstError {
+				break
+			}
+			continue
+		}
+		result.RowsAffected += item.RowsAffected
+	}
+	return
+}
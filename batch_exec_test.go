@@ -0,0 +1,174 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"errors"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"testing"
+)
+
+func namedBatch(ids ...interface{}) []vparam.Parameterer {
+	params := make([]vparam.Parameterer, len(ids))
+	for i, id := range ids {
+		params[i] = vparam.NewNamedData(map[string]interface{}{"id": id})
+	}
+	return params
+}
+
+func TestExecQueryBatch(t *testing.T) {
+	engine, db := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	// every item must go through the statement middleware
+	executed := 0
+	engine.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		executed++
+		c.Next(ctx)
+	})
+	engine.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		executed++
+		c.Next(ctx)
+	})
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE items (id INTEGER PRIMARY KEY)")); err != nil {
+		t.Fatal(err)
+	}
+	insert := vparam.NewNamed("INSERT INTO items (id) VALUES (:id)")
+
+	result, err := InsertQueryBatch(ctx, engine, insert, namedBatch(1, 2, 2, 3), BatchExecOptions{ErrorMode: ContinueOnError})
+	if itemErr, ok := err.(*BatchItemError); !ok || itemErr.Index != 2 {
+		t.Errorf("expected the duplicate to fail, got %v", err)
+	}
+	if result.RowsAffected != 3 || len(result.Items) != 4 || result.Items[3].LastInsertID != 3 {
+		t.Errorf("expected the other items to be inserted: %+v", result)
+	}
+
+	result, err = ExecQueryBatch(ctx, engine, insert, namedBatch(4, 1, 5), BatchExecOptions{})
+	if err == nil || len(result.Items) != 2 || result.RowsAffected != 1 {
+		t.Errorf("expected the batch to stop at the duplicate: %+v, %v", result, err)
+	}
+
+	result, err = ExecQueryBatch(ctx, engine, insert, namedBatch(6, 7, 1), BatchExecOptions{ErrorMode: ContinueOnError, Transaction: true})
+	if err == nil || !result.RolledBack || result.RowsAffected != 0 {
+		t.Errorf("expected the transaction to be rolled back: %+v, %v", result, err)
+	}
+	result, err = ExecQueryBatch(ctx, engine, insert, namedBatch(8, 9), BatchExecOptions{Transaction: true})
+	if err != nil || result.RolledBack || result.RowsAffected != 2 {
+		t.Errorf("expected the transaction to be committed: %+v, %v", result, err)
+	}
+	var count int
+	if err = db.QueryRow("SELECT COUNT(*) FROM items").Scan(&count); err != nil || count != 6 {
+		t.Errorf("expected 6 items after the rolled back batch, got %d, %v", count, err)
+	}
+	if executed != 11 {
+		t.Errorf("expected all 11 items to go through the middleware, %d did", executed)
+	}
+}
+
+func TestExecStatementBatch_EngineStatement(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE items (id INTEGER PRIMARY KEY)")); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := engine.Prepare(ctx, vparam.NewNamed("INSERT INTO items (id) VALUES (:id)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = stmt.Close() }()
+
+	result, err := ExecStatementBatch(ctx, stmt, namedBatch(1, 2, 3), BatchExecOptions{})
+	if err != nil || result.RowsAffected != 3 {
+		t.Errorf("expected 3 rows, got %+v, %v", result, err)
+	}
+	if _, err = ExecStatementBatch(ctx, stmt, namedBatch(4), BatchExecOptions{Transaction: true}); err != ErrBatchTransactionUnsupported {
+		t.Errorf("expected ErrBatchTransactionUnsupported, got %v", err)
+	}
+}
+
+func TestStatement_ExecBatch(t *testing.T) {
+	engine, db := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	// the statement the package prepared, before the engine wraps it
+	var prepared vstmt.Statementer
+	engine.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		prepared = c.Statement()
+		c.Next(ctx)
+	})
+	executed, begun := 0, 0
+	engine.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		executed++
+		c.Next(ctx)
+	})
+	engine.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		begun++
+		c.Next(ctx)
+	})
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE items (id INTEGER PRIMARY KEY)")); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := engine.Prepare(ctx, vparam.NewNamed("INSERT INTO items (id) VALUES (:id)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = stmt.Close() }()
+	batcher, ok := prepared.(BatchExecer)
+	if !ok {
+		t.Fatal("expected the statements of the package to run batches")
+	}
+
+	result, err := batcher.ExecBatch(ctx, namedBatch(1, 2, 1), BatchExecOptions{ErrorMode: ContinueOnError, Transaction: true})
+	if err == nil || !result.RolledBack || result.RowsAffected != 0 || len(result.Items) != 3 {
+		t.Errorf("expected the transaction to be rolled back: %+v, %v", result, err)
+	}
+	result, err = batcher.ExecBatch(ctx, namedBatch(3, 4), BatchExecOptions{Transaction: true})
+	if err != nil || result.RolledBack || result.RowsAffected != 2 {
+		t.Errorf("expected the transaction to be committed: %+v, %v", result, err)
+	}
+	var count int
+	if err = db.QueryRow("SELECT COUNT(*) FROM items").Scan(&count); err != nil || count != 2 {
+		t.Errorf("expected 2 items after the rolled back batch, got %d, %v", count, err)
+	}
+	if executed != 5 || begun != 2 {
+		t.Errorf("expected the items and transactions to go through the middleware, %d items and %d transactions did", executed, begun)
+	}
+}
+
+func TestStatement_ExecBatchCommitFails(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE items (id INTEGER PRIMARY KEY)")); err != nil {
+		t.Fatal(err)
+	}
+	// the commit takes effect, but its outcome is lost, as if the connection broke while it was acknowledged
+	commitErr := errors.New("connection lost")
+	engine.CommitMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		c.SetError(commitErr)
+	})
+	result, err := ExecQueryBatch(ctx, engine, vparam.NewNamed("INSERT INTO items (id) VALUES (:id)"), namedBatch(1, 2), BatchExecOptions{Transaction: true})
+	if err != commitErr {
+		t.Errorf("expected the commit error, got: %v", err)
+	}
+	if result.RolledBack {
+		t.Error("expected a batch whose commit failed not to be reported as rolled back")
+	}
+}
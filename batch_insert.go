@@ -0,0 +1,177 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"strings"
+)
+
+// ErrBatchNoColumns is returned when a batch insert does not name any columns
+var ErrBatchNoColumns = errors.New("batch insert requires at least one column")
+
+// BatchRows supplies the rows of a batch insert, one at a time, so that large batches need not be held in memory
+type BatchRows interface {
+	// Next returns the values of the next row in the same order as the columns. ok is false once all rows were returned
+	Next() (values []interface{}, ok bool, err error)
+}
+
+// BatchRowsFromSlice supplies the rows of a batch insert from memory
+func BatchRowsFromSlice(rows [][]interface{}) BatchRows {
+	return &sliceBatchRows{rows: rows}
+}
+
+type sliceBatchRows struct {
+	rows     [][]interface{}
+	position int
+}
+
+// Next returns the next row of the slice
+func (s *sliceBatchRows) Next() (values []interface{}, ok bool, err error) {
+	if s.position >= len(s.rows) {
+		return nil, false, nil
+	}
+	values = s.rows[s.position]
+	s.position++
+	return values, true, nil
+}
+
+// BatchInsert describes where the rows of a batch insert go
+type BatchInsert struct {
+	// Dialect sets the identifier quoting and the placeholder limit of each statement
+	Dialect Dialect
+	// Table is the name of the table, optionally qualified with the schema
+	Table string
+	// Columns are the names of the columns, in the same order as the values of each row
+	Columns []string
+	// MaxRows limits the rows of each statement in addition to the dialect's placeholder and row limits. Zero means only the dialect's limits apply
+	MaxRows int
+}
+
+// BatchChunkResult is the outcome of a single INSERT statement of a batch
+type BatchChunkResult struct {
+	// Rows is the number of rows sent in the statement
+	Rows int
+	// RowsAffected is the number of rows the database reported as inserted
+	RowsAffected int64
+	// FirstInsertID is the auto-increment id given to the first row of the statement, only valid if HasInsertID is true.
+	// SQLite only reports the id of the last row, so the first is counted back from it. That is only right when the
+	// statement's rows got consecutive ids: the rows must not set the id themselves, and the table must not have
+	// reached the largest rowid, after which SQLite picks unused ids at random
+	FirstInsertID int64
+	// HasInsertID is false when the database does not report insert ids, such as postgres
+	HasInsertID bool
+}
+
+// InsertBatch inserts the rows using multi-row INSERT ... VALUES (...),(...) statements. Rows are split into chunks so
+// that no statement has more placeholders than the dialect allows. Placeholders are rendered by the interpolation
+// strategy of the engine the rows are inserted through, so the batch runs through the insert middleware like any other insert
+// @param db the engine or transaction to insert into. Use a transaction to make the batch all-or-nothing
+// @return results has one entry per statement that succeeded, even when err is not nil
+func InsertBatch(ctx context.Context, db vsql.QueryExecer, batch BatchInsert, rows BatchRows) (results []BatchChunkResult, err error) {
+	chunkSize, err := batch.rowsPerStatement()
+	if err != nil {
+		return nil, err
+	}
+	chunk := make([]interface{}, 0, chunkSize*len(batch.Columns))
+	rowCount := 0
+	for {
+		values, ok, err := rows.Next()
+		if err != nil {
+			return results, err
+		}
+		if ok {
+			if len(values) != len(batch.Columns) {
+				return results, fmt.Errorf("row %d has %d values, expected %d", rowCount, len(values), len(batch.Columns))
+			}
+			chunk = append(chunk, values...)
+			rowCount++
+		}
+		chunkRows := len(chunk) / len(batch.Columns)
+		if chunkRows == chunkSize || (!ok && chunkRows > 0) {
+			result, err := batch.insertChunk(ctx, db, chunk)
+			if err != nil {
+				return results, err
+			}
+			results = append(results, result)
+			chunk = chunk[:0]
+		}
+		if !ok {
+			return results, nil
+		}
+	}
+}
+
+// rowsPerStatement is the most rows that fit in one statement, within both the placeholder and the row limits of the dialect
+func (b BatchInsert) rowsPerStatement() (int, error) {
+	if len(b.Columns) == 0 {
+		return 0, ErrBatchNoColumns
+	}
+	size := b.Dialect.MaxPlaceholders() / len(b.Columns)
+	if size == 0 {
+		return 0, fmt.Errorf("%d columns exceed the %s limit of %d placeholders per statement", len(b.Columns), b.Dialect, b.Dialect.MaxPlaceholders())
+	}
+	if maxRows := b.Dialect.MaxValuesRows(); maxRows > 0 && maxRows < size {
+		size = maxRows
+	}
+	if b.MaxRows > 0 && b.MaxRows < size {
+		size = b.MaxRows
+	}
+	return size, nil
+}
+
+func (b BatchInsert) insertChunk(ctx context.Context, db vsql.QueryExecer, values []interface{}) (result BatchChunkResult, err error) {
+	result.Rows = len(values) / len(b.Columns)
+	res, err := db.Insert(ctx, newPositionalQuery(b.insertSQL(result.Rows), values...))
+	if err != nil {
+		return result, err
+	}
+	if affected, err := res.RowsAffected(); err == nil {
+		result.RowsAffected = int64(affected)
+	}
+	// not all drivers report insert ids, HasInsertID tells the caller
+	if lastID, err := res.LastInsertId(); err == nil {
+		result.FirstInsertID = b.Dialect.firstInsertID(int64(lastID), result.Rows)
+		result.HasInsertID = true
+	}
+	return result, nil
+}
+
+// insertSQL renders INSERT INTO table (columns) VALUES with a tuple of placeholders for each row
+func (b BatchInsert) insertSQL(rows int) string {
+	sb := strings.Builder{}
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(b.Dialect.QuoteIdentifier(b.Table))
+	sb.WriteString(" (")
+	for i, column := range b.Columns {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(b.Dialect.QuoteIdentifier(column))
+	}
+	sb.WriteString(") VALUES ")
+	sb.WriteString(valuesTuples(len(b.Columns), rows))
+	return sb.String()
+}
+
+// valuesTuples renders a tuple of placeholders for each row
+func valuesTuples(columns, rows int) string {
+	tuple := "(" + strings.TrimSuffix(strings.Repeat("?, ", columns), ", ") + ")"
+	return strings.TrimSuffix(strings.Repeat(tuple+", ", rows), ", ")
+}
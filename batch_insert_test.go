@@ -0,0 +1,102 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// dollarNumbered is the interpolation strategy for postgres
+type dollarNumbered struct {
+	next int
+}
+
+func (d *dollarNumbered) InsertPlaceholderIntoSQL() string {
+	d.next++
+	return fmt.Sprintf("$%d", d.next)
+}
+
+func TestInsertBatch(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, newPositionalQuery("CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, age INTEGER)")); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := make([][]interface{}, 0, 5)
+	for i := 0; i < 5; i++ {
+		rows = append(rows, []interface{}{fmt.Sprintf("user%d", i), i})
+	}
+	batch := BatchInsert{
+		Dialect: SQLiteDialect,
+		Table:   "users",
+		Columns: []string{"name", "age"},
+		MaxRows: 2,
+	}
+	results, err := InsertBatch(ctx, engine, batch, BatchRowsFromSlice(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []BatchChunkResult{
+		{Rows: 2, RowsAffected: 2, FirstInsertID: 1, HasInsertID: true},
+		{Rows: 2, RowsAffected: 2, FirstInsertID: 3, HasInsertID: true},
+		{Rows: 1, RowsAffected: 1, FirstInsertID: 5, HasInsertID: true},
+	}
+	if fmt.Sprint(results) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+
+	_, err = InsertBatch(ctx, engine, batch, BatchRowsFromSlice([][]interface{}{{"short"}}))
+	if err == nil {
+		t.Error("expected an error for a row with too few values")
+	}
+}
+
+func TestBatchInsert_RowsPerStatement(t *testing.T) {
+	batch := BatchInsert{Dialect: GenericDialect, Table: "t", Columns: []string{"a", "b", "c"}}
+	if size, err := batch.rowsPerStatement(); err != nil || size != 333 {
+		t.Errorf("expected 333 rows per statement, got %d, %v", size, err)
+	}
+	// SQL Server allows 2098 placeholders, but only 1000 rows in a VALUES list
+	single := BatchInsert{Dialect: SQLServerDialect, Table: "t", Columns: []string{"a"}}
+	if size, err := single.rowsPerStatement(); err != nil || size != 1000 {
+		t.Errorf("expected 1000 rows per statement, got %d, %v", size, err)
+	}
+	wide := BatchInsert{Dialect: SQLServerDialect, Table: "t", Columns: []string{"a", "b", "c"}}
+	if size, err := wide.rowsPerStatement(); err != nil || size != 699 {
+		t.Errorf("expected the 2098 placeholders of SQL Server to allow 699 rows of 3 columns, got %d, %v", size, err)
+	}
+	batch.Columns = nil
+	if _, err := batch.rowsPerStatement(); err != ErrBatchNoColumns {
+		t.Errorf("expected ErrBatchNoColumns, got %v", err)
+	}
+}
+
+func TestPositionalQuery_NumberedPlaceholders(t *testing.T) {
+	batch := BatchInsert{Dialect: PostgresDialect, Table: "app.users", Columns: []string{"name", "age"}}
+	q := newPositionalQuery(batch.insertSQL(2), "a", 1, "b", 2)
+	sqlQ, params, err := q.Interpolate(q.SQLQueryUnInterpolated(), &dollarNumbered{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `INSERT INTO "app"."users" ("name", "age") VALUES ($1, $2), ($3, $4)`
+	if sqlQ != expected || len(params) != 4 {
+		t.Errorf("expected %s, got %s with %d params", expected, sqlQ, len(params))
+	}
+}
@@ -0,0 +1,271 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// BulkLoader streams rows into a table using the fastest method the database offers
+type BulkLoader interface {
+	// Load sends all of the rows into the table
+	// @param db the engine or a transaction of the engine to load through
+	// @return rowsLoaded is the number of rows the database reported as loaded
+	Load(ctx context.Context, db vsql.QueryExecer, rows BatchRows) (rowsLoaded int64, err error)
+}
+
+// ReaderHandlers registers the readers that LOAD DATA LOCAL INFILE 'Reader::name' reads from. With
+// github.com/go-sql-driver/mysql use ReaderHandlers{Register: mysql.RegisterReaderHandler, Deregister: mysql.DeregisterReaderHandler}
+type ReaderHandlers struct {
+	Register   func(name string, handler func() io.Reader)
+	Deregister func(name string)
+}
+
+// NewBulkLoader returns the loader for the dialect: COPY FROM STDIN for postgres, LOAD DATA LOCAL INFILE for MySQL
+// when handlers are provided, and batched multi-row inserts for everything else
+// @param handlers may be nil, in which case MySQL falls back to batched inserts
+func NewBulkLoader(dialect Dialect, table string, columns []string, handlers *ReaderHandlers) BulkLoader {
+	switch {
+	case dialect == PostgresDialect:
+		return NewCopyLoader(table, columns...)
+	case dialect == MySQLDialect && handlers != nil:
+		return NewLoadDataLoader(table, columns, *handlers)
+	default:
+		return NewBatchLoader(BatchInsert{Dialect: dialect, Table: table, Columns: columns})
+	}
+}
+
+// NewBatchLoader loads rows with InsertBatch
+func NewBatchLoader(batch BatchInsert) BulkLoader {
+	return &batchLoader{batch: batch}
+}
+
+type batchLoader struct {
+	batch BatchInsert
+}
+
+// Load inserts the rows in chunks
+func (l *batchLoader) Load(ctx context.Context, db vsql.QueryExecer, rows BatchRows) (rowsLoaded int64, err error) {
+	results, err := InsertBatch(ctx, db, l.batch, rows)
+	for _, result := range results {
+		rowsLoaded += result.RowsAffected
+	}
+	return rowsLoaded, err
+}
+
+// NewCopyLoader loads rows with postgres' COPY FROM STDIN, prepared the same way pq.CopyIn prepares it. The driver
+// must support COPY statements, as github.com/lib/pq does. COPY only works inside of a transaction: if db is not a
+// transaction, Load wraps the copy in one
+func NewCopyLoader(table string, columns ...string) BulkLoader {
+	return &copyLoader{table: table, columns: columns}
+}
+
+type copyLoader struct {
+	table   string
+	columns []string
+}
+
+// Load prepares the COPY statement, executes it once per row and once more without values to flush the rows
+func (l *copyLoader) Load(ctx context.Context, db vsql.QueryExecer, rows BatchRows) (rowsLoaded int64, err error) {
+	if starter, ok := db.(vsql.SQLer); ok {
+		err = vsql.Txn(starter, ctx, nil, func(tx vsql.QueryExecer) (commit bool, err error) {
+			rowsLoaded, err = l.copy(ctx, tx, rows)
+			return err == nil, err
+		})
+		return
+	}
+	return l.copy(ctx, db, rows)
+}
+
+func (l *copyLoader) copy(ctx context.Context, tx vsql.QueryExecer, rows BatchRows) (rowsLoaded int64, err error) {
+	stmt, err := tx.Prepare(ctx, newPositionalQuery(l.copySQL()))
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := stmt.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	for row := 0; ; row++ {
+		values, ok, err := rows.Next()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+		if len(values) != len(l.columns) {
+			return 0, fmt.Errorf("row %d has %d values, expected %d", row, len(values), len(l.columns))
+		}
+		if _, err = stmt.Exec(ctx, rawParameters(values)); err != nil {
+			return 0, err
+		}
+	}
+	res, err := stmt.Exec(ctx, rawParameters(nil))
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	return int64(affected), err
+}
+
+// copySQL renders COPY table (columns) FROM STDIN
+func (l *copyLoader) copySQL() string {
+	quoted := make([]string, len(l.columns))
+	for i, column := range l.columns {
+		quoted[i] = PostgresDialect.QuoteIdentifier(column)
+	}
+	return fmt.Sprintf("COPY %s (%s) FROM STDIN", PostgresDialect.QuoteIdentifier(l.table), strings.Join(quoted, ", "))
+}
+
+// rawParameters passes the values to the driver as they are, for statements without placeholders such as COPY
+type rawParameters []interface{}
+
+// Interpolate returns the query unchanged and the values
+func (r rawParameters) Interpolate(sqlQuery string, _ interpolation_strategy.InterpolateStrategy) (interpolatedSQLQuery string, params []interface{}, err error) {
+	return sqlQuery, r, nil
+}
+
+// NewLoadDataLoader loads rows with MySQL's LOAD DATA LOCAL INFILE, streaming them as tab-separated values through a
+// reader registered with the driver. The server and the driver must both allow local infile
+func NewLoadDataLoader(table string, columns []string, handlers ReaderHandlers) BulkLoader {
+	return &loadDataLoader{table: table, columns: columns, handlers: handlers}
+}
+
+type loadDataLoader struct {
+	table    string
+	columns  []string
+	handlers ReaderHandlers
+}
+
+// loadDataReaderCount makes the name of each registered reader unique
+var loadDataReaderCount uint64
+
+// Load registers a reader fed by the rows and executes LOAD DATA against it
+func (l *loadDataLoader) Load(ctx context.Context, db vsql.QueryExecer, rows BatchRows) (rowsLoaded int64, err error) {
+	name := fmt.Sprintf("vsql_bulk_load_%d", atomic.AddUint64(&loadDataReaderCount, 1))
+	pr, pw := io.Pipe()
+	l.handlers.Register(name, func() io.Reader { return pr })
+	defer l.handlers.Deregister(name)
+
+	encodeErr := make(chan error, 1)
+	go func() {
+		err := encodeTabSeparated(pw, rows, len(l.columns))
+		_ = pw.CloseWithError(err)
+		encodeErr <- err
+	}()
+
+	res, err := db.Exec(ctx, newPositionalQuery(l.loadDataSQL(name)))
+	// unblocks the encoder if the driver stopped reading early
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	if rowsErr := <-encodeErr; rowsErr != nil && rowsErr != io.ErrClosedPipe {
+		return 0, rowsErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	return int64(affected), err
+}
+
+// loadDataSQL renders LOAD DATA with the format written by encodeTabSeparated
+func (l *loadDataLoader) loadDataSQL(readerName string) string {
+	quoted := make([]string, len(l.columns))
+	for i, column := range l.columns {
+		quoted[i] = MySQLDialect.QuoteIdentifier(column)
+	}
+	return fmt.Sprintf(`LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s CHARACTER SET utf8mb4 FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n' (%s)`,
+		readerName, MySQLDialect.QuoteIdentifier(l.table), strings.Join(quoted, ", "))
+}
+
+// encodeTabSeparated writes the rows in LOAD DATA's default format: fields separated by tabs, rows by new lines, special characters escaped with a backslash and NULL as \N
+func encodeTabSeparated(w io.Writer, rows BatchRows, columnCount int) error {
+	var line []byte
+	for row := 0; ; row++ {
+		values, ok, err := rows.Next()
+		if err != nil || !ok {
+			return err
+		}
+		if len(values) != columnCount {
+			return fmt.Errorf("row %d has %d values, expected %d", row, len(values), columnCount)
+		}
+		line = line[:0]
+		for i, v := range values {
+			if i > 0 {
+				line = append(line, '\t')
+			}
+			line = appendTabSeparatedValue(line, v)
+		}
+		line = append(line, '\n')
+		if _, err = w.Write(line); err != nil {
+			return err
+		}
+	}
+}
+
+func appendTabSeparatedValue(line []byte, v interface{}) []byte {
+	var s string
+	switch d := v.(type) {
+	case nil:
+		return append(line, '\\', 'N')
+	case []byte:
+		if d == nil {
+			return append(line, '\\', 'N')
+		}
+		s = string(d)
+	case string:
+		s = d
+	case bool:
+		if d {
+			return append(line, '1')
+		}
+		return append(line, '0')
+	case time.Time:
+		s = d.Format("2006-01-02 15:04:05.999999")
+	case float32:
+		return strconv.AppendFloat(line, float64(d), 'g', -1, 32)
+	case float64:
+		return strconv.AppendFloat(line, d, 'g', -1, 64)
+	default:
+		s = fmt.Sprint(d)
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			line = append(line, '\\', '\\')
+		case '\t':
+			line = append(line, '\\', 't')
+		case '\n':
+			line = append(line, '\\', 'n')
+		case '\r':
+			line = append(line, '\\', 'r')
+		case 0:
+			line = append(line, '\\', '0')
+		default:
+			line = append(line, s[i])
+		}
+	}
+	return line
+}
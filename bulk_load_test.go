@@ -0,0 +1,86 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestNewBulkLoader_FallsBackToBatchInsert(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, newPositionalQuery("CREATE TABLE events (id INTEGER, kind TEXT)")); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := NewBulkLoader(SQLiteDialect, "events", []string{"id", "kind"}, nil)
+	if _, ok := loader.(*batchLoader); !ok {
+		t.Fatalf("expected the batch insert loader, got %T", loader)
+	}
+	rows := make([][]interface{}, 0, 1200)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, []interface{}{i, "click"})
+	}
+	loaded, err := loader.Load(ctx, engine, BatchRowsFromSlice(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 1200 {
+		t.Errorf("expected 1200 rows to be loaded, got %d", loaded)
+	}
+}
+
+func TestNewBulkLoader_Dialects(t *testing.T) {
+	if _, ok := NewBulkLoader(PostgresDialect, "t", []string{"a"}, nil).(*copyLoader); !ok {
+		t.Error("expected postgres to use COPY")
+	}
+	if _, ok := NewBulkLoader(MySQLDialect, "t", []string{"a"}, nil).(*batchLoader); !ok {
+		t.Error("expected MySQL without reader handlers to use batch inserts")
+	}
+	if _, ok := NewBulkLoader(MySQLDialect, "t", []string{"a"}, &ReaderHandlers{}).(*loadDataLoader); !ok {
+		t.Error("expected MySQL with reader handlers to use LOAD DATA")
+	}
+}
+
+func TestBulkLoad_SQL(t *testing.T) {
+	copySQL := (&copyLoader{table: "app.events", columns: []string{"id", "kind"}}).copySQL()
+	if expected := `COPY "app"."events" ("id", "kind") FROM STDIN`; copySQL != expected {
+		t.Errorf("expected %s, got %s", expected, copySQL)
+	}
+	loadSQL := (&loadDataLoader{table: "events", columns: []string{"id", "kind"}}).loadDataSQL("r1")
+	expected := "LOAD DATA LOCAL INFILE 'Reader::r1' INTO TABLE `events` CHARACTER SET utf8mb4 FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' (`id`, `kind`)"
+	if loadSQL != expected {
+		t.Errorf("expected %s, got %s", expected, loadSQL)
+	}
+}
+
+func TestEncodeTabSeparated(t *testing.T) {
+	rows := BatchRowsFromSlice([][]interface{}{
+		{1, "tab\there", nil},
+		{2.5, []byte("back\\slash\nline"), true},
+	})
+	buf := bytes.Buffer{}
+	if err := encodeTabSeparated(&buf, rows, 3); err != nil {
+		t.Fatal(err)
+	}
+	expected := "1\ttab\\there\t\\N\n2.5\tback\\\\slash\\nline\t1\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,244 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"strings"
+)
+
+// WithInvalidation returns a context.Context that declares the table tags written by inserts and execs run with it.
+// Without it, the tags are parsed from simple INSERT, REPLACE, UPDATE, DELETE, MERGE, TRUNCATE, ALTER TABLE and
+// DROP TABLE statements as the table names. Tags are case-insensitive. Writes that are neither declared nor parsable do
+// not invalidate anything
+func WithInvalidation(ctx context.Context, tags ...string) context.Context {
+	return context.WithValue(ctx, invalidationContextKey, tags)
+}
+
+// Invalidates declares the table tags written by a single query, see WithInvalidation
+func Invalidates(query vparam.Queryer, tags ...string) vparam.Queryer {
+	return &invalidatingQuery{
+		Queryer: query,
+		tags:    tags,
+	}
+}
+
+type invalidatingQuery struct {
+	vparam.Queryer
+	tags []string
+}
+
+func writtenTags(ctx context.Context, query vparam.Queryer) []string {
+	if q, ok := query.(*invalidatingQuery); ok {
+		return q.tags
+	}
+	if ctx != nil {
+		if tags, ok := ctx.Value(invalidationContextKey).([]string); ok {
+			return tags
+		}
+	}
+	if query == nil {
+		return nil
+	}
+	return writtenTables(query.SQLQueryUnInterpolated())
+}
+
+// installCacheInvalidation invalidates the tags of writes made outside of transactions as soon as they succeed. Tags
+// written inside a transaction are held by the transaction until it ends: they are invalidated when it commits and
+// discarded when it rolls back, or when the transaction is dropped without being ended
+func installCacheInvalidation(engine vsql_engine.SQLQueryer, cache *QueryCache) {
+	engine.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		c.Next(ctx)
+		if c.Error() == nil {
+			cache.written(c.QueryExecTransactioner(), writtenTags(ctx, c.Query()))
+		}
+	})
+	engine.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		c.Next(ctx)
+		if c.Error() == nil {
+			cache.written(c.QueryExecTransactioner(), writtenTags(ctx, c.Query()))
+		}
+	})
+	engine.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		c.Next(ctx)
+		if c.Error() == nil {
+			cache.written(statementTransaction(c.Statement()), writtenTags(ctx, statementQuery(c.Statement())))
+		}
+	})
+	engine.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		c.Next(ctx)
+		if c.Error() == nil {
+			cache.written(statementTransaction(c.Statement()), writtenTags(ctx, statementQuery(c.Statement())))
+		}
+	})
+	engine.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		c.Next(ctx)
+		// a failed commit may still have been applied if the connection was lost while committing, so invalidate either way
+		if tags := cache.takePending(c.QueryExecTransactioner()); len(tags) != 0 {
+			cache.Invalidate(tags...)
+		}
+	})
+	engine.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		c.Next(ctx)
+		cache.takePending(c.QueryExecTransactioner())
+	})
+}
+
+// statementTransaction returns the transaction a statement created by this package was prepared in, or nil
+func statementTransaction(s interface{}) interface{} {
+	if st, ok := s.(*statement); ok && st.transaction != nil {
+		return st.transaction
+	}
+	return nil
+}
+
+// written invalidates the tags now if tx is nil or a session, otherwise holds them until the transaction ends
+func (c *QueryCache) written(tx interface{}, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	qet, ok := tx.(*queryExecTransaction)
+	if !ok {
+		c.Invalidate(tags...)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if qet.pendingTags == nil {
+		qet.pendingTags = make(map[*QueryCache]map[string]struct{})
+	}
+	pending, ok := qet.pendingTags[c]
+	if !ok {
+		pending = make(map[string]struct{})
+		qet.pendingTags[c] = pending
+	}
+	for _, tag := range normalizeTags(tags) {
+		pending[tag] = struct{}{}
+	}
+}
+
+// takePending removes and returns the tags held for the transaction
+func (c *QueryCache) takePending(tx interface{}) (tags []string) {
+	qet, ok := tx.(*queryExecTransaction)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for tag := range qet.pendingTags[c] {
+		tags = append(tags, tag)
+	}
+	delete(qet.pendingTags, c)
+	return
+}
+
+// writtenTables returns the tables written by each statement of the query that is a simple write
+func writtenTables(sqlQuery string) (tables []string) {
+	tokens := withoutComments(tokenizeSQL(sqlQuery))
+	start := 0
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) && !tokens[i].is(";") {
+			continue
+		}
+		if table, ok := writtenTable(tokens[start:i]); ok {
+			tables = append(tables, table)
+		}
+		start = i + 1
+	}
+	return
+}
+
+// writeModifiers are the words that may appear between the write keyword and the table name
+var writeModifiers = []string{"low_priority", "delayed", "high_priority", "quick", "ignore", "into", "from", "only", "table", "if", "exists"}
+
+// writtenTable finds the table written by a single statement
+func writtenTable(tokens []sqlToken) (table string, ok bool) {
+	if len(tokens) == 0 {
+		return "", false
+	}
+	switch {
+	case tokens[0].is("insert"), tokens[0].is("replace"), tokens[0].is("update"), tokens[0].is("delete"),
+		tokens[0].is("merge"), tokens[0].is("truncate"):
+	case tokens[0].is("alter"), tokens[0].is("drop"):
+		if len(tokens) < 2 || !tokens[1].is("table") {
+			return "", false
+		}
+	case tokens[0].is("copy"):
+		// COPY table FROM loads the table, COPY table TO only reads it
+		table, ok = tableName(tokens, 1)
+		for _, t := range tokens[1:] {
+			if t.is("from") {
+				return table, ok
+			}
+		}
+		return "", false
+	case tokens[0].is("load"):
+		// LOAD DATA ... INTO TABLE table
+		for i := 1; i+1 < len(tokens); i++ {
+			if tokens[i].is("into") && tokens[i+1].is("table") {
+				return tableName(tokens, i+2)
+			}
+		}
+		return "", false
+	default:
+		return "", false
+	}
+	i := 1
+	for i < len(tokens) && isWriteModifier(tokens[i]) {
+		i++
+	}
+	return tableName(tokens, i)
+}
+
+func isWriteModifier(t sqlToken) bool {
+	for _, m := range writeModifiers {
+		if t.is(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableName reads a possibly schema-qualified name starting at i and returns the unqualified table name
+func tableName(tokens []sqlToken, i int) (name string, ok bool) {
+	for i < len(tokens) && (tokens[i].kind == tokenWord || tokens[i].kind == tokenQuotedIdentifier) {
+		name, ok = unquoteIdentifier(tokens[i]), true
+		if i+1 >= len(tokens) || !tokens[i+1].is(".") {
+			break
+		}
+		i += 2
+	}
+	return
+}
+
+// unquoteIdentifier returns the name of a quoted identifier as written, and un-quoted names in lower-case as they are case-insensitive
+func unquoteIdentifier(t sqlToken) string {
+	if t.kind != tokenQuotedIdentifier {
+		return strings.ToLower(t.text)
+	}
+	text := t.text
+	if len(text) < 2 {
+		return text
+	}
+	open, inner := text[0], text[1:len(text)-1]
+	if open == '[' {
+		return inner
+	}
+	return strings.Replace(inner, string([]byte{open, open}), string(open), -1)
+}
@@ -0,0 +1,26 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+// Config holds the optional behaviors of the installers. The zero value behaves exactly like InstallSingle
+type Config struct {
+	// Timeouts are the default deadlines applied to each kind of database call when the caller's context.Context has no earlier deadline
+	Timeouts Timeouts
+	// ReplicaHealth, when set, removes unhealthy replicas from read routing in InstallSingleReplicated. It is stopped when the engine is closed
+	ReplicaHealth *ReplicaHealth
+	// StatementCache, when set, reuses statements prepared outside of transactions. Its statements are purged when the engine is closed
+	StatementCache *StatementCache
+}
@@ -0,0 +1,41 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+// contextKey is the type of all keys this package stores in a context.Context. Using an unexported type keeps the
+// values from colliding with keys set by other packages
+type contextKey int
+
+const (
+	// fingerprintContextKey holds the fingerprintedQuery of the query currently passing through the middleware
+	fingerprintContextKey contextKey = iota
+	// primaryContextKey is set when reads must go to the primary database instead of a replica
+	primaryContextKey
+	// shardContextKey holds the shard id set with WithShard
+	shardContextKey
+	// queryCacheContextKey holds the queryCachePolicy set with WithQueryCache
+	queryCacheContextKey
+	// invalidationContextKey holds the table tags set with WithInvalidation
+	invalidationContextKey
+	// actorContextKey holds the actor set with WithActor
+	actorContextKey
+	// tenantContextKey holds the tenant set with WithTenant, or allTenants set with WithAllTenants
+	tenantContextKey
+	// sessionContextKey holds the sessionRequest of BeginSession, to start a session instead of a transaction
+	sessionContextKey
+	// scopeRecorderContextKey holds the scopeRecorder of an advisory lock taken in a transaction
+	scopeRecorderContextKey
+)
@@ -0,0 +1,352 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// assignValue copies a value read from the database into a Scan destination, converting it the same way sql.Rows.Scan
+// would. It is used by rows that are held in memory instead of read from a driver
+func assignValue(dest interface{}, src interface{}) error {
+	switch d := dest.(type) {
+	case nil:
+		return fmt.Errorf("destination pointer is nil")
+	case *interface{}:
+		if b, ok := src.([]byte); ok {
+			*d = cloneBytes(b)
+			return nil
+		}
+		*d = src
+		return nil
+	case sql.Scanner:
+		return d.Scan(src)
+	case *string:
+		if src == nil {
+			return errNullConversion(dest)
+		}
+		*d = asString(src)
+		return nil
+	case *[]byte:
+		if src == nil {
+			*d = nil
+			return nil
+		}
+		*d = asBytes(src)
+		return nil
+	case *sql.RawBytes:
+		if src == nil {
+			*d = nil
+			return nil
+		}
+		*d = asBytes(src)
+		return nil
+	case *bool:
+		if src == nil {
+			return errNullConversion(dest)
+		}
+		if b, ok := src.(bool); ok {
+			*d = b
+			return nil
+		}
+		b, err := strconv.ParseBool(asString(src))
+		if err != nil {
+			return fmt.Errorf("converting %T to bool: %v", src, err)
+		}
+		*d = b
+		return nil
+	case *time.Time:
+		t, ok := src.(time.Time)
+		if !ok {
+			return fmt.Errorf("unsupported Scan, storing %T into type *time.Time", src)
+		}
+		*d = t
+		return nil
+	}
+	return assignReflect(dest, src)
+}
+
+// assignReflect handles destinations that are pointers to numbers, to named types and to pointers (nullable values)
+func assignReflect(dest interface{}, src interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("destination not a pointer")
+	}
+	elem := dv.Elem()
+	if src == nil {
+		if elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Interface || elem.Kind() == reflect.Slice || elem.Kind() == reflect.Map {
+			elem.Set(reflect.Zero(elem.Type()))
+			return nil
+		}
+		return errNullConversion(dest)
+	}
+	if elem.Kind() == reflect.Ptr {
+		ptr := reflect.New(elem.Type().Elem())
+		if err := assignValue(ptr.Interface(), src); err != nil {
+			return err
+		}
+		elem.Set(ptr)
+		return nil
+	}
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(elem.Type()) {
+		elem.Set(sv)
+		return nil
+	}
+	s := asString(src)
+	switch elem.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, elem.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T %q to %s: %v", src, s, elem.Kind(), err)
+		}
+		elem.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, elem.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T %q to %s: %v", src, s, elem.Kind(), err)
+		}
+		elem.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, elem.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T %q to %s: %v", src, s, elem.Kind(), err)
+		}
+		elem.SetFloat(f)
+		return nil
+	case reflect.String:
+		elem.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("converting %T %q to bool: %v", src, s, err)
+		}
+		elem.SetBool(b)
+		return nil
+	}
+	return fmt.Errorf("unsupported Scan, storing %T into type %T", src, dest)
+}
+
+func errNullConversion(dest interface{}) error {
+	return fmt.Errorf("converting NULL to %s is unsupported", reflect.TypeOf(dest).Elem())
+}
+
+func asString(src interface{}) string {
+	switch v := src.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%v", src)
+}
+
+// convertLike converts a value read from the database to the type of like, the same way sql.Rows.Scan would. The value
+// is returned unchanged if either is NULL or it cannot be converted
+func convertLike(v interface{}, like interface{}) interface{} {
+	if v == nil || like == nil {
+		return v
+	}
+	dest := reflect.New(reflect.TypeOf(like))
+	if err := assignValue(dest.Interface(), v); err != nil {
+		return v
+	}
+	return dest.Elem().Interface()
+}
+
+func asBytes(src interface{}) []byte {
+	if b, ok := src.([]byte); ok {
+		return cloneBytes(b)
+	}
+	return []byte(asString(src))
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// compareValues orders two values read from the database. NULLs sort first, numbers and times compare by value, and
+// strings and byte slices compare byte by byte. Values of types that cannot be compared are considered equal
+// @param textAsNumbers compares text that holds a number numerically, for drivers that return numbers as text, such as
+// MySQL
+func compareValues(a, b interface{}, textAsNumbers bool) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+	if textAsNumbers {
+		a, b = textToNumber(a), textToNumber(b)
+	}
+	if c, ok := compareNumbers(a, b); ok {
+		return c
+	}
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			switch {
+			case at.Before(bt):
+				return -1
+			case at.After(bt):
+				return 1
+			}
+			return 0
+		}
+	}
+	as, bs := asString(a), asString(b)
+	switch {
+	case as < bs:
+		return -1
+	case as > bs:
+		return 1
+	}
+	return 0
+}
+
+// textToNumber returns text that holds a number as an int64, or a float64 if it is not a whole number that fits.
+// Other values are returned unchanged
+func textToNumber(v interface{}) interface{} {
+	var text string
+	switch t := v.(type) {
+	case []byte:
+		text = string(t)
+	case string:
+		text = t
+	default:
+		return v
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f
+	}
+	return v
+}
+
+// compareNumbers orders two numbers. Whole numbers are compared as integers so that values too large for a float64
+// to hold exactly, such as ids above 2^53, keep their order
+// @return ok is false if either value is not a number
+func compareNumbers(a, b interface{}) (c int, ok bool) {
+	ai, aInt := asInt(a)
+	bi, bInt := asInt(b)
+	if aInt && bInt {
+		switch {
+		case ai < bi:
+			return -1, true
+		case ai > bi:
+			return 1, true
+		}
+		return 0, true
+	}
+	// uint64 values above the int64 range are larger than any int64
+	au, aUint := a.(uint64)
+	bu, bUint := b.(uint64)
+	switch {
+	case aUint && bUint:
+		switch {
+		case au < bu:
+			return -1, true
+		case au > bu:
+			return 1, true
+		}
+		return 0, true
+	case aUint && bInt:
+		return 1, true
+	case aInt && bUint:
+		return -1, true
+	}
+	af, aFloat := asFloat(a)
+	bf, bFloat := asFloat(b)
+	if !aFloat || !bFloat {
+		return 0, false
+	}
+	switch {
+	case af < bf:
+		return -1, true
+	case af > bf:
+		return 1, true
+	}
+	return 0, true
+}
+
+// asInt returns the value as an int64 if it is a whole number that fits
+func asInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint8:
+		return int64(n), true
+	case uint64:
+		if n <= math.MaxInt64 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// asFloat returns the value as a float64 if it is a number
+func asFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	if i, ok := asInt(v); ok {
+		return float64(i), true
+	}
+	if u, ok := v.(uint64); ok {
+		return float64(u), true
+	}
+	return 0, false
+}
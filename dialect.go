@@ -0,0 +1,109 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"strings"
+)
+
+// Dialect selects the SQL flavor used by the statements this package generates
+type Dialect int
+
+const (
+	// GenericDialect uses ANSI SQL and conservative limits
+	GenericDialect Dialect = iota
+	MySQLDialect
+	PostgresDialect
+	SQLiteDialect
+	SQLServerDialect
+)
+
+// String returns the name of the dialect
+func (d Dialect) String() string {
+	switch d {
+	case MySQLDialect:
+		return "mysql"
+	case PostgresDialect:
+		return "postgres"
+	case SQLiteDialect:
+		return "sqlite"
+	case SQLServerDialect:
+		return "sqlserver"
+	default:
+		return "generic"
+	}
+}
+
+// QuoteIdentifier quotes a table or column name so it may be used in generated SQL. Names qualified with a schema,
+// such as "app.users", have each part quoted
+func (d Dialect) QuoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = d.quotePart(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+func (d Dialect) quotePart(part string) string {
+	switch d {
+	case MySQLDialect:
+		return "`" + strings.Replace(part, "`", "``", -1) + "`"
+	case SQLServerDialect:
+		return "[" + strings.Replace(part, "]", "]]", -1) + "]"
+	}
+	return `"` + strings.Replace(part, `"`, `""`, -1) + `"`
+}
+
+// MaxPlaceholders is the most parameters a single statement may have
+func (d Dialect) MaxPlaceholders() int {
+	switch d {
+	case MySQLDialect, PostgresDialect:
+		return 65535
+	case SQLiteDialect:
+		// SQLITE_MAX_VARIABLE_NUMBER since SQLite 3.32
+		return 32766
+	case SQLServerDialect:
+		// 2100 per request, less the statement and parameter list sp_executesql takes
+		return 2098
+	default:
+		return 999
+	}
+}
+
+// MaxValuesRows is the most rows a single INSERT ... VALUES list may have, zero if only the placeholder limit applies
+func (d Dialect) MaxValuesRows() int {
+	if d == SQLServerDialect {
+		return 1000
+	}
+	return 0
+}
+
+// SupportsTransactionalDDL is true if schema changes can be rolled back with the transaction they were made in. MySQL
+// commits implicitly before and after most DDL statements
+func (d Dialect) SupportsTransactionalDDL() bool {
+	return d == PostgresDialect || d == SQLiteDialect || d == SQLServerDialect
+}
+
+// firstInsertID converts the LastInsertId reported for a multi-row insert into the id of its first row
+func (d Dialect) firstInsertID(lastInsertID int64, rows int) int64 {
+	if d == SQLiteDialect {
+		// SQLite reports the id of the last row. Counting back assumes the rows were given consecutive ids, see
+		// BatchChunkResult.FirstInsertID
+		return lastInsertID - int64(rows) + 1
+	}
+	// MySQL reports the id of the first row
+	return lastInsertID
+}
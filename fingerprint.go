@@ -0,0 +1,236 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"hash/fnv"
+	"strings"
+)
+
+// Fingerprint is the identity of a query independent of its literal values, comments and formatting. Two queries that
+// only differ by the values they compare against or by how they were indented will have the same Fingerprint
+type Fingerprint struct {
+	// Hash is a short, stable identifier for the query, suitable for metric labels, log fields and cache keys
+	Hash string
+	// Normalized is the query with comments removed, whitespace collapsed, keywords lower-cased and all literals and placeholders replaced with "?"
+	Normalized string
+}
+
+// FingerprintSQL normalizes the query and hashes the result. Use this with vparam.Queryer.SQLQueryUnInterpolated()
+// so that the fingerprint does not depend on the placeholder style of the interpolation strategy
+func FingerprintSQL(sqlQuery string) Fingerprint {
+	normalized := normalizeSQL(sqlQuery)
+	h := fnv.New64a()
+	// hash.Hash never returns an error on Write
+	_, _ = h.Write([]byte(normalized))
+	return Fingerprint{
+		Hash:       fmt.Sprintf("%016x", h.Sum64()),
+		Normalized: normalized,
+	}
+}
+
+// FingerprintFromContext returns the fingerprint calculated by the middleware installed with InstallFingerprint
+// @return ok is false if the fingerprint middleware did not run for this call
+func FingerprintFromContext(ctx context.Context) (fp Fingerprint, ok bool) {
+	if ctx == nil {
+		return
+	}
+	fingerprinted, ok := ctx.Value(fingerprintContextKey).(fingerprintedQuery)
+	return fingerprinted.fp, ok
+}
+
+// fingerprintedQuery is the fingerprint placed into the context.Context, with the SQL it was calculated from
+type fingerprintedQuery struct {
+	sqlQuery string
+	fp       Fingerprint
+}
+
+// fingerprintOf returns the fingerprint of the query. The one placed into ctx by InstallFingerprint is only reused if
+// it was calculated from the same SQL: calls made with a context.Context handed down from another call, such as those
+// made by middleware, carry the fingerprint of that other call
+func fingerprintOf(ctx context.Context, sqlQuery string) Fingerprint {
+	if ctx != nil {
+		if fingerprinted, ok := ctx.Value(fingerprintContextKey).(fingerprintedQuery); ok && fingerprinted.sqlQuery == sqlQuery {
+			return fingerprinted.fp
+		}
+	}
+	return FingerprintSQL(sqlQuery)
+}
+
+// InstallFingerprint adds middleware that fingerprints every query, insert, exec and prepared statement and places
+// the Fingerprint into the context.Context passed to the rest of the middleware
+func InstallFingerprint(engine vsql_engine.SQLQueryer) {
+	engine.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		c.Next(withFingerprint(ctx, c.Query()))
+	})
+	engine.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		c.Next(withFingerprint(ctx, c.Query()))
+	})
+	engine.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		c.Next(withFingerprint(ctx, c.Query()))
+	})
+	engine.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		c.Next(withFingerprint(ctx, c.Query()))
+	})
+	engine.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		c.Next(withFingerprint(ctx, statementQuery(c.Statement())))
+	})
+	engine.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		c.Next(withFingerprint(ctx, statementQuery(c.Statement())))
+	})
+	engine.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		c.Next(withFingerprint(ctx, statementQuery(c.Statement())))
+	})
+}
+
+func withFingerprint(ctx context.Context, query vparam.Queryer) context.Context {
+	if query == nil {
+		return ctx
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sqlQ := query.SQLQueryUnInterpolated()
+	return context.WithValue(ctx, fingerprintContextKey, fingerprintedQuery{sqlQuery: sqlQ, fp: FingerprintSQL(sqlQ)})
+}
+
+// normalizeSQL renders the tokens of the query back into a canonical string
+func normalizeSQL(sqlQuery string) string {
+	tokens := withoutComments(tokenizeSQL(sqlQuery))
+	parts := make([]string, 0, len(tokens))
+	for i, t := range tokens {
+		switch t.kind {
+		case tokenWord:
+			parts = append(parts, strings.ToLower(t.text))
+		case tokenString, tokenNumber, tokenPlaceholder:
+			// a minus sign directly in front of a number is part of the literal when it follows an operator or starts the query
+			if t.kind == tokenNumber && isUnaryMinus(tokens, i-1) {
+				parts = parts[:len(parts)-1]
+			}
+			parts = append(parts, "?")
+		default:
+			parts = append(parts, t.text)
+		}
+	}
+	parts = collapseInLists(parts)
+	parts = collapseValuesTuples(parts)
+	return joinNormalized(parts)
+}
+
+func isUnaryMinus(tokens []sqlToken, i int) bool {
+	if i < 0 || tokens[i].kind != tokenPunct || tokens[i].text != "-" {
+		return false
+	}
+	return i == 0 || (tokens[i-1].kind == tokenPunct && tokens[i-1].text != ")")
+}
+
+// collapseInLists replaces "in ( ? , ? , ? )" with "in ( ?+ )" so lists of different lengths share a fingerprint
+func collapseInLists(parts []string) []string {
+	out := make([]string, 0, len(parts))
+	for i := 0; i < len(parts); i++ {
+		out = append(out, parts[i])
+		if parts[i] != "in" || i+2 >= len(parts) || parts[i+1] != "(" || parts[i+2] != "?" {
+			continue
+		}
+		j := i + 3
+		for j+1 < len(parts) && parts[j] == "," && parts[j+1] == "?" {
+			j += 2
+		}
+		if j < len(parts) && parts[j] == ")" {
+			out = append(out, "(", "?+", ")")
+			i = j
+		}
+	}
+	return out
+}
+
+// collapseValuesTuples replaces "values ( ? , ? ) , ( ? , ? )" with "values ( ? , ? )" so multi-row inserts share a fingerprint with single-row inserts
+func collapseValuesTuples(parts []string) []string {
+	out := make([]string, 0, len(parts))
+	for i := 0; i < len(parts); i++ {
+		out = append(out, parts[i])
+		if parts[i] != "values" && parts[i] != "value" {
+			continue
+		}
+		first := tupleEnd(parts, i+1)
+		if first < 0 {
+			continue
+		}
+		tuple := parts[i+1 : first+1]
+		out = append(out, tuple...)
+		i = first
+		for i+1 < len(parts) && parts[i+1] == "," {
+			next := tupleEnd(parts, i+2)
+			if next < 0 || !equalParts(tuple, parts[i+2:next+1]) {
+				break
+			}
+			i = next
+		}
+	}
+	return out
+}
+
+// tupleEnd returns the index of the parenthesis closing the tuple that opens at start, or -1 if there is no tuple
+func tupleEnd(parts []string, start int) int {
+	if start >= len(parts) || parts[start] != "(" {
+		return -1
+	}
+	depth := 0
+	for i := start; i < len(parts); i++ {
+		switch parts[i] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func equalParts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func joinNormalized(parts []string) string {
+	sb := strings.Builder{}
+	for i, p := range parts {
+		if i > 0 && !noSpaceBetween(parts[i-1], p) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(p)
+	}
+	return sb.String()
+}
+
+func noSpaceBetween(previous, current string) bool {
+	return previous == "(" || previous == "." || previous == "::" || current == ")" || current == "," || current == "." || current == "::" || current == ";"
+}
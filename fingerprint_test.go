@@ -0,0 +1,86 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"testing"
+)
+
+func TestFingerprintSQL_Normalized(t *testing.T) {
+	cases := map[string]struct {
+		input    string
+		expected string
+	}{
+		"collapses whitespace": {
+			input:    "SELECT  *\n\tFROM users\n WHERE id = ?",
+			expected: "select * from users where id = ?",
+		},
+		"strips comments": {
+			input:    "/* report */ SELECT id FROM users -- trailing\nWHERE id = 1",
+			expected: "select id from users where id = ?",
+		},
+		"replaces literals": {
+			input:    "SELECT id FROM users WHERE name = 'it''s' AND age > -21 AND score < 1.5e3",
+			expected: "select id from users where name = ? and age > ? and score < ?",
+		},
+		"replaces placeholder styles": {
+			input:    "SELECT id FROM users WHERE a = $1 AND b = :name AND c = ?",
+			expected: "select id from users where a = ? and b = ? and c = ?",
+		},
+		"keeps casts": {
+			input:    "SELECT id::text FROM users",
+			expected: "select id::text from users",
+		},
+		"collapses in-lists": {
+			input:    "SELECT id FROM users WHERE id IN (1, 2, 3)",
+			expected: "select id from users where id in (?+)",
+		},
+		"collapses values tuples": {
+			input:    "INSERT INTO users (a, b) VALUES (?, ?), (?, ?), (?, ?)",
+			expected: "insert into users (a, b) values (?, ?)",
+		},
+		"keeps quoted identifiers": {
+			input:    "SELECT `Name` FROM \"Users\"",
+			expected: "select `Name` from \"Users\"",
+		},
+		"dollar quoted strings": {
+			input:    "SELECT $$it's;here$$",
+			expected: "select ?",
+		},
+	}
+
+	for caseName, c := range cases {
+		actual := FingerprintSQL(c.input).Normalized
+		if actual != c.expected {
+			t.Errorf(`%s: expected "%s" but got "%s"`, caseName, c.expected, actual)
+		}
+	}
+}
+
+func TestFingerprintSQL_Hash(t *testing.T) {
+	a := FingerprintSQL("SELECT * FROM users WHERE id IN (1,2)")
+	b := FingerprintSQL("select *\nfrom users\nwhere id in (7, 8, 9)")
+	c := FingerprintSQL("SELECT * FROM accounts WHERE id IN (1,2)")
+	if a.Hash != b.Hash {
+		t.Errorf("expected equivalent queries to share a hash: %s != %s", a.Hash, b.Hash)
+	}
+	if a.Hash == c.Hash {
+		t.Error("expected different queries to have different hashes")
+	}
+	if len(a.Hash) != 16 {
+		t.Errorf("expected a 16 character hash but got %d", len(a.Hash))
+	}
+}
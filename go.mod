@@ -3,6 +3,7 @@ module github.com/wojnosystems/vsql_engine_go
 go 1.12
 
 require (
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/wojnosystems/vsql v0.0.13
 	github.com/wojnosystems/vsql_engine v0.0.13
 )
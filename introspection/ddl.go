@@ -0,0 +1,283 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package introspection
+
+import (
+	"fmt"
+	"github.com/wojnosystems/vsql_engine_go"
+	"strings"
+)
+
+// DDL renders the diff as the statements that would make the source schema match the target schema. The statements
+// are suggestions to be reviewed, not a migration to be run blindly: changes the dialect cannot make with ALTER TABLE,
+// such as changing a column in SQLite, are rendered as "--" comments that describe the change instead.
+//
+// Foreign keys are dropped first and added last so that tables may be created, dropped and changed in any order.
+// CHECK constraints are not read by the Inspector, so they are neither compared nor rendered
+// @param dialect is used to quote identifiers and pick the syntax. GenericDialect renders postgres-style statements
+func (d Diff) DDL(dialect vsql_engine_go.Dialect) (statements []string) {
+	r := ddlRenderer{dialect: dialect}
+	for _, td := range d.Tables {
+		if td.Change == Removed {
+			// the table may be referenced by, or reference, other removed tables, which are dropped in name order.
+			// SQLite drops the foreign keys along with the table
+			if dialect != vsql_engine_go.SQLiteDialect {
+				for _, fk := range td.Source.ForeignKeys {
+					r.dropForeignKey(td.Name, fk)
+				}
+			}
+			continue
+		}
+		for _, fk := range td.ForeignKeys {
+			if fk.Change != Added {
+				r.dropForeignKey(td.Name, *fk.Source)
+			}
+		}
+	}
+	for _, td := range d.Tables {
+		switch td.Change {
+		case Added:
+			r.createTable(td.Target)
+		case Removed:
+			r.add("DROP TABLE %s", r.quote(td.Name))
+		default:
+			r.alterTable(td)
+		}
+	}
+	for _, td := range d.Tables {
+		switch td.Change {
+		case Added:
+			if dialect != vsql_engine_go.SQLiteDialect {
+				for _, fk := range td.Target.ForeignKeys {
+					r.addForeignKey(td.Name, fk)
+				}
+			}
+		case Modified:
+			for _, fk := range td.ForeignKeys {
+				if fk.Change != Removed {
+					r.addForeignKey(td.Name, *fk.Target)
+				}
+			}
+		}
+	}
+	return r.statements
+}
+
+type ddlRenderer struct {
+	dialect    vsql_engine_go.Dialect
+	statements []string
+}
+
+func (r *ddlRenderer) add(format string, args ...interface{}) {
+	r.statements = append(r.statements, fmt.Sprintf(format, args...))
+}
+
+func (r *ddlRenderer) quote(name string) string {
+	return r.dialect.QuoteIdentifier(name)
+}
+
+func (r *ddlRenderer) quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = r.quote(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// createTable creates the table and its indexes. SQLite cannot add foreign keys later, so they are declared inline
+func (r *ddlRenderer) createTable(table Table) {
+	definitions := make([]string, 0, len(table.Columns)+1)
+	for _, column := range table.Columns {
+		definitions = append(definitions, r.columnDefinition(column))
+	}
+	if len(table.PrimaryKey) != 0 {
+		definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", r.quoteAll(table.PrimaryKey)))
+	}
+	if r.dialect == vsql_engine_go.SQLiteDialect {
+		for _, fk := range table.ForeignKeys {
+			definitions = append(definitions, r.foreignKeyDefinition(fk))
+		}
+	}
+	r.add("CREATE TABLE %s (\n\t%s\n)", r.quote(table.Name), strings.Join(definitions, ",\n\t"))
+	for _, index := range table.Indexes {
+		r.createIndex(table.Name, index)
+	}
+}
+
+// alterTable drops the removed and changed indexes before changing the columns, as the databases either drop the
+// indexes of a dropped column along with it, or refuse to drop or alter a column while an index uses it
+func (r *ddlRenderer) alterTable(td TableDiff) {
+	table := r.quote(td.Name)
+	for _, index := range td.Indexes {
+		if index.Change != Added {
+			r.dropIndex(td.Name, *index.Source)
+		}
+	}
+	for _, cd := range td.Columns {
+		switch cd.Change {
+		case Added:
+			if r.dialect == vsql_engine_go.SQLServerDialect {
+				r.add("ALTER TABLE %s ADD %s", table, r.columnDefinition(*cd.Target))
+			} else {
+				r.add("ALTER TABLE %s ADD COLUMN %s", table, r.columnDefinition(*cd.Target))
+			}
+		case Removed:
+			r.add("ALTER TABLE %s DROP COLUMN %s", table, r.quote(cd.Name))
+		default:
+			r.alterColumn(td.Name, cd)
+		}
+	}
+	if td.PrimaryKeyChanged {
+		r.alterPrimaryKey(td)
+	}
+	for _, index := range td.Indexes {
+		if index.Change != Removed {
+			r.createIndex(td.Name, *index.Target)
+		}
+	}
+}
+
+func (r *ddlRenderer) alterColumn(tableName string, cd ColumnDiff) {
+	table, column, target := r.quote(tableName), r.quote(cd.Name), *cd.Target
+	switch r.dialect {
+	case vsql_engine_go.SQLiteDialect:
+		r.add("-- SQLite cannot alter column %s.%s to %s, rebuild the table", tableName, cd.Name, r.columnDefinition(target))
+	case vsql_engine_go.MySQLDialect:
+		r.add("ALTER TABLE %s MODIFY COLUMN %s", table, r.columnDefinition(target))
+	case vsql_engine_go.SQLServerDialect:
+		if cd.TypeChanged || cd.NullableChanged {
+			r.add("ALTER TABLE %s ALTER COLUMN %s %s %s", table, column, target.Type, nullability(target.Nullable))
+		}
+		if cd.DefaultChanged {
+			r.add("-- SQL Server defaults are named constraints, replace the default of %s.%s with %s", tableName, cd.Name, defaultOrNone(target.Default))
+		}
+	default:
+		if cd.TypeChanged {
+			r.add("ALTER TABLE %s ALTER COLUMN %s TYPE %s", table, column, target.Type)
+		}
+		if cd.NullableChanged {
+			if target.Nullable {
+				r.add("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", table, column)
+			} else {
+				r.add("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table, column)
+			}
+		}
+		if cd.DefaultChanged {
+			if target.Default == nil {
+				r.add("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", table, column)
+			} else {
+				r.add("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s", table, column, *target.Default)
+			}
+		}
+	}
+}
+
+// alterPrimaryKey adds a primary key to a table that had none. Only MySQL can drop a primary key without knowing the
+// name of its constraint, so replacing one in any other dialect is rendered as a comment
+func (r *ddlRenderer) alterPrimaryKey(td TableDiff) {
+	table := r.quote(td.Name)
+	switch {
+	case r.dialect == vsql_engine_go.SQLiteDialect:
+		r.add("-- SQLite cannot change the primary key of %s to (%s), rebuild the table", td.Name, strings.Join(td.Target.PrimaryKey, ", "))
+	case r.dialect == vsql_engine_go.MySQLDialect && len(td.Source.PrimaryKey) != 0 && len(td.Target.PrimaryKey) != 0:
+		r.add("ALTER TABLE %s DROP PRIMARY KEY, ADD PRIMARY KEY (%s)", table, r.quoteAll(td.Target.PrimaryKey))
+	case r.dialect == vsql_engine_go.MySQLDialect && len(td.Target.PrimaryKey) == 0:
+		r.add("ALTER TABLE %s DROP PRIMARY KEY", table)
+	case len(td.Source.PrimaryKey) == 0:
+		r.add("ALTER TABLE %s ADD PRIMARY KEY (%s)", table, r.quoteAll(td.Target.PrimaryKey))
+	default:
+		r.add("-- change the primary key of %s from (%s) to (%s)", td.Name, strings.Join(td.Source.PrimaryKey, ", "), strings.Join(td.Target.PrimaryKey, ", "))
+	}
+}
+
+func (r *ddlRenderer) createIndex(tableName string, index Index) {
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
+	}
+	r.add("CREATE %sINDEX %s ON %s (%s)", unique, r.quote(index.Name), r.quote(tableName), r.quoteAll(index.Columns))
+}
+
+func (r *ddlRenderer) dropIndex(tableName string, index Index) {
+	switch r.dialect {
+	case vsql_engine_go.MySQLDialect, vsql_engine_go.SQLServerDialect:
+		r.add("DROP INDEX %s ON %s", r.quote(index.Name), r.quote(tableName))
+	default:
+		r.add("DROP INDEX %s", r.quote(index.Name))
+	}
+}
+
+func (r *ddlRenderer) addForeignKey(tableName string, fk ForeignKey) {
+	if r.dialect == vsql_engine_go.SQLiteDialect {
+		r.add("-- SQLite cannot add the foreign key %s to %s, rebuild the table", r.foreignKeyDefinition(fk), tableName)
+		return
+	}
+	r.add("ALTER TABLE %s ADD %s", r.quote(tableName), r.foreignKeyDefinition(fk))
+}
+
+func (r *ddlRenderer) dropForeignKey(tableName string, fk ForeignKey) {
+	switch {
+	case r.dialect == vsql_engine_go.SQLiteDialect || fk.Name == "":
+		r.add("-- drop the foreign key %s from %s, rebuild the table", r.foreignKeyDefinition(fk), tableName)
+	case r.dialect == vsql_engine_go.MySQLDialect:
+		r.add("ALTER TABLE %s DROP FOREIGN KEY %s", r.quote(tableName), r.quote(fk.Name))
+	default:
+		r.add("ALTER TABLE %s DROP CONSTRAINT %s", r.quote(tableName), r.quote(fk.Name))
+	}
+}
+
+func (r *ddlRenderer) columnDefinition(column Column) string {
+	definition := r.quote(column.Name) + " " + column.Type
+	if !column.Nullable {
+		definition += " NOT NULL"
+	}
+	if column.Default != nil {
+		definition += " DEFAULT " + *column.Default
+	}
+	return definition
+}
+
+func (r *ddlRenderer) foreignKeyDefinition(fk ForeignKey) string {
+	definition := ""
+	if fk.Name != "" {
+		definition = "CONSTRAINT " + r.quote(fk.Name) + " "
+	}
+	definition += fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s", r.quoteAll(fk.Columns), r.quote(fk.ReferencedTable))
+	if len(fk.ReferencedColumns) != 0 {
+		definition += " (" + r.quoteAll(fk.ReferencedColumns) + ")"
+	}
+	if fk.OnUpdate != "" {
+		definition += " ON UPDATE " + fk.OnUpdate
+	}
+	if fk.OnDelete != "" {
+		definition += " ON DELETE " + fk.OnDelete
+	}
+	return definition
+}
+
+func nullability(nullable bool) string {
+	if nullable {
+		return "NULL"
+	}
+	return "NOT NULL"
+}
+
+func defaultOrNone(def *string) string {
+	if def == nil {
+		return "no default"
+	}
+	return *def
+}
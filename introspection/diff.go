@@ -0,0 +1,276 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package introspection
+
+import (
+	"context"
+	"database/sql"
+	"github.com/wojnosystems/vsql_engine_go"
+	"sort"
+	"strings"
+)
+
+// Change is how an object differs between the two schemas
+type Change int
+
+const (
+	// Added objects are only in the target schema
+	Added Change = iota
+	// Removed objects are only in the source schema
+	Removed
+	// Modified objects are in both, but differ
+	Modified
+)
+
+// String returns the name of the change
+func (c Change) String() string {
+	switch c {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	default:
+		return "modified"
+	}
+}
+
+// Diff is what would have to change for the source schema to match the target schema. It covers the columns, primary
+// keys, indexes and foreign keys read by the Inspector. CHECK constraints are out of scope
+type Diff struct {
+	// Tables that differ, ordered by name
+	Tables []TableDiff
+}
+
+// Empty is true if the schemas match
+func (d Diff) Empty() bool {
+	return len(d.Tables) == 0
+}
+
+// TableDiff is how a single table differs
+type TableDiff struct {
+	Name   string
+	Change Change
+	// Source is the table in the source schema, the zero value if Added
+	Source Table
+	// Target is the table in the target schema, the zero value if Removed
+	Target Table
+	// The differences of a Modified table
+	Columns           []ColumnDiff
+	PrimaryKeyChanged bool
+	Indexes           []IndexDiff
+	ForeignKeys       []ForeignKeyDiff
+}
+
+// ColumnDiff is how a single column differs
+type ColumnDiff struct {
+	Name   string
+	Change Change
+	// Source is nil if Added, Target is nil if Removed
+	Source *Column
+	Target *Column
+	// TypeChanged, NullableChanged and DefaultChanged tell what differs in a Modified column
+	TypeChanged     bool
+	NullableChanged bool
+	DefaultChanged  bool
+}
+
+// IndexDiff is how a single index differs, indexes are matched by name
+type IndexDiff struct {
+	Name   string
+	Change Change
+	Source *Index
+	Target *Index
+}
+
+// ForeignKeyDiff is how a single foreign key differs. Foreign keys are matched by name, or by their columns and
+// referenced table when they are not named, so an unnamed foreign key is never Modified, only Removed and Added
+type ForeignKeyDiff struct {
+	Change Change
+	Source *ForeignKey
+	Target *ForeignKey
+}
+
+// DiffDatabases inspects both databases and compares their schemas
+// @param source is the database that would be changed, such as production
+// @param target is the database with the wanted schema, such as staging
+func DiffDatabases(ctx context.Context, source, target *sql.DB, dialect vsql_engine_go.Dialect) (diff Diff, err error) {
+	schemas := make([]Schema, 2)
+	for i, db := range []*sql.DB{source, target} {
+		inspector, err := NewFromDB(db, dialect)
+		if err != nil {
+			return diff, err
+		}
+		if schemas[i], err = inspector.Schema(ctx); err != nil {
+			return diff, err
+		}
+	}
+	return Compare(schemas[0], schemas[1]), nil
+}
+
+// Compare returns what would have to change for source to match target
+func Compare(source, target Schema) (diff Diff) {
+	for _, name := range tableNames(source, target) {
+		s, inSource := source.Table(name)
+		t, inTarget := target.Table(name)
+		switch {
+		case !inSource:
+			diff.Tables = append(diff.Tables, TableDiff{Name: name, Change: Added, Target: t})
+		case !inTarget:
+			diff.Tables = append(diff.Tables, TableDiff{Name: name, Change: Removed, Source: s})
+		default:
+			if td := compareTables(s, t); td.Columns != nil || td.PrimaryKeyChanged || td.Indexes != nil || td.ForeignKeys != nil {
+				diff.Tables = append(diff.Tables, td)
+			}
+		}
+	}
+	return
+}
+
+func tableNames(schemas ...Schema) []string {
+	seen := make(map[string]struct{})
+	var names []string
+	for _, schema := range schemas {
+		for _, table := range schema.Tables {
+			if _, ok := seen[table.Name]; !ok {
+				seen[table.Name] = struct{}{}
+				names = append(names, table.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func compareTables(source, target Table) TableDiff {
+	td := TableDiff{Name: source.Name, Change: Modified, Source: source, Target: target}
+
+	for i := range source.Columns {
+		s := &source.Columns[i]
+		t, ok := target.Column(s.Name)
+		if !ok {
+			td.Columns = append(td.Columns, ColumnDiff{Name: s.Name, Change: Removed, Source: s})
+			continue
+		}
+		cd := ColumnDiff{
+			Name:            s.Name,
+			Change:          Modified,
+			Source:          s,
+			Target:          &t,
+			TypeChanged:     s.Type != t.Type,
+			NullableChanged: s.Nullable != t.Nullable,
+			DefaultChanged:  !equalDefaults(s.Default, t.Default),
+		}
+		if cd.TypeChanged || cd.NullableChanged || cd.DefaultChanged {
+			td.Columns = append(td.Columns, cd)
+		}
+	}
+	for i := range target.Columns {
+		t := &target.Columns[i]
+		if _, ok := source.Column(t.Name); !ok {
+			td.Columns = append(td.Columns, ColumnDiff{Name: t.Name, Change: Added, Target: t})
+		}
+	}
+
+	td.PrimaryKeyChanged = !equalStrings(source.PrimaryKey, target.PrimaryKey)
+
+	sourceIndexes, sourceNames := indexesByName(source.Indexes)
+	targetIndexes, targetNames := indexesByName(target.Indexes)
+	for _, name := range sortedUnion(sourceNames, targetNames) {
+		s, t := sourceIndexes[name], targetIndexes[name]
+		switch {
+		case s == nil:
+			td.Indexes = append(td.Indexes, IndexDiff{Name: name, Change: Added, Target: t})
+		case t == nil:
+			td.Indexes = append(td.Indexes, IndexDiff{Name: name, Change: Removed, Source: s})
+		case s.Unique != t.Unique || !equalStrings(s.Columns, t.Columns):
+			td.Indexes = append(td.Indexes, IndexDiff{Name: name, Change: Modified, Source: s, Target: t})
+		}
+	}
+
+	sourceKeys, sourceIDs := foreignKeysByID(source.ForeignKeys)
+	targetKeys, targetIDs := foreignKeysByID(target.ForeignKeys)
+	for _, id := range sortedUnion(sourceIDs, targetIDs) {
+		s, t := sourceKeys[id], targetKeys[id]
+		switch {
+		case s == nil:
+			td.ForeignKeys = append(td.ForeignKeys, ForeignKeyDiff{Change: Added, Target: t})
+		case t == nil:
+			td.ForeignKeys = append(td.ForeignKeys, ForeignKeyDiff{Change: Removed, Source: s})
+		case !equalForeignKeys(*s, *t):
+			td.ForeignKeys = append(td.ForeignKeys, ForeignKeyDiff{Change: Modified, Source: s, Target: t})
+		}
+	}
+	return td
+}
+
+func equalDefaults(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalForeignKeys(a, b ForeignKey) bool {
+	return a.ReferencedTable == b.ReferencedTable && equalStrings(a.Columns, b.Columns) &&
+		equalStrings(a.ReferencedColumns, b.ReferencedColumns) && a.OnUpdate == b.OnUpdate && a.OnDelete == b.OnDelete
+}
+
+func indexesByName(indexes []Index) (byName map[string]*Index, names []string) {
+	byName = make(map[string]*Index, len(indexes))
+	for i := range indexes {
+		byName[indexes[i].Name] = &indexes[i]
+		names = append(names, indexes[i].Name)
+	}
+	return
+}
+
+func foreignKeysByID(keys []ForeignKey) (byID map[string]*ForeignKey, ids []string) {
+	byID = make(map[string]*ForeignKey, len(keys))
+	for i := range keys {
+		fk := &keys[i]
+		id := fk.Name
+		if id == "" {
+			id = strings.Join(fk.Columns, ",") + "->" + fk.ReferencedTable
+		}
+		byID[id] = fk
+		ids = append(ids, id)
+	}
+	return
+}
+
+func sortedUnion(a, b []string) (union []string) {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	for _, s := range append(append([]string{}, a...), b...) {
+		if _, ok := seen[s]; !ok {
+			seen[s] = struct{}{}
+			union = append(union, s)
+		}
+	}
+	sort.Strings(union)
+	return
+}
@@ -0,0 +1,165 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package introspection
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql_engine_go"
+	"reflect"
+	"testing"
+)
+
+const targetSchema = `
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY,
+	email VARCHAR(320) NOT NULL UNIQUE,
+	status TEXT NOT NULL DEFAULT 'pending',
+	name TEXT
+);
+CREATE TABLE memberships (
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	group_id INTEGER NOT NULL,
+	PRIMARY KEY (group_id, user_id)
+);
+CREATE UNIQUE INDEX memberships_user ON memberships (user_id, group_id);
+CREATE TABLE groups (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL
+);
+`
+
+func TestDiffDatabases_SQLite(t *testing.T) {
+	source, target := newSQLiteDB(t, testSchema), newSQLiteDB(t, targetSchema)
+	defer func() { _ = source.Close() }()
+	defer func() { _ = target.Close() }()
+
+	diff, err := DiffDatabases(context.Background(), source, target, vsql_engine_go.SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Tables) != 3 {
+		t.Fatalf("expected 3 changed tables, got: %+v", diff.Tables)
+	}
+	groups, memberships, users := diff.Tables[0], diff.Tables[1], diff.Tables[2]
+	if groups.Name != "groups" || groups.Change != Added || len(groups.Target.Columns) != 2 {
+		t.Errorf("expected groups to be added: %+v", groups)
+	}
+
+	if memberships.Change != Modified || memberships.PrimaryKeyChanged || len(memberships.ForeignKeys) != 0 {
+		t.Errorf("expected only the columns and indexes of memberships to change: %+v", memberships)
+	}
+	if len(memberships.Columns) != 1 || memberships.Columns[0].Name != "joined_at" || memberships.Columns[0].Change != Removed {
+		t.Errorf("expected joined_at to be removed: %+v", memberships.Columns)
+	}
+	if len(memberships.Indexes) != 2 || memberships.Indexes[0].Name != "memberships_joined" || memberships.Indexes[0].Change != Removed ||
+		memberships.Indexes[1].Name != "memberships_user" || memberships.Indexes[1].Change != Added {
+		t.Errorf("expected one index to be replaced by another: %+v", memberships.Indexes)
+	}
+
+	changes := make(map[string]ColumnDiff)
+	for _, cd := range users.Columns {
+		changes[cd.Name] = cd
+	}
+	if email := changes["email"]; email.Change != Modified || !email.TypeChanged || email.NullableChanged || email.DefaultChanged {
+		t.Errorf("expected only the type of email to change: %+v", email)
+	}
+	if status := changes["status"]; !status.NullableChanged || !status.DefaultChanged || status.TypeChanged {
+		t.Errorf("expected the nullability and default of status to change: %+v", status)
+	}
+	if name := changes["name"]; name.Change != Added || name.Target == nil {
+		t.Errorf("expected name to be added: %+v", name)
+	}
+	if len(changes) != 3 {
+		t.Errorf("expected id to be unchanged: %+v", users.Columns)
+	}
+}
+
+func TestCompare_Identical(t *testing.T) {
+	db := newSQLiteDB(t, testSchema)
+	defer func() { _ = db.Close() }()
+	diff, err := DiffDatabases(context.Background(), db, db, vsql_engine_go.SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() || len(diff.DDL(vsql_engine_go.SQLiteDialect)) != 0 {
+		t.Errorf("expected no differences: %+v", diff)
+	}
+}
+
+func TestDiff_DDL(t *testing.T) {
+	def := "0"
+	source := Schema{Tables: []Table{
+		{Name: "orders", Columns: []Column{{Name: "id", Type: "integer"}, {Name: "total", Type: "integer", Nullable: true}, {Name: "note", Type: "text", Nullable: true}},
+			PrimaryKey:  []string{"id"},
+			Indexes:     []Index{{Name: "orders_note", Columns: []string{"note"}}},
+			ForeignKeys: []ForeignKey{{Name: "orders_customer", Columns: []string{"customer_id"}, ReferencedTable: "customers", ReferencedColumns: []string{"id"}}}},
+		{Name: "legacy", Columns: []Column{{Name: "id", Type: "integer"}}},
+	}}
+	target := Schema{Tables: []Table{
+		{Name: "customers", Columns: []Column{{Name: "id", Type: "integer"}}, PrimaryKey: []string{"id"}},
+		{Name: "orders", Columns: []Column{{Name: "id", Type: "integer"}, {Name: "total", Type: "bigint", Default: &def}, {Name: "customer_id", Type: "integer"}},
+			PrimaryKey:  []string{"id"},
+			Indexes:     []Index{{Name: "orders_customer_id", Columns: []string{"customer_id"}}},
+			ForeignKeys: []ForeignKey{{Name: "orders_customer", Columns: []string{"customer_id"}, ReferencedTable: "customers", ReferencedColumns: []string{"id"}, OnDelete: "CASCADE"}}},
+	}}
+	diff := Compare(source, target)
+
+	expected := []string{
+		`ALTER TABLE "orders" DROP CONSTRAINT "orders_customer"`,
+		"CREATE TABLE \"customers\" (\n\t\"id\" integer NOT NULL,\n\tPRIMARY KEY (\"id\")\n)",
+		`DROP TABLE "legacy"`,
+		// the index is dropped before its column, which would drop it too
+		`DROP INDEX "orders_note"`,
+		`ALTER TABLE "orders" ALTER COLUMN "total" TYPE bigint`,
+		`ALTER TABLE "orders" ALTER COLUMN "total" SET NOT NULL`,
+		`ALTER TABLE "orders" ALTER COLUMN "total" SET DEFAULT 0`,
+		`ALTER TABLE "orders" DROP COLUMN "note"`,
+		`ALTER TABLE "orders" ADD COLUMN "customer_id" integer NOT NULL`,
+		`CREATE INDEX "orders_customer_id" ON "orders" ("customer_id")`,
+		`ALTER TABLE "orders" ADD CONSTRAINT "orders_customer" FOREIGN KEY ("customer_id") REFERENCES "customers" ("id") ON DELETE CASCADE`,
+	}
+	if actual := diff.DDL(vsql_engine_go.PostgresDialect); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected postgres DDL:\n%q\n%q", expected, actual)
+	}
+
+	mysql := diff.DDL(vsql_engine_go.MySQLDialect)
+	if mysql[0] != "ALTER TABLE `orders` DROP FOREIGN KEY `orders_customer`" ||
+		mysql[4] != "ALTER TABLE `orders` MODIFY COLUMN `total` bigint NOT NULL DEFAULT 0" {
+		t.Errorf("unexpected mysql DDL: %q", mysql)
+	}
+}
+
+func TestDiff_DDLDropsReferencesOfRemovedTables(t *testing.T) {
+	source := Schema{Tables: []Table{
+		{Name: "a_parents", Columns: []Column{{Name: "id", Type: "integer"}}, PrimaryKey: []string{"id"}},
+		{Name: "b_children", Columns: []Column{{Name: "parent_id", Type: "integer"}},
+			ForeignKeys: []ForeignKey{{Name: "children_parent", Columns: []string{"parent_id"}, ReferencedTable: "a_parents"}}},
+	}}
+	expected := []string{
+		`ALTER TABLE "b_children" DROP CONSTRAINT "children_parent"`,
+		`DROP TABLE "a_parents"`,
+		`DROP TABLE "b_children"`,
+	}
+	if actual := Compare(source, Schema{}).DDL(vsql_engine_go.PostgresDialect); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected DDL:\n%q\n%q", expected, actual)
+	}
+
+	// the reference has no columns, it is to the primary key
+	added := Compare(Schema{}, source).DDL(vsql_engine_go.PostgresDialect)
+	if last := added[len(added)-1]; last != `ALTER TABLE "b_children" ADD CONSTRAINT "children_parent" FOREIGN KEY ("parent_id") REFERENCES "a_parents"` {
+		t.Errorf("unexpected foreign key: %s", last)
+	}
+}
@@ -0,0 +1,185 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package introspection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/wojnosystems/vsql/vquery"
+	"github.com/wojnosystems/vsql/vrows"
+	"strings"
+)
+
+// mysqlCatalog reads information_schema for the tables of the current database
+type mysqlCatalog struct {
+}
+
+func (mysqlCatalog) tables(ctx context.Context, q vquery.Queryer) ([]string, error) {
+	return informationSchemaTables(ctx, q, "DATABASE()")
+}
+
+func (mysqlCatalog) table(ctx context.Context, q vquery.Queryer, name string) (table Table, err error) {
+	table.Name = name
+	// COLUMN_TYPE has the length and unsigned-ness that DATA_TYPE leaves out
+	table.Columns, err = informationSchemaColumns(ctx, q, "DATABASE()", "COLUMN_TYPE", name)
+	if err != nil {
+		return
+	}
+	builder := indexBuilder{}
+	err = each(ctx, q, `SELECT INDEX_NAME, NON_UNIQUE, COLUMN_NAME FROM information_schema.STATISTICS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+ORDER BY INDEX_NAME, SEQ_IN_INDEX`, []interface{}{name}, func(row vrows.Rower) error {
+		var index string
+		var nonUnique bool
+		var column sql.NullString
+		if err := row.Scan(&index, &nonUnique, &column); err != nil {
+			return err
+		}
+		if index == "PRIMARY" {
+			table.PrimaryKey = append(table.PrimaryKey, column.String)
+			return nil
+		}
+		// functional indexes have no column name
+		builder.add(index, !nonUnique, column.String)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	table.Indexes = builder.indexes
+	fks := foreignKeyBuilder{}
+	// MySQL names every primary key PRIMARY, so the referenced columns are read from its own KEY_COLUMN_USAGE columns
+	err = each(ctx, q, `SELECT k.CONSTRAINT_NAME, k.REFERENCED_TABLE_NAME, k.COLUMN_NAME, k.REFERENCED_COLUMN_NAME, rc.UPDATE_RULE, rc.DELETE_RULE
+FROM information_schema.KEY_COLUMN_USAGE k
+JOIN information_schema.REFERENTIAL_CONSTRAINTS rc ON rc.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA AND rc.CONSTRAINT_NAME = k.CONSTRAINT_NAME AND rc.TABLE_NAME = k.TABLE_NAME
+WHERE k.TABLE_SCHEMA = DATABASE() AND k.TABLE_NAME = ? AND k.REFERENCED_TABLE_NAME IS NOT NULL
+ORDER BY k.CONSTRAINT_NAME, k.ORDINAL_POSITION`, []interface{}{name}, func(row vrows.Rower) error {
+		var fk ForeignKey
+		var column, referenced string
+		if err := row.Scan(&fk.Name, &fk.ReferencedTable, &column, &referenced, &fk.OnUpdate, &fk.OnDelete); err != nil {
+			return err
+		}
+		fks.add(fk.Name, fk, column, referenced)
+		return nil
+	})
+	table.ForeignKeys = fks.keys
+	return
+}
+
+// sqlServerCatalog reads information_schema for the tables of the default schema, and sys.indexes for the indexes
+type sqlServerCatalog struct {
+}
+
+func (sqlServerCatalog) tables(ctx context.Context, q vquery.Queryer) ([]string, error) {
+	return informationSchemaTables(ctx, q, "SCHEMA_NAME()")
+}
+
+func (sqlServerCatalog) table(ctx context.Context, q vquery.Queryer, name string) (table Table, err error) {
+	table.Name = name
+	table.Columns, err = informationSchemaColumns(ctx, q, "SCHEMA_NAME()", sqlServerColumnType, name)
+	if err != nil {
+		return
+	}
+	builder := indexBuilder{}
+	err = each(ctx, q, `SELECT i.name, i.is_unique, i.is_primary_key, c.name
+FROM sys.indexes i
+JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+WHERE i.object_id = OBJECT_ID(QUOTENAME(SCHEMA_NAME()) + '.' + QUOTENAME(?)) AND ic.is_included_column = 0
+ORDER BY i.name, ic.key_ordinal`, []interface{}{name}, func(row vrows.Rower) error {
+		var index, column string
+		var unique, primary bool
+		if err := row.Scan(&index, &unique, &primary, &column); err != nil {
+			return err
+		}
+		if primary {
+			table.PrimaryKey = append(table.PrimaryKey, column)
+			return nil
+		}
+		builder.add(index, unique, column)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	table.Indexes = builder.indexes
+	table.ForeignKeys, err = informationSchemaForeignKeys(ctx, q, "SCHEMA_NAME()", name)
+	return
+}
+
+// sqlServerColumnType adds the length, or the precision and scale, that DATA_TYPE leaves out
+const sqlServerColumnType = `LOWER(DATA_TYPE) + CASE
+WHEN CHARACTER_MAXIMUM_LENGTH = -1 THEN '(max)'
+WHEN CHARACTER_MAXIMUM_LENGTH IS NOT NULL THEN '(' + CAST(CHARACTER_MAXIMUM_LENGTH AS VARCHAR(10)) + ')'
+WHEN DATA_TYPE IN ('decimal', 'numeric') THEN '(' + CAST(NUMERIC_PRECISION AS VARCHAR(10)) + ',' + CAST(NUMERIC_SCALE AS VARCHAR(10)) + ')'
+ELSE '' END`
+
+// informationSchemaTables returns the base tables of the schema
+// @param schema is the SQL expression of the schema, such as DATABASE()
+func informationSchemaTables(ctx context.Context, q vquery.Queryer, schema string) (names []string, err error) {
+	err = each(ctx, q, fmt.Sprintf(`SELECT TABLE_NAME FROM information_schema.TABLES
+WHERE TABLE_SCHEMA = %s AND TABLE_TYPE = 'BASE TABLE'`, schema), nil, func(row vrows.Rower) error {
+		var name string
+		names = append(names, name)
+		return row.Scan(&names[len(names)-1])
+	})
+	return
+}
+
+// informationSchemaColumns returns the columns of the table
+// @param columnType is the SQL expression of the column's type
+func informationSchemaColumns(ctx context.Context, q vquery.Queryer, schema, columnType, table string) (columns []Column, err error) {
+	err = each(ctx, q, fmt.Sprintf(`SELECT COLUMN_NAME, %s, IS_NULLABLE, COLUMN_DEFAULT FROM information_schema.COLUMNS
+WHERE TABLE_SCHEMA = %s AND TABLE_NAME = ?
+ORDER BY ORDINAL_POSITION`, columnType, schema), []interface{}{table}, func(row vrows.Rower) error {
+		var c Column
+		var nullable string
+		var def sql.NullString
+		if err := row.Scan(&c.Name, &c.Type, &nullable, &def); err != nil {
+			return err
+		}
+		c.Type = strings.ToLower(c.Type)
+		c.Nullable = nullable == "YES"
+		c.Default = nullableString(def)
+		columns = append(columns, c)
+		return nil
+	})
+	return
+}
+
+// informationSchemaForeignKeys pairs each column of a foreign key with the column of the unique constraint it
+// references. This relies on constraint names being unique within a schema, as the standard requires
+// but MySQL does not
+func informationSchemaForeignKeys(ctx context.Context, q vquery.Queryer, schema, table string) (keys []ForeignKey, err error) {
+	fks := foreignKeyBuilder{}
+	err = each(ctx, q, fmt.Sprintf(`SELECT rc.CONSTRAINT_NAME, ref.TABLE_NAME, k.COLUMN_NAME, ref.COLUMN_NAME, rc.UPDATE_RULE, rc.DELETE_RULE
+FROM information_schema.REFERENTIAL_CONSTRAINTS rc
+JOIN information_schema.KEY_COLUMN_USAGE k ON k.CONSTRAINT_SCHEMA = rc.CONSTRAINT_SCHEMA AND k.CONSTRAINT_NAME = rc.CONSTRAINT_NAME
+JOIN information_schema.KEY_COLUMN_USAGE ref ON ref.CONSTRAINT_SCHEMA = rc.UNIQUE_CONSTRAINT_SCHEMA AND ref.CONSTRAINT_NAME = rc.UNIQUE_CONSTRAINT_NAME
+  AND ref.ORDINAL_POSITION = k.POSITION_IN_UNIQUE_CONSTRAINT
+WHERE rc.CONSTRAINT_SCHEMA = %s AND k.TABLE_NAME = ?
+ORDER BY rc.CONSTRAINT_NAME, k.ORDINAL_POSITION`, schema), []interface{}{table}, func(row vrows.Rower) error {
+		var fk ForeignKey
+		var column, referenced string
+		if err := row.Scan(&fk.Name, &fk.ReferencedTable, &column, &referenced, &fk.OnUpdate, &fk.OnDelete); err != nil {
+			return err
+		}
+		fks.add(fk.Name, fk, column, referenced)
+		return nil
+	})
+	return fks.keys, err
+}
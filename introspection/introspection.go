@@ -0,0 +1,289 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package introspection reads the tables, columns, indexes and foreign keys of a database into a model that is the
+// same for every dialect
+package introspection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql/vquery"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine_go"
+	"sort"
+	"strings"
+)
+
+// Schema is every table of a database, ordered by name
+type Schema struct {
+	Tables []Table
+}
+
+// Table returns the table with the name
+func (s Schema) Table(name string) (table Table, ok bool) {
+	for _, t := range s.Tables {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return
+}
+
+// Table is the structure of a single table. CHECK constraints are not read
+type Table struct {
+	Name string
+	// Columns are in the order they were defined
+	Columns []Column
+	// PrimaryKey are the columns of the primary key, in key order. Empty if the table has none
+	PrimaryKey []string
+	// Indexes are the indexes other than the primary key, ordered by name
+	Indexes []Index
+	// ForeignKeys are ordered by name, then by referenced table
+	ForeignKeys []ForeignKey
+}
+
+// Column returns the column with the name
+func (t Table) Column(name string) (column Column, ok bool) {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return
+}
+
+// Column is a single column of a table
+type Column struct {
+	Name string
+	// Type is the type as the database reports it, lower-cased, such as "varchar(255)" or "integer"
+	Type     string
+	Nullable bool
+	// Default is the default expression as the database reports it, nil if the column has no default
+	Default *string
+}
+
+// Index is an index or a unique constraint
+type Index struct {
+	// Name may be generated by the database, such as SQLite's sqlite_autoindex_ names for unique constraints
+	Name string
+	// Columns are in index order
+	Columns []string
+	Unique  bool
+}
+
+// ForeignKey is a reference from columns of one table to columns of another
+type ForeignKey struct {
+	// Name is empty for SQLite, which does not name foreign keys
+	Name            string
+	Columns         []string
+	ReferencedTable string
+	// ReferencedColumns is empty when SQLite references the primary key of ReferencedTable without naming its columns
+	ReferencedColumns []string
+	// OnUpdate and OnDelete are the referential actions in upper-case, such as "CASCADE" or "NO ACTION"
+	OnUpdate string
+	OnDelete string
+}
+
+// Inspector reads the schema of a database
+type Inspector struct {
+	q       vquery.Queryer
+	catalog catalog
+}
+
+// catalog queries the system tables of a dialect
+type catalog interface {
+	tables(ctx context.Context, q vquery.Queryer) ([]string, error)
+	table(ctx context.Context, q vquery.Queryer, name string) (Table, error)
+}
+
+// New creates an Inspector that queries through an engine, or a transaction of it
+// @param q must render placeholders for the dialect, as the engine's interpolation strategy does
+// @param dialect selects the system tables: sqlite_master for SQLite, pg_catalog for postgres and information_schema
+// for MySQL and SQL Server. GenericDialect is not supported
+func New(q vquery.Queryer, dialect vsql_engine_go.Dialect) (*Inspector, error) {
+	var c catalog
+	switch dialect {
+	case vsql_engine_go.SQLiteDialect:
+		c = sqliteCatalog{}
+	case vsql_engine_go.PostgresDialect:
+		c = postgresCatalog{}
+	case vsql_engine_go.MySQLDialect:
+		c = mysqlCatalog{}
+	case vsql_engine_go.SQLServerDialect:
+		c = sqlServerCatalog{}
+	default:
+		return nil, fmt.Errorf("introspection is not supported by the %s dialect", dialect)
+	}
+	return &Inspector{q: q, catalog: c}, nil
+}
+
+// NewFromDB creates an Inspector for the database handle given to InstallSingle. It queries through a private engine
+// with only the database middleware installed, using the dialect's placeholders
+func NewFromDB(db *sql.DB, dialect vsql_engine_go.Dialect) (*Inspector, error) {
+	engine := vsql_engine.NewSingle()
+	vsql_engine_go.InstallSingle(engine, db, placeholders(dialect))
+	return New(engine, dialect)
+}
+
+// Schema reads every table
+func (i *Inspector) Schema(ctx context.Context) (schema Schema, err error) {
+	names, err := i.Tables(ctx)
+	if err != nil {
+		return schema, err
+	}
+	for _, name := range names {
+		table, err := i.Table(ctx, name)
+		if err != nil {
+			return schema, err
+		}
+		schema.Tables = append(schema.Tables, table)
+	}
+	return schema, nil
+}
+
+// Tables returns the names of the tables, ordered by name. Views and system tables are not included
+func (i *Inspector) Tables(ctx context.Context) ([]string, error) {
+	names, err := i.catalog.tables(ctx, i.q)
+	sort.Strings(names)
+	return names, err
+}
+
+// Table reads a single table
+func (i *Inspector) Table(ctx context.Context, name string) (table Table, err error) {
+	table, err = i.catalog.table(ctx, i.q, name)
+	if err != nil {
+		return table, fmt.Errorf("table %s: %v", name, err)
+	}
+	if len(table.Columns) == 0 {
+		return table, fmt.Errorf("table %s does not exist", name)
+	}
+	sort.Slice(table.Indexes, func(a, b int) bool {
+		return table.Indexes[a].Name < table.Indexes[b].Name
+	})
+	sort.SliceStable(table.ForeignKeys, func(a, b int) bool {
+		fa, fb := table.ForeignKeys[a], table.ForeignKeys[b]
+		if fa.Name != fb.Name {
+			return fa.Name < fb.Name
+		}
+		return fa.ReferencedTable < fb.ReferencedTable
+	})
+	return table, nil
+}
+
+// each runs the query and calls scan for every row
+func each(ctx context.Context, q vquery.Queryer, sqlQ string, params []interface{}, scan func(row vrows.Rower) error) (err error) {
+	rows, err := q.Query(ctx, vsql_engine_go.NewPositionalQuery(sqlQ, params...))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := rows.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	for row := rows.Next(); row != nil; row = rows.Next() {
+		if err = scan(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexBuilder groups the rows of an index query, one row per column, into indexes in the order they were first seen
+type indexBuilder struct {
+	indexes []Index
+	byName  map[string]int
+}
+
+func (b *indexBuilder) add(name string, unique bool, column string) {
+	if b.byName == nil {
+		b.byName = make(map[string]int)
+	}
+	i, ok := b.byName[name]
+	if !ok {
+		i = len(b.indexes)
+		b.byName[name] = i
+		b.indexes = append(b.indexes, Index{Name: name, Unique: unique})
+	}
+	b.indexes[i].Columns = append(b.indexes[i].Columns, column)
+}
+
+// foreignKeyBuilder groups the rows of a foreign key query, one row per column pair
+type foreignKeyBuilder struct {
+	keys []ForeignKey
+	byID map[string]int
+}
+
+func (b *foreignKeyBuilder) add(id string, fk ForeignKey, column, referencedColumn string) {
+	if b.byID == nil {
+		b.byID = make(map[string]int)
+	}
+	i, ok := b.byID[id]
+	if !ok {
+		i = len(b.keys)
+		b.byID[id] = i
+		fk.OnUpdate = strings.ToUpper(fk.OnUpdate)
+		fk.OnDelete = strings.ToUpper(fk.OnDelete)
+		b.keys = append(b.keys, fk)
+	}
+	b.keys[i].Columns = append(b.keys[i].Columns, column)
+	// a reference without columns, such as to the primary key in SQLite, leaves ReferencedColumns empty
+	if referencedColumn != "" {
+		b.keys[i].ReferencedColumns = append(b.keys[i].ReferencedColumns, referencedColumn)
+	}
+}
+
+func nullableString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+// placeholders returns the interpolation strategy factory of the dialect's usual driver
+func placeholders(dialect vsql_engine_go.Dialect) interpolation_strategy.InterpolationStrategyFactory {
+	switch dialect {
+	case vsql_engine_go.PostgresDialect:
+		return func() interpolation_strategy.InterpolateStrategy { return &numbered{prefix: "$"} }
+	case vsql_engine_go.SQLServerDialect:
+		return func() interpolation_strategy.InterpolateStrategy { return &numbered{prefix: "@p"} }
+	default:
+		return func() interpolation_strategy.InterpolateStrategy { return questionMark{} }
+	}
+}
+
+type questionMark struct {
+}
+
+// InsertPlaceholderIntoSQL returns ?
+func (questionMark) InsertPlaceholderIntoSQL() string {
+	return "?"
+}
+
+// numbered renders $1, $2 ... or @p1, @p2 ...
+type numbered struct {
+	prefix string
+	next   int
+}
+
+// InsertPlaceholderIntoSQL returns the next numbered placeholder
+func (n *numbered) InsertPlaceholderIntoSQL() string {
+	n.next++
+	return fmt.Sprintf("%s%d", n.prefix, n.next)
+}
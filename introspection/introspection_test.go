@@ -0,0 +1,133 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package introspection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/wojnosystems/vsql_engine_go"
+	"testing"
+)
+
+const testSchema = `
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY,
+	email VARCHAR(255) NOT NULL UNIQUE,
+	status TEXT DEFAULT 'active'
+);
+CREATE TABLE memberships (
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	group_id INTEGER NOT NULL,
+	joined_at TIMESTAMP,
+	PRIMARY KEY (group_id, user_id)
+);
+CREATE INDEX memberships_joined ON memberships (joined_at, user_id);
+CREATE VIEW active_users AS SELECT * FROM users WHERE status = 'active';
+`
+
+func newSQLiteDB(t *testing.T, schema string) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range vsql_engine_go.SplitStatements(schema) {
+		if _, err = db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestInspector_SQLite(t *testing.T) {
+	db := newSQLiteDB(t, testSchema)
+	defer func() { _ = db.Close() }()
+	inspector, err := NewFromDB(db, vsql_engine_go.SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := inspector.Schema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Tables) != 2 || schema.Tables[0].Name != "memberships" || schema.Tables[1].Name != "users" {
+		t.Fatalf("expected the two tables, without the view: %+v", schema.Tables)
+	}
+
+	users, _ := schema.Table("users")
+	email, _ := users.Column("email")
+	status, _ := users.Column("status")
+	if email.Type != "varchar(255)" || email.Nullable || email.Default != nil {
+		t.Errorf("unexpected email column: %+v", email)
+	}
+	if !status.Nullable || status.Default == nil || *status.Default != "'active'" {
+		t.Errorf("unexpected status column: %+v", status)
+	}
+	if fmt.Sprint(users.PrimaryKey) != "[id]" {
+		t.Errorf("unexpected users primary key: %v", users.PrimaryKey)
+	}
+	if len(users.Indexes) != 1 || !users.Indexes[0].Unique || fmt.Sprint(users.Indexes[0].Columns) != "[email]" {
+		t.Errorf("expected the unique email constraint: %+v", users.Indexes)
+	}
+
+	memberships, _ := schema.Table("memberships")
+	if fmt.Sprint(memberships.PrimaryKey) != "[group_id user_id]" {
+		t.Errorf("expected the primary key in key order: %v", memberships.PrimaryKey)
+	}
+	expectedIndexes := []Index{{Name: "memberships_joined", Columns: []string{"joined_at", "user_id"}}}
+	if fmt.Sprint(memberships.Indexes) != fmt.Sprint(expectedIndexes) {
+		t.Errorf("expected %+v, got %+v", expectedIndexes, memberships.Indexes)
+	}
+	expectedKeys := []ForeignKey{{
+		Columns:           []string{"user_id"},
+		ReferencedTable:   "users",
+		ReferencedColumns: []string{"id"},
+		OnUpdate:          "NO ACTION",
+		OnDelete:          "CASCADE",
+	}}
+	if fmt.Sprint(memberships.ForeignKeys) != fmt.Sprint(expectedKeys) {
+		t.Errorf("expected %+v, got %+v", expectedKeys, memberships.ForeignKeys)
+	}
+
+	if _, err = inspector.Table(context.Background(), "missing"); err == nil {
+		t.Error("expected an error for a table that does not exist")
+	}
+}
+
+func TestInspector_SQLiteReferenceToPrimaryKey(t *testing.T) {
+	db := newSQLiteDB(t, "CREATE TABLE parents (id INTEGER PRIMARY KEY); CREATE TABLE children (parent_id INTEGER REFERENCES parents)")
+	defer func() { _ = db.Close() }()
+	inspector, err := NewFromDB(db, vsql_engine_go.SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := inspector.Schema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, _ := schema.Table("children")
+	if len(children.ForeignKeys) != 1 || len(children.ForeignKeys[0].ReferencedColumns) != 0 {
+		t.Errorf("expected a reference without columns: %+v", children.ForeignKeys)
+	}
+}
+
+func TestNew_GenericUnsupported(t *testing.T) {
+	if _, err := New(nil, vsql_engine_go.GenericDialect); err == nil {
+		t.Error("expected the generic dialect to be rejected")
+	}
+}
@@ -0,0 +1,127 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package introspection
+
+import (
+	"context"
+	"database/sql"
+	"github.com/wojnosystems/vsql/vquery"
+	"github.com/wojnosystems/vsql/vrows"
+)
+
+// postgresCatalog reads pg_catalog for the tables of the current schema
+type postgresCatalog struct {
+}
+
+func (postgresCatalog) tables(ctx context.Context, q vquery.Queryer) (names []string, err error) {
+	err = each(ctx, q, `SELECT c.relname FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p')`, nil, func(row vrows.Rower) error {
+		var name string
+		names = append(names, name)
+		return row.Scan(&names[len(names)-1])
+	})
+	return
+}
+
+const postgresColumns = `SELECT a.attname, pg_catalog.format_type(a.atttypid, a.atttypmod), NOT a.attnotnull, pg_catalog.pg_get_expr(d.adbin, d.adrelid)
+FROM pg_catalog.pg_attribute a
+JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+WHERE c.relname = ? AND n.nspname = current_schema() AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY a.attnum`
+
+const postgresIndexes = `SELECT i.relname, ix.indisunique, ix.indisprimary, COALESCE(a.attname, '')
+FROM pg_catalog.pg_index ix
+JOIN pg_catalog.pg_class t ON t.oid = ix.indrelid
+JOIN pg_catalog.pg_class i ON i.oid = ix.indexrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = t.relnamespace
+CROSS JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, ord)
+LEFT JOIN pg_catalog.pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+WHERE t.relname = ? AND n.nspname = current_schema()
+ORDER BY i.relname, k.ord`
+
+const postgresForeignKeys = `SELECT con.conname, rt.relname, a.attname, ra.attname, con.confupdtype, con.confdeltype
+FROM pg_catalog.pg_constraint con
+JOIN pg_catalog.pg_class t ON t.oid = con.conrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = t.relnamespace
+JOIN pg_catalog.pg_class rt ON rt.oid = con.confrelid
+CROSS JOIN LATERAL unnest(con.conkey, con.confkey) WITH ORDINALITY AS k(attnum, refnum, ord)
+JOIN pg_catalog.pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+JOIN pg_catalog.pg_attribute ra ON ra.attrelid = rt.oid AND ra.attnum = k.refnum
+WHERE con.contype = 'f' AND t.relname = ? AND n.nspname = current_schema()
+ORDER BY con.conname, k.ord`
+
+// postgresActions maps pg_constraint's action codes to their SQL
+var postgresActions = map[string]string{
+	"a": "NO ACTION",
+	"r": "RESTRICT",
+	"c": "CASCADE",
+	"n": "SET NULL",
+	"d": "SET DEFAULT",
+}
+
+func (postgresCatalog) table(ctx context.Context, q vquery.Queryer, name string) (table Table, err error) {
+	table.Name = name
+	err = each(ctx, q, postgresColumns, []interface{}{name}, func(row vrows.Rower) error {
+		var c Column
+		var def sql.NullString
+		if err := row.Scan(&c.Name, &c.Type, &c.Nullable, &def); err != nil {
+			return err
+		}
+		c.Default = nullableString(def)
+		table.Columns = append(table.Columns, c)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	builder := indexBuilder{}
+	err = each(ctx, q, postgresIndexes, []interface{}{name}, func(row vrows.Rower) error {
+		var index, column string
+		var unique, primary bool
+		if err := row.Scan(&index, &unique, &primary, &column); err != nil {
+			return err
+		}
+		if primary {
+			table.PrimaryKey = append(table.PrimaryKey, column)
+			return nil
+		}
+		// expression indexes have no column name
+		builder.add(index, unique, column)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	table.Indexes = builder.indexes
+
+	fks := foreignKeyBuilder{}
+	err = each(ctx, q, postgresForeignKeys, []interface{}{name}, func(row vrows.Rower) error {
+		var fk ForeignKey
+		var column, referenced, onUpdate, onDelete string
+		if err := row.Scan(&fk.Name, &fk.ReferencedTable, &column, &referenced, &onUpdate, &onDelete); err != nil {
+			return err
+		}
+		fk.OnUpdate, fk.OnDelete = postgresActions[onUpdate], postgresActions[onDelete]
+		fks.add(fk.Name, fk, column, referenced)
+		return nil
+	})
+	table.ForeignKeys = fks.keys
+	return
+}
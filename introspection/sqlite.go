@@ -0,0 +1,123 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package introspection
+
+import (
+	"context"
+	"database/sql"
+	"github.com/wojnosystems/vsql/vquery"
+	"github.com/wojnosystems/vsql/vrows"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sqliteCatalog reads sqlite_master and the table-valued pragma functions, which need SQLite 3.16 or later
+type sqliteCatalog struct {
+}
+
+func (sqliteCatalog) tables(ctx context.Context, q vquery.Queryer) (names []string, err error) {
+	err = each(ctx, q, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'", nil, func(row vrows.Rower) error {
+		var name string
+		names = append(names, name)
+		return row.Scan(&names[len(names)-1])
+	})
+	return
+}
+
+func (sqliteCatalog) table(ctx context.Context, q vquery.Queryer, name string) (table Table, err error) {
+	table.Name = name
+	type keyColumn struct {
+		position int
+		name     string
+	}
+	var primaryKey []keyColumn
+	err = each(ctx, q, `SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid`, []interface{}{name}, func(row vrows.Rower) error {
+		var c Column
+		var notNull bool
+		var def sql.NullString
+		var pk int
+		if err := row.Scan(&c.Name, &c.Type, &notNull, &def, &pk); err != nil {
+			return err
+		}
+		c.Type = strings.ToLower(c.Type)
+		c.Nullable = !notNull
+		c.Default = nullableString(def)
+		table.Columns = append(table.Columns, c)
+		if pk > 0 {
+			primaryKey = append(primaryKey, keyColumn{position: pk, name: c.Name})
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	sort.Slice(primaryKey, func(i, j int) bool {
+		return primaryKey[i].position < primaryKey[j].position
+	})
+	for _, k := range primaryKey {
+		table.PrimaryKey = append(table.PrimaryKey, k.name)
+	}
+
+	type indexRow struct {
+		name   string
+		unique bool
+	}
+	var indexes []indexRow
+	err = each(ctx, q, `SELECT name, "unique" FROM pragma_index_list(?) WHERE origin != 'pk'`, []interface{}{name}, func(row vrows.Rower) error {
+		var index indexRow
+		if err := row.Scan(&index.name, &index.unique); err != nil {
+			return err
+		}
+		indexes = append(indexes, index)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	builder := indexBuilder{}
+	for _, index := range indexes {
+		err = each(ctx, q, "SELECT name FROM pragma_index_info(?) ORDER BY seqno", []interface{}{index.name}, func(row vrows.Rower) error {
+			var column sql.NullString
+			if err := row.Scan(&column); err != nil {
+				return err
+			}
+			// expression indexes have no column name
+			builder.add(index.name, index.unique, column.String)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	table.Indexes = builder.indexes
+
+	fks := foreignKeyBuilder{}
+	err = each(ctx, q, `SELECT id, "table", "from", "to", on_update, on_delete FROM pragma_foreign_key_list(?) ORDER BY id, seq`, []interface{}{name}, func(row vrows.Rower) error {
+		var id int
+		var fk ForeignKey
+		var from string
+		var to sql.NullString
+		if err := row.Scan(&id, &fk.ReferencedTable, &from, &to, &fk.OnUpdate, &fk.OnDelete); err != nil {
+			return err
+		}
+		// a reference without columns is to the primary key of the referenced table
+		fks.add(strconv.Itoa(id), fk, from, to.String)
+		return nil
+	})
+	table.ForeignKeys = fks.keys
+	return
+}
@@ -0,0 +1,211 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"strings"
+	"sync"
+)
+
+// LiteralPolicy is what the literal check middleware does with queries that contain literals where parameters are expected
+type LiteralPolicy int
+
+const (
+	// LiteralFlag reports the query to OnUnsafeLiteral and lets it run
+	LiteralFlag LiteralPolicy = iota
+	// LiteralBlock rejects the query with an *UnsafeLiteralError before it reaches the database
+	LiteralBlock
+)
+
+// ErrLiteralCheckOnUnsafeLiteralRequired is returned by InstallLiteralCheck for the LiteralFlag policy without an
+// OnUnsafeLiteral to report to
+var ErrLiteralCheckOnUnsafeLiteralRequired = errors.New("the literal check flag policy requires OnUnsafeLiteral")
+
+// UnsafeLiteralError is returned by the LiteralBlock policy
+type UnsafeLiteralError struct {
+	Fingerprint Fingerprint
+	// Literals are the offending literals, as they appear in the query
+	Literals []string
+}
+
+func (e *UnsafeLiteralError) Error() string {
+	return fmt.Sprintf("query %s has literals where parameters are expected: %s", e.Fingerprint.Hash, strings.Join(e.Literals, ", "))
+}
+
+// LiteralCheckConfig sets up a LiteralChecker
+type LiteralCheckConfig struct {
+	Policy LiteralPolicy
+	// IgnoreNumbers only checks string literals. Numeric literals are often intentional constants, such as "deleted = 0"
+	IgnoreNumbers bool
+	// Suppressed are the fingerprint hashes of queries whose literals were reviewed and are intentional
+	Suppressed []string
+	// OnUnsafeLiteral is called with each query that is not suppressed and has literals where parameters are expected,
+	// for either policy. It is required by the flag policy
+	OnUnsafeLiteral func(ctx context.Context, fp Fingerprint, literals []string)
+}
+
+// LiteralChecker finds string and numeric literals in the positions where user values are usually passed as
+// parameters: compared with an operator, LIKE or BETWEEN, listed with IN or inserted with VALUES. These are the
+// literals that end up in a query when a value is concatenated into the SQL instead of being bound as a parameter.
+// It is safe to use from multiple goroutines
+type LiteralChecker struct {
+	cfg        LiteralCheckConfig
+	mu         sync.RWMutex
+	suppressed map[string]struct{}
+}
+
+// NewLiteralChecker creates a checker with the suppressions in the configuration
+func NewLiteralChecker(cfg LiteralCheckConfig) *LiteralChecker {
+	c := &LiteralChecker{
+		cfg:        cfg,
+		suppressed: make(map[string]struct{}, len(cfg.Suppressed)),
+	}
+	for _, hash := range cfg.Suppressed {
+		c.suppressed[hash] = struct{}{}
+	}
+	return c
+}
+
+// Suppress stops reporting the queries with the fingerprint hash
+func (c *LiteralChecker) Suppress(hash string) {
+	c.mu.Lock()
+	c.suppressed[hash] = struct{}{}
+	c.mu.Unlock()
+}
+
+func (c *LiteralChecker) isSuppressed(hash string) bool {
+	c.mu.RLock()
+	_, ok := c.suppressed[hash]
+	c.mu.RUnlock()
+	return ok
+}
+
+// Literals returns the literals of the query that are in positions where parameters are expected, ignoring suppressions
+func (c *LiteralChecker) Literals(sqlQuery string) (literals []string) {
+	tokens := withoutComments(tokenizeSQL(sqlQuery))
+	// parens is a stack with the kind of each open parenthesis
+	var parens []parenKind
+	closed := parenOther
+	for i, t := range tokens {
+		switch {
+		case t.is("("):
+			previous := tokenAt(tokens, i-1)
+			kind := parenOther
+			if previous.is("in") {
+				kind = parenInList
+			} else if previous.is("values") || previous.is("value") || (closed == parenValues && previous.is(",")) {
+				kind = parenValues
+			}
+			parens = append(parens, kind)
+			closed = parenOther
+		case t.is(")"):
+			closed = parenOther
+			if len(parens) != 0 {
+				closed = parens[len(parens)-1]
+				parens = parens[:len(parens)-1]
+			}
+		case t.kind == tokenString || (t.kind == tokenNumber && !c.cfg.IgnoreNumbers):
+			closed = parenOther
+			at := i
+			if t.kind == tokenNumber && isUnaryMinus(tokens, i-1) {
+				at--
+			}
+			inList := len(parens) != 0 && parens[len(parens)-1] != parenOther
+			if expectsParameter(tokens, at, inList) {
+				literals = append(literals, sqlQuery[tokens[at].start:t.end])
+			}
+		case !t.is(","):
+			closed = parenOther
+		}
+	}
+	return
+}
+
+// parenKind is what a parenthesis holds, as far as the literal check is concerned
+type parenKind int
+
+const (
+	parenOther parenKind = iota
+	parenInList
+	parenValues
+)
+
+// comparisonOperators are the operators whose right-hand side is usually a parameter
+var comparisonOperators = map[string]bool{"=": true, "<>": true, "!=": true, "<": true, ">": true, "<=": true, ">=": true, "<=>": true}
+
+// expectsParameter is true if the token at i is in a position where parameters are expected
+func expectsParameter(tokens []sqlToken, i int, inList bool) bool {
+	previous := tokenAt(tokens, i-1)
+	switch {
+	case previous.kind == tokenPunct && comparisonOperators[previous.text]:
+		return true
+	case previous.is("like") || previous.is("ilike") || previous.is("between"):
+		return true
+	case previous.is("and"):
+		// the upper bound of BETWEEN
+		return tokenAt(tokens, i-3).is("between")
+	case inList:
+		return previous.is("(") || previous.is(",")
+	}
+	return false
+}
+
+// tokenAt returns the token at i, or an empty token if i is out of range
+func tokenAt(tokens []sqlToken, i int) sqlToken {
+	if i < 0 || i >= len(tokens) {
+		return sqlToken{kind: tokenComment}
+	}
+	return tokens[i]
+}
+
+// check returns the error that rejects the query, or nil if the query may run
+func (c *LiteralChecker) check(ctx context.Context, query vparam.Queryer) error {
+	if query == nil {
+		return nil
+	}
+	sqlQ := query.SQLQueryUnInterpolated()
+	literals := c.Literals(sqlQ)
+	if len(literals) == 0 {
+		return nil
+	}
+	fp := fingerprintOf(ctx, sqlQ)
+	if c.isSuppressed(fp.Hash) {
+		return nil
+	}
+	if c.cfg.OnUnsafeLiteral != nil {
+		c.cfg.OnUnsafeLiteral(ctx, fp, literals)
+	}
+	if c.cfg.Policy == LiteralBlock {
+		return &UnsafeLiteralError{Fingerprint: fp, Literals: literals}
+	}
+	return nil
+}
+
+// InstallLiteralCheck adds middleware that checks every query, insert, exec and statement preparation for literals
+// where parameters are expected. Placeholders are never reported, only the literals written into the SQL itself.
+// Nothing is installed if the checker's configuration is invalid
+func InstallLiteralCheck(engine vsql_engine.SQLQueryer, checker *LiteralChecker) error {
+	if checker.cfg.Policy == LiteralFlag && checker.cfg.OnUnsafeLiteral == nil {
+		return ErrLiteralCheckOnUnsafeLiteralRequired
+	}
+	installQueryCheck(engine, checker.check)
+	return nil
+}
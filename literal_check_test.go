@@ -0,0 +1,131 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"reflect"
+	"testing"
+)
+
+func TestLiteralChecker_Literals(t *testing.T) {
+	cases := map[string]struct {
+		input         string
+		ignoreNumbers bool
+		expected      []string
+	}{
+		"parameters are safe": {
+			input: "SELECT id FROM users WHERE name = ? AND age > $1 AND id IN (?, ?) LIMIT 10",
+		},
+		"concatenated comparison": {
+			input:    "SELECT id FROM users WHERE name = 'alice' OR 1=1",
+			expected: []string{"'alice'", "1"},
+		},
+		"negative numbers": {
+			input:    "SELECT id FROM accounts WHERE balance < -5",
+			expected: []string{"-5"},
+		},
+		"like and between": {
+			input:    "SELECT id FROM users WHERE name LIKE 'a%' AND age BETWEEN 18 AND 65",
+			expected: []string{"'a%'", "18", "65"},
+		},
+		"in lists and values tuples": {
+			input:    "INSERT INTO users (id, name) VALUES (1, 'alice'), (2, lower('BOB')) ON CONFLICT DO NOTHING",
+			expected: []string{"1", "'alice'", "2"},
+		},
+		"in list": {
+			input:    "DELETE FROM users WHERE id IN (1, 2, ?) AND kind IN (SELECT 'x')",
+			expected: []string{"1", "2"},
+		},
+		"not value positions": {
+			input: "SELECT 'label' AS kind, substr(name, 1, 3), CASE WHEN a THEN 'y' END FROM users -- name = 'alice'\nLIMIT 5 OFFSET 10",
+		},
+		"ignore numbers": {
+			input:         "UPDATE users SET name = 'bob' WHERE deleted = 0",
+			ignoreNumbers: true,
+			expected:      []string{"'bob'"},
+		},
+	}
+	for caseName, c := range cases {
+		t.Run(caseName, func(t *testing.T) {
+			checker := NewLiteralChecker(LiteralCheckConfig{IgnoreNumbers: c.ignoreNumbers})
+			if actual := checker.Literals(c.input); !reflect.DeepEqual(c.expected, actual) {
+				t.Errorf("expected %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestInstallLiteralCheck(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE users (id INTEGER, name TEXT, deleted INTEGER)")); err != nil {
+		t.Fatal(err)
+	}
+
+	var flagged []string
+	checker := NewLiteralChecker(LiteralCheckConfig{
+		Policy:     LiteralBlock,
+		Suppressed: []string{FingerprintSQL("SELECT name FROM users WHERE deleted = 0").Hash},
+		OnUnsafeLiteral: func(ctx context.Context, fp Fingerprint, literals []string) {
+			flagged = append(flagged, literals...)
+		},
+	})
+	if err := InstallLiteralCheck(engine, checker); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.Exec(ctx, vparam.NewAppendWithData("INSERT INTO users (id, name, deleted) VALUES (?, ?, ?)", 1, "alice", 0)); err != nil {
+		t.Errorf("expected the parameterized insert to run: %v", err)
+	}
+	_, err := engine.Query(ctx, vparam.New("SELECT id FROM users WHERE name = 'alice'"))
+	if e, ok := err.(*UnsafeLiteralError); !ok || !reflect.DeepEqual(e.Literals, []string{"'alice'"}) {
+		t.Errorf("expected the concatenated query to be blocked, got: %v", err)
+	}
+	rows, err := engine.Query(ctx, vparam.New("select name from users where deleted = 0"))
+	if err != nil {
+		t.Fatalf("expected the suppressed query to run: %v", err)
+	}
+	_ = rows.Close()
+	if !reflect.DeepEqual(flagged, []string{"'alice'"}) {
+		t.Errorf("expected only the blocked query to be reported, got: %q", flagged)
+	}
+}
+
+func TestInstallLiteralCheck_FlagRequiresOnUnsafeLiteral(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	if err := InstallLiteralCheck(engine, NewLiteralChecker(LiteralCheckConfig{Policy: LiteralFlag})); err != ErrLiteralCheckOnUnsafeLiteralRequired {
+		t.Errorf("expected the flag policy to require OnUnsafeLiteral, got: %v", err)
+	}
+}
+
+func TestLiteralChecker_SuppressesByTheQuerysOwnFingerprint(t *testing.T) {
+	suppressed := vparam.New("SELECT name FROM users WHERE deleted = 0")
+	checker := NewLiteralChecker(LiteralCheckConfig{
+		Policy:     LiteralBlock,
+		Suppressed: []string{FingerprintSQL(suppressed.SQLQueryUnInterpolated()).Hash},
+	})
+	ctx := withFingerprint(context.Background(), suppressed)
+	if err := checker.check(ctx, suppressed); err != nil {
+		t.Errorf("expected the suppressed query to pass: %v", err)
+	}
+	if err := checker.check(ctx, vparam.New("SELECT id FROM users WHERE name = 'alice'")); err == nil {
+		t.Error("expected a query run with another query's context.Context not to be suppressed")
+	}
+}
@@ -0,0 +1,148 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/wojnosystems/vsql/vrows"
+)
+
+// memoryRows is a result set held in memory. Rows are scanned exactly as goRows would scan them
+type memoryRows struct {
+	columns  []string
+	values   [][]interface{}
+	position int
+	// err is returned when the rows are closed, used to report errors that happened while the rows were collected
+	err error
+}
+
+func newMemoryRows(columns []string, values [][]interface{}) *memoryRows {
+	return &memoryRows{
+		columns: columns,
+		values:  values,
+	}
+}
+
+// Next returns the next row or nil if no more rows are available
+func (m *memoryRows) Next() vrows.Rower {
+	if m.position >= len(m.values) {
+		return nil
+	}
+	r := &memoryRow{
+		columns: m.columns,
+		values:  m.values[m.position],
+	}
+	m.position++
+	return r
+}
+
+// Close releases the rows. Rows in memory do not hold resources, so this only reports collection errors
+func (m *memoryRows) Close() error {
+	m.position = len(m.values)
+	return m.err
+}
+
+type memoryRow struct {
+	columns []string
+	values  []interface{}
+}
+
+// Columns returns a list of columns available for this vrow
+func (m *memoryRow) Columns() (columnNames []string) {
+	return m.columns
+}
+
+// Scan converts the values of the row into the destinations the same way sql.Rows.Scan does
+func (m *memoryRow) Scan(dest ...interface{}) error {
+	if len(dest) != len(m.values) {
+		return fmt.Errorf("sql: expected %d destination arguments in Scan, not %d", len(m.values), len(dest))
+	}
+	for i, v := range m.values {
+		if err := assignValue(dest[i], v); err != nil {
+			return fmt.Errorf(`sql: Scan error on column index %d, name "%s": %v`, i, m.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// readAllRows reads the remaining rows into memory and closes the rows
+func readAllRows(rows *sql.Rows) (columns []string, values [][]interface{}, err error) {
+	defer func() {
+		if closeErr := rows.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	columns, err = rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	values = make([][]interface{}, 0)
+	for rows.Next() {
+		row, err := scanRow(rows, len(columns))
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, row)
+	}
+	return columns, values, rows.Err()
+}
+
+// readAllRowser reads the remaining rows of a vrows.Rowser into memory and closes the rows
+func readAllRowser(rows vrows.Rowser) (columns []string, values [][]interface{}, err error) {
+	if r, ok := rows.(*goRows); ok {
+		// read the sql.Rows directly to keep the column names of empty results and to report iteration errors
+		if r.cancel != nil {
+			defer r.cancel()
+		}
+		return readAllRows(r.sqlRows)
+	}
+	defer func() {
+		if closeErr := rows.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	values = make([][]interface{}, 0)
+	for row := rows.Next(); row != nil; row = rows.Next() {
+		if columns == nil {
+			columns = row.Columns()
+		}
+		values = append(values, nil)
+		values[len(values)-1], err = scanRow(row, len(columns))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return columns, values, nil
+}
+
+// scanner is implemented by both sql.Rows and vrows.Rower
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRow reads the current row as values. Scanning into *interface{} leaves the conversion to the driver and copies byte slices
+func scanRow(row scanner, columnCount int) ([]interface{}, error) {
+	values := make([]interface{}, columnCount)
+	dest := make([]interface{}, columnCount)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,121 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package migration
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql_engine_go"
+	"time"
+)
+
+// ErrLocked is returned when another runner held the migration lock for longer than Options.LockTimeout
+var ErrLocked = errors.New("migrations are locked by another runner")
+
+// locked runs the block while holding the migration lock. The lock is a row in the lock table, which works the same
+// on every database, but is left behind if the runner dies while holding it; remove it with ForceUnlock
+func (m *Migrator) locked(ctx context.Context, block func() error) (err error) {
+	if err = m.createTables(ctx); err != nil {
+		return err
+	}
+	owner, err := newLockOwner()
+	if err != nil {
+		return err
+	}
+	if err = m.lock(ctx, owner); err != nil {
+		return err
+	}
+	defer func() {
+		// release even if ctx was canceled, or the lock would be held until ForceUnlock
+		if unlockErr := m.unlock(context.Background(), owner); err == nil {
+			err = unlockErr
+		}
+	}()
+	return block()
+}
+
+func (m *Migrator) lock(ctx context.Context, owner string) error {
+	deadline := time.Now().Add(m.opts.LockTimeout)
+	// freeRetried is set when the insert failed but the lock was free, it may have been released in between
+	freeRetried := false
+	for {
+		_, err := m.db.Exec(ctx, vsql_engine_go.NewPositionalQuery(
+			fmt.Sprintf("INSERT INTO %s (id, owner, locked_at) VALUES (1, ?, ?)", m.dialect.QuoteIdentifier(m.opts.LockTable)),
+			owner, time.Now().UTC()))
+		if err == nil {
+			return nil
+		}
+		held, heldErr := m.lockHeld(ctx)
+		if heldErr != nil {
+			return heldErr
+		}
+		if !held {
+			if freeRetried {
+				// the insert failed twice with the lock free, so it fails for some other reason than the lock row
+				return err
+			}
+			freeRetried = true
+			continue
+		}
+		freeRetried = false
+		if !time.Now().Before(deadline) {
+			return ErrLocked
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.opts.LockPollInterval):
+		}
+	}
+}
+
+func (m *Migrator) lockHeld(ctx context.Context) (held bool, err error) {
+	rows, err := m.db.Query(ctx, vsql_engine_go.NewPositionalQuery(
+		fmt.Sprintf("SELECT id FROM %s WHERE id = 1", m.dialect.QuoteIdentifier(m.opts.LockTable))))
+	if err != nil {
+		return false, err
+	}
+	held = rows.Next() != nil
+	return held, rows.Close()
+}
+
+func (m *Migrator) unlock(ctx context.Context, owner string) error {
+	_, err := m.db.Exec(ctx, vsql_engine_go.NewPositionalQuery(
+		fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND owner = ?", m.dialect.QuoteIdentifier(m.opts.LockTable)), owner))
+	return err
+}
+
+// ForceUnlock removes the migration lock regardless of who holds it. Use it only when the runner holding it has died
+func (m *Migrator) ForceUnlock(ctx context.Context) error {
+	if err := m.createTables(ctx); err != nil {
+		return err
+	}
+	_, err := m.db.Exec(ctx, vsql_engine_go.NewPositionalQuery(
+		fmt.Sprintf("DELETE FROM %s WHERE id = 1", m.dialect.QuoteIdentifier(m.opts.LockTable))))
+	return err
+}
+
+// newLockOwner identifies this runner in the lock table so that it only releases its own lock
+func newLockOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,106 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package migration applies versioned schema migrations through a vsql engine, so that migrations run through the
+// same middleware as the application's own queries
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Migration is a single version of the schema
+type Migration struct {
+	// Version orders the migrations, it is the number the file names start with
+	Version uint64
+	// Name is the part of the file names between the version and the direction
+	Name string
+	// Up is the SQL that applies the migration
+	Up string
+	// Down is the SQL that reverts the migration, empty if there is no down file
+	Down string
+	// Checksum is the hex SHA-256 of Up, recorded when the migration is applied
+	Checksum string
+}
+
+// fileNamePattern matches migration files named like 0001_create_users.up.sql and 0001_create_users.down.sql
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in the source, ordered by version. Files that are not named like migrations are ignored
+func Load(source Source) (migrations []Migration, err error) {
+	names, err := source.Names()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[uint64]*Migration)
+	for _, name := range names {
+		match := fileNamePattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %v", name, err)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, m.Name, match[2])
+		}
+		contents, err := readFile(source, name)
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = contents
+			m.Checksum = checksum(contents)
+		} else {
+			m.Down = contents
+		}
+	}
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func readFile(source Source, name string) (string, error) {
+	f, err := source.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	contents, err := ioutil.ReadAll(f)
+	return string(contents), err
+}
+
+func checksum(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return hex.EncodeToString(sum[:])
+}
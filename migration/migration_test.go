@@ -0,0 +1,221 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine_go"
+	"strings"
+	"testing"
+	"time"
+)
+
+type questionMark struct {
+}
+
+func (q *questionMark) InsertPlaceholderIntoSQL() string {
+	return "?"
+}
+
+func questionMarkFactory() interpolation_strategy.InterpolateStrategy {
+	return &questionMark{}
+}
+
+func newSQLiteEngine(t *testing.T) (vsql_engine.SingleTXer, *sql.DB) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	engine := vsql_engine.NewSingle()
+	vsql_engine_go.InstallSingle(engine, db, questionMarkFactory)
+	return engine, db
+}
+
+var testSource = MapSource{
+	"0001_users.up.sql":   "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\nINSERT INTO users (name) VALUES ('semi;colon?');",
+	"0001_users.down.sql": "DROP TABLE users;",
+	"0002_posts.up.sql":   "-- posts belong to users\nCREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER);",
+	"0002_posts.down.sql": "DROP TABLE posts;",
+	"README.md":           "not a migration",
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "users" || migrations[1].Version != 2 || migrations[1].Down == "" {
+		t.Errorf("unexpected migrations: %+v", migrations)
+	}
+	if _, err = Load(MapSource{"0001_a.down.sql": ""}); err == nil {
+		t.Error("expected an error for a migration without an up file")
+	}
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	engine, db := newSQLiteEngine(t)
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	m := New(engine, testSource, vsql_engine_go.SQLiteDialect, Options{})
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 {
+		t.Errorf("expected 2 migrations to be applied, got %d", len(applied))
+	}
+	var name string
+	if err = db.QueryRow("SELECT name FROM users").Scan(&name); err != nil || name != "semi;colon?" {
+		t.Errorf("expected the seeded user, got %q, %v", name, err)
+	}
+	if applied, err = m.Up(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("expected nothing to apply the second time, got %d, %v", len(applied), err)
+	}
+	records, err := m.Applied(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Checksum != checksum(testSource["0001_users.up.sql"]) || records[0].AppliedAt.IsZero() {
+		t.Errorf("unexpected records: %+v", records)
+	}
+
+	reverted, err := m.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != 2 {
+		t.Errorf("expected the newest migration to be reverted: %+v", reverted)
+	}
+	if _, err = db.Exec("SELECT * FROM posts"); err == nil {
+		t.Error("expected the posts table to be dropped")
+	}
+}
+
+func TestMigrator_FailedMigrationIsRolledBack(t *testing.T) {
+	engine, db := newSQLiteEngine(t)
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	source := MapSource{
+		"0001_broken.up.sql": "CREATE TABLE half (id INTEGER); INSERT INTO missing VALUES (1);",
+	}
+	_, err := New(engine, source, vsql_engine_go.SQLiteDialect, Options{}).Up(ctx)
+	if migrationErr, ok := err.(*Error); !ok || migrationErr.Version != 1 {
+		t.Fatalf("expected a migration error, got %v", err)
+	}
+	if _, err = db.Exec("SELECT * FROM half"); err == nil {
+		t.Error("expected the partial migration to be rolled back")
+	}
+	var count int
+	if err = db.QueryRow("SELECT COUNT(*) FROM schema_migrations_lock").Scan(&count); err != nil || count != 0 {
+		t.Errorf("expected the lock to be released, got %d, %v", count, err)
+	}
+}
+
+func TestMigrator_ScriptsRunAsWritten(t *testing.T) {
+	engine, _ := newSQLiteEngine(t)
+	defer func() { _ = engine.Close() }()
+	// postgres' jsonb ? operator, which SQLite does not have, so the statement stops here instead of reaching it
+	const operator = "CREATE INDEX docs_tagged ON docs (id) WHERE data ? 'tag'"
+	var sent string
+	engine.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		if c.Query().SQLQueryUnInterpolated() != operator {
+			c.Next(ctx)
+			return
+		}
+		sqlQ, params, err := c.Query().Interpolate(c.Query().SQLQueryUnInterpolated(), questionMarkFactory())
+		if err != nil || len(params) != 0 {
+			c.SetError(fmt.Errorf("expected no parameters, got %v: %v", params, err))
+			return
+		}
+		sent = sqlQ
+	})
+	source := MapSource{
+		"0001_docs.up.sql": "CREATE TABLE docs (id INTEGER, data TEXT);\n" + operator + ";",
+	}
+	if _, err := New(engine, source, vsql_engine_go.SQLiteDialect, Options{}).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sent != operator {
+		t.Errorf("expected the statement to be sent as written, got %q", sent)
+	}
+}
+
+func TestMigrator_Locked(t *testing.T) {
+	engine, _ := newSQLiteEngine(t)
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	m := New(engine, testSource, vsql_engine_go.SQLiteDialect, Options{LockTimeout: time.Millisecond, LockPollInterval: time.Millisecond})
+	if err := m.createTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.lock(ctx, "other runner"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != ErrLocked {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+	if err := m.ForceUnlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Errorf("expected the migrations to run once unlocked, got %v", err)
+	}
+}
+
+func TestMigrator_LockRetriesWhenReleasedInBetween(t *testing.T) {
+	engine, _ := newSQLiteEngine(t)
+	defer func() { _ = engine.Close() }()
+	// the first attempt fails as if the lock was held, but it is free by the time it is checked
+	failed := false
+	engine.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		if !failed && strings.HasPrefix(c.Query().SQLQueryUnInterpolated(), `INSERT INTO "schema_migrations_lock"`) {
+			failed = true
+			c.SetError(errors.New("UNIQUE constraint failed"))
+			return
+		}
+		c.Next(ctx)
+	})
+	ctx := context.Background()
+	m := New(engine, testSource, vsql_engine_go.SQLiteDialect, Options{})
+	if err := m.createTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.lock(ctx, "runner"); err != nil || !failed {
+		t.Errorf("expected the lock to be retried, got %v", err)
+	}
+}
+
+func TestMigrator_ParseTimestamp(t *testing.T) {
+	m := New(nil, testSource, vsql_engine_go.MySQLDialect, Options{})
+	expected := time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	for _, v := range []interface{}{[]byte("2024-01-02 03:04:05.123456"), "2024-01-02T03:04:05.123456Z", expected.In(time.FixedZone("x", 3600))} {
+		if actual, err := m.parseTimestamp(v); err != nil || !actual.Equal(expected) {
+			t.Errorf("%v: expected %s, got %s, %v", v, expected, actual, err)
+		}
+	}
+	if _, err := m.parseTimestamp([]byte("yesterday")); err == nil {
+		t.Error("expected an error for text that is not a timestamp")
+	}
+}
@@ -0,0 +1,366 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine_go"
+	"strings"
+	"time"
+)
+
+// ErrNoDown is returned when reverting a migration that has no down file
+var ErrNoDown = errors.New("migration has no down file")
+
+// Error is returned when a statement of a migration fails
+type Error struct {
+	Version uint64
+	Name    string
+	// Statement is the statement that failed, empty if recording the migration failed
+	Statement string
+	Err       error
+}
+
+// Error describes the failed migration
+func (e *Error) Error() string {
+	if e.Statement == "" {
+		return fmt.Sprintf("migration %d_%s: %v", e.Version, e.Name, e.Err)
+	}
+	return fmt.Sprintf("migration %d_%s: %v, in statement: %s", e.Version, e.Name, e.Err, e.Statement)
+}
+
+// Unwrap returns the database error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Options customize a Migrator
+type Options struct {
+	// Table records the applied migrations. Defaults to schema_migrations
+	Table string
+	// LockTable holds the lock that keeps two runners from migrating at the same time. Defaults to schema_migrations_lock
+	LockTable string
+	// LockTimeout is how long to wait for another runner to finish. Defaults to one minute
+	LockTimeout time.Duration
+	// LockPollInterval is how often the lock is retried while waiting. Defaults to one second
+	LockPollInterval time.Duration
+	// InterpolationStrategy renders the placeholders of the SQL printed by DryRun. Use the factory given to the engine's
+	// installer. If nil, DryRun prints ? placeholders
+	InterpolationStrategy interpolation_strategy.InterpolationStrategyFactory
+}
+
+// AppliedMigration is a migration recorded in the migrations table
+type AppliedMigration struct {
+	Version   uint64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrator applies and reverts the migrations of a source
+type Migrator struct {
+	db      vsql.SQLer
+	source  Source
+	dialect vsql_engine_go.Dialect
+	opts    Options
+}
+
+// New creates a Migrator
+// @param db is the engine to run the migrations through. Transactions are started with its Begin, so the engine's begin and commit middleware run for each migration
+// @param dialect decides whether each migration runs in a transaction and how the bookkeeping tables are created
+func New(db vsql.SQLer, source Source, dialect vsql_engine_go.Dialect, opts Options) *Migrator {
+	if opts.Table == "" {
+		opts.Table = "schema_migrations"
+	}
+	if opts.LockTable == "" {
+		opts.LockTable = "schema_migrations_lock"
+	}
+	if opts.LockTimeout <= 0 {
+		opts.LockTimeout = time.Minute
+	}
+	if opts.LockPollInterval <= 0 {
+		opts.LockPollInterval = time.Second
+	}
+	return &Migrator{
+		db:      db,
+		source:  source,
+		dialect: dialect,
+		opts:    opts,
+	}
+}
+
+// Up applies every migration that has not been applied yet, in version order. Each migration runs in its own
+// transaction if the dialect supports transactional DDL, otherwise a failed migration may be partially applied
+// @return applied are the migrations applied before any error
+func (m *Migrator) Up(ctx context.Context) (applied []Migration, err error) {
+	err = m.locked(ctx, func() error {
+		migrations, err := Load(m.source)
+		if err != nil {
+			return err
+		}
+		done, err := m.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if err = m.run(ctx, migration, migration.Up, true); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return
+}
+
+// Down reverts the most recently applied migrations, newest first
+// @param steps is the number of migrations to revert
+// @return reverted are the migrations reverted before any error
+func (m *Migrator) Down(ctx context.Context, steps int) (reverted []Migration, err error) {
+	err = m.locked(ctx, func() error {
+		migrations, err := Load(m.source)
+		if err != nil {
+			return err
+		}
+		byVersion := make(map[uint64]Migration, len(migrations))
+		for _, migration := range migrations {
+			byVersion[migration.Version] = migration
+		}
+		applied, err := m.Applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(applied) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration, ok := byVersion[applied[i].Version]
+			if !ok {
+				return &Error{Version: applied[i].Version, Name: applied[i].Name, Err: errors.New("applied migration is missing from the source")}
+			}
+			if strings.TrimSpace(migration.Down) == "" {
+				return &Error{Version: migration.Version, Name: migration.Name, Err: ErrNoDown}
+			}
+			if err = m.run(ctx, migration, migration.Down, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return
+}
+
+// Applied returns the recorded migrations, ordered by version. Nothing is changed on the database: if the migrations
+// table does not exist yet, no migrations have been applied
+func (m *Migrator) Applied(ctx context.Context) (applied []AppliedMigration, err error) {
+	exists, err := m.tableExists(ctx, m.opts.Table)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return m.readApplied(ctx)
+}
+
+// readApplied reads the migrations table, which must exist
+func (m *Migrator) readApplied(ctx context.Context) (applied []AppliedMigration, err error) {
+	rows, err := m.db.Query(ctx, vsql_engine_go.NewPositionalQuery(fmt.Sprintf(
+		"SELECT version, name, checksum, applied_at FROM %s ORDER BY version", m.dialect.QuoteIdentifier(m.opts.Table))))
+	if err != nil {
+		return nil, err
+	}
+	for row := rows.Next(); row != nil; row = rows.Next() {
+		a := AppliedMigration{}
+		var appliedAt interface{}
+		if err = row.Scan(&a.Version, &a.Name, &a.Checksum, &appliedAt); err == nil {
+			a.AppliedAt, err = m.parseTimestamp(appliedAt)
+		}
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		applied = append(applied, a)
+	}
+	// closing reports the error that ended reading the rows early, if any
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// timestampLayouts are the text forms of the timestamps drivers return when they do not convert them to time.Time,
+// such as MySQL without parseTime=true
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+}
+
+// parseTimestamp converts an applied_at value read from the database. Timestamps without a time zone are in UTC, as
+// they are recorded
+func (m *Migrator) parseTimestamp(v interface{}) (time.Time, error) {
+	var text string
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC(), nil
+	case []byte:
+		text = string(t)
+	case string:
+		text = t
+	default:
+		return time.Time{}, fmt.Errorf("unsupported %s timestamp type %T", m.dialect, v)
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, text, time.UTC); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported %s timestamp %q", m.dialect, text)
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context) (map[uint64]struct{}, error) {
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[uint64]struct{}, len(applied))
+	for _, a := range applied {
+		versions[a.Version] = struct{}{}
+	}
+	return versions, nil
+}
+
+// run executes the statements of one direction of a migration and records it, in a transaction if the dialect allows
+func (m *Migrator) run(ctx context.Context, migration Migration, script string, up bool) error {
+	apply := func(q vsql.QueryExecer) error {
+		for _, statement := range vsql_engine_go.SplitStatements(script) {
+			if _, err := q.Exec(ctx, vsql_engine_go.NewVerbatimQuery(statement)); err != nil {
+				return &Error{Version: migration.Version, Name: migration.Name, Statement: statement, Err: err}
+			}
+		}
+		if err := m.record(ctx, q, migration, up); err != nil {
+			return &Error{Version: migration.Version, Name: migration.Name, Err: err}
+		}
+		return nil
+	}
+	if !m.dialect.SupportsTransactionalDDL() {
+		return apply(m.db)
+	}
+	return vsql.Txn(m.db, ctx, nil, func(tx vsql.QueryExecer) (commit bool, err error) {
+		err = apply(tx)
+		return err == nil, err
+	})
+}
+
+func (m *Migrator) record(ctx context.Context, q vsql.QueryExecer, migration Migration, up bool) error {
+	_, err := q.Exec(ctx, m.recordQuery(migration, up, time.Now().UTC()))
+	return err
+}
+
+// recordQuery adds the migration to the migrations table when applying it, and removes it when reverting it
+func (m *Migrator) recordQuery(migration Migration, up bool, now time.Time) vparam.Queryer {
+	table := m.dialect.QuoteIdentifier(m.opts.Table)
+	if up {
+		return vsql_engine_go.NewPositionalQuery(
+			fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)", table),
+			migration.Version, migration.Name, migration.Checksum, now)
+	}
+	return vsql_engine_go.NewPositionalQuery(fmt.Sprintf("DELETE FROM %s WHERE version = ?", table), migration.Version)
+}
+
+// tableExists checks the catalog of the database for the table, which may be qualified with a schema
+func (m *Migrator) tableExists(ctx context.Context, name string) (exists bool, err error) {
+	schema, table := "", name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		schema, table = name[:i], name[i+1:]
+	}
+	var query vparam.Queryer
+	if m.dialect == vsql_engine_go.SQLiteDialect {
+		catalog := "sqlite_master"
+		if schema != "" {
+			catalog = m.dialect.QuoteIdentifier(schema) + ".sqlite_master"
+		}
+		query = vsql_engine_go.NewPositionalQuery("SELECT 1 FROM "+catalog+" WHERE type = 'table' AND name = ?", table)
+	} else {
+		var current string
+		switch m.dialect {
+		case vsql_engine_go.PostgresDialect:
+			current = "current_schema()"
+		case vsql_engine_go.MySQLDialect:
+			current = "DATABASE()"
+		case vsql_engine_go.SQLServerDialect:
+			current = "SCHEMA_NAME()"
+		}
+		switch {
+		case schema != "":
+			query = vsql_engine_go.NewPositionalQuery("SELECT 1 FROM information_schema.tables WHERE table_schema = ? AND table_name = ?", schema, table)
+		case current != "":
+			query = vsql_engine_go.NewPositionalQuery("SELECT 1 FROM information_schema.tables WHERE table_schema = "+current+" AND table_name = ?", table)
+		default:
+			query = vsql_engine_go.NewPositionalQuery("SELECT 1 FROM information_schema.tables WHERE table_name = ?", table)
+		}
+	}
+	rows, err := m.db.Query(ctx, query)
+	if err != nil {
+		return false, err
+	}
+	exists = rows.Next() != nil
+	return exists, rows.Close()
+}
+
+// createTables creates the migrations and lock tables if they do not exist
+func (m *Migrator) createTables(ctx context.Context) error {
+	timestamp := m.timestampType()
+	tables := []struct {
+		name    string
+		columns string
+	}{
+		{m.opts.Table, "version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, checksum VARCHAR(64) NOT NULL, applied_at " + timestamp + " NOT NULL"},
+		{m.opts.LockTable, "id INTEGER NOT NULL PRIMARY KEY, owner VARCHAR(64) NOT NULL, locked_at " + timestamp + " NOT NULL"},
+	}
+	for _, table := range tables {
+		var statement string
+		if m.dialect == vsql_engine_go.SQLServerDialect {
+			statement = fmt.Sprintf("IF OBJECT_ID(N'%s', N'U') IS NULL CREATE TABLE %s (%s)",
+				strings.Replace(table.name, "'", "''", -1), m.dialect.QuoteIdentifier(table.name), table.columns)
+		} else {
+			statement = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", m.dialect.QuoteIdentifier(table.name), table.columns)
+		}
+		if _, err := m.db.Exec(ctx, vsql_engine_go.NewPositionalQuery(statement)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) timestampType() string {
+	switch m.dialect {
+	case vsql_engine_go.PostgresDialect:
+		return "TIMESTAMP WITH TIME ZONE"
+	case vsql_engine_go.MySQLDialect:
+		return "DATETIME(6)"
+	case vsql_engine_go.SQLServerDialect:
+		return "DATETIME2"
+	default:
+		return "TIMESTAMP"
+	}
+}
@@ -0,0 +1,208 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package migration
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine_go"
+	"io"
+	"sort"
+	"time"
+)
+
+// State compares a migration in the source with the migrations table
+type State int
+
+const (
+	// Pending migrations are in the source but have not been applied
+	Pending State = iota
+	// Applied migrations were recorded with the checksum they have in the source
+	Applied
+	// Modified migrations were applied, but their up file has changed since
+	Modified
+	// Missing migrations were applied, but are no longer in the source
+	Missing
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case Applied:
+		return "applied"
+	case Modified:
+		return "modified"
+	case Missing:
+		return "missing"
+	default:
+		return "pending"
+	}
+}
+
+// Status is the state of a single migration version
+type Status struct {
+	Version uint64
+	Name    string
+	State   State
+	// Checksum is the checksum of the up file in the source, empty if Missing
+	Checksum string
+	// AppliedChecksum is the checksum recorded when the migration was applied, empty if Pending
+	AppliedChecksum string
+	// AppliedAt is when the migration was applied, zero if Pending
+	AppliedAt time.Time
+}
+
+// Plan is what Up would do
+type Plan struct {
+	// Pending are the migrations Up would apply, in the order it would apply them
+	Pending []Migration
+	// Drift are the applied migrations that were modified or are missing from the source. Up does not change them
+	Drift []Status
+}
+
+// Status compares the migrations in the source with the recorded checksums, ordered by version. Nothing is changed on
+// the database, a database without the migrations table has every migration pending
+func (m *Migrator) Status(ctx context.Context) (statuses []Status, err error) {
+	statuses, _, err = m.status(ctx)
+	return
+}
+
+func (m *Migrator) status(ctx context.Context) (statuses []Status, migrations []Migration, err error) {
+	migrations, err = Load(m.source)
+	if err != nil {
+		return nil, nil, err
+	}
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	byVersion := make(map[uint64]*Status, len(migrations)+len(applied))
+	for _, migration := range migrations {
+		byVersion[migration.Version] = &Status{
+			Version:  migration.Version,
+			Name:     migration.Name,
+			State:    Pending,
+			Checksum: migration.Checksum,
+		}
+	}
+	for _, a := range applied {
+		status, ok := byVersion[a.Version]
+		if !ok {
+			status = &Status{Version: a.Version, Name: a.Name, State: Missing}
+			byVersion[a.Version] = status
+		} else if status.Checksum == a.Checksum {
+			status.State = Applied
+		} else {
+			status.State = Modified
+		}
+		status.AppliedChecksum = a.Checksum
+		status.AppliedAt = a.AppliedAt
+	}
+	for _, status := range byVersion {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, migrations, nil
+}
+
+// Plan returns the migrations Up would apply and the drift between the source and the migrations table
+func (m *Migrator) Plan(ctx context.Context) (plan Plan, err error) {
+	statuses, migrations, err := m.status(ctx)
+	if err != nil {
+		return plan, err
+	}
+	pending := make(map[uint64]struct{})
+	for _, status := range statuses {
+		switch status.State {
+		case Pending:
+			pending[status.Version] = struct{}{}
+		case Modified, Missing:
+			plan.Drift = append(plan.Drift, status)
+		}
+	}
+	for _, migration := range migrations {
+		if _, ok := pending[migration.Version]; ok {
+			plan.Pending = append(plan.Pending, migration)
+		}
+	}
+	return plan, nil
+}
+
+// DryRun writes the SQL that Up would run, without running it. The statements of the migrations are written as they
+// are, the placeholders of the statements recording them are rendered with Options.InterpolationStrategy, the same way
+// the engine would send them to the database
+func (m *Migrator) DryRun(ctx context.Context, w io.Writer) (plan Plan, err error) {
+	plan, err = m.Plan(ctx)
+	if err != nil {
+		return plan, err
+	}
+	for _, status := range plan.Drift {
+		if _, err = fmt.Fprintf(w, "-- warning: %d_%s is %s\n", status.Version, status.Name, status.State); err != nil {
+			return plan, err
+		}
+	}
+	now := time.Now().UTC()
+	for _, migration := range plan.Pending {
+		header := fmt.Sprintf("-- %d_%s\n", migration.Version, migration.Name)
+		if m.dialect.SupportsTransactionalDDL() {
+			header = fmt.Sprintf("-- %d_%s, in a transaction\n", migration.Version, migration.Name)
+		}
+		if _, err = io.WriteString(w, header); err != nil {
+			return plan, err
+		}
+		for _, statement := range vsql_engine_go.SplitStatements(migration.Up) {
+			if err = m.printQuery(w, vsql_engine_go.NewVerbatimQuery(statement)); err != nil {
+				return plan, err
+			}
+		}
+		if err = m.printQuery(w, m.recordQuery(migration, true, now)); err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+// printQuery writes the query as it would be sent to the database, followed by its parameters as a comment
+func (m *Migrator) printQuery(w io.Writer, query vparam.Queryer) (err error) {
+	sqlQ := query.SQLQueryUnInterpolated()
+	var params []interface{}
+	if m.opts.InterpolationStrategy != nil {
+		sqlQ, params, err = query.Interpolate(sqlQ, m.opts.InterpolationStrategy())
+	} else {
+		_, params, err = query.Interpolate(sqlQ, keepQuestionMarks{})
+	}
+	if err != nil {
+		return err
+	}
+	if len(params) == 0 {
+		_, err = fmt.Fprintf(w, "%s;\n", sqlQ)
+	} else {
+		_, err = fmt.Fprintf(w, "%s; -- %v\n", sqlQ, params)
+	}
+	return err
+}
+
+// keepQuestionMarks keeps ? placeholders as they are
+type keepQuestionMarks struct {
+}
+
+// InsertPlaceholderIntoSQL returns ?
+func (keepQuestionMarks) InsertPlaceholderIntoSQL() string {
+	return "?"
+}
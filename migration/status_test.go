@@ -0,0 +1,107 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package migration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql_engine_go"
+	"strings"
+	"testing"
+)
+
+type dollarNumbered struct {
+	next int
+}
+
+func (d *dollarNumbered) InsertPlaceholderIntoSQL() string {
+	d.next++
+	return fmt.Sprintf("$%d", d.next)
+}
+
+func TestMigrator_StatusAndDryRun(t *testing.T) {
+	engine, db := newSQLiteEngine(t)
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	applied := MapSource{
+		"0001_users.up.sql": "CREATE TABLE users (id INTEGER);",
+		"0003_tags.up.sql":  "CREATE TABLE tags (id INTEGER);",
+	}
+	if _, err := New(engine, applied, vsql_engine_go.SQLiteDialect, Options{}).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	current := MapSource{
+		"0001_users.up.sql": "CREATE TABLE users (id INTEGER, name TEXT);",
+		"0002_posts.up.sql": "CREATE TABLE posts (id INTEGER);",
+	}
+	m := New(engine, current, vsql_engine_go.SQLiteDialect, Options{
+		InterpolationStrategy: func() interpolation_strategy.InterpolateStrategy { return &dollarNumbered{} },
+	})
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make([]string, len(statuses))
+	for i, status := range statuses {
+		states[i] = fmt.Sprintf("%d:%s", status.Version, status.State)
+	}
+	if expected := "1:modified 2:pending 3:missing"; strings.Join(states, " ") != expected {
+		t.Errorf("expected %s, got %v", expected, states)
+	}
+
+	out := bytes.Buffer{}
+	plan, err := m.DryRun(ctx, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Pending) != 1 || len(plan.Drift) != 2 {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+	for _, expected := range []string{
+		"-- warning: 1_users is modified",
+		"-- 2_posts, in a transaction\nCREATE TABLE posts (id INTEGER);\n",
+		`INSERT INTO "schema_migrations" (version, name, checksum, applied_at) VALUES ($1, $2, $3, $4); -- [2 posts `,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected the dry run to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+	if _, err = db.Exec("SELECT * FROM posts"); err == nil {
+		t.Error("expected the dry run not to create the posts table")
+	}
+}
+
+func TestMigrator_StatusIsReadOnly(t *testing.T) {
+	engine, db := newSQLiteEngine(t)
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	m := New(engine, testSource, vsql_engine_go.SQLiteDialect, Options{})
+
+	plan, err := m.DryRun(ctx, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Pending) != 2 || len(plan.Drift) != 0 {
+		t.Errorf("expected every migration to be pending: %+v", plan)
+	}
+	var count int
+	if err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&count); err != nil || count != 0 {
+		t.Errorf("expected the dry run not to create any tables, found %d, %v", count, err)
+	}
+}
@@ -0,0 +1,46 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+// operation identifies the kind of database call being made so that settings such as timeouts can be chosen per kind
+type operation int
+
+const (
+	opQuery operation = iota
+	opExec
+	opBegin
+	opCommit
+	opPing
+	opPrepare
+)
+
+func (o operation) String() string {
+	switch o {
+	case opQuery:
+		return "query"
+	case opExec:
+		return "exec"
+	case opBegin:
+		return "begin"
+	case opCommit:
+		return "commit"
+	case opPing:
+		return "ping"
+	case opPrepare:
+		return "prepare"
+	}
+	return "unknown"
+}
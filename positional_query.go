@@ -0,0 +1,102 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql/vparam"
+	"strings"
+)
+
+// positionalQuery is a query generated by this package with ? placeholders. Unlike vparam.Appender, it asks the
+// interpolation strategy for a new placeholder for every parameter, so strategies that number them, like postgres' $1, work
+type positionalQuery struct {
+	query  string
+	params []interface{}
+}
+
+// NewPositionalQuery creates a query with ? placeholders. The engine's interpolation strategy renders each placeholder
+// in turn, so strategies that number them, like postgres' $1, work, which vparam.NewAppend does not support. Question
+// marks inside of literals, quoted identifiers and comments are not placeholders
+func NewPositionalQuery(query string, params ...interface{}) vparam.Queryer {
+	return newPositionalQuery(query, params...)
+}
+
+func newPositionalQuery(query string, params ...interface{}) *positionalQuery {
+	return &positionalQuery{
+		query:  query,
+		params: params,
+	}
+}
+
+// SQLQueryUnInterpolated returns the query with ? placeholders
+func (q *positionalQuery) SQLQueryUnInterpolated() string {
+	return q.query
+}
+
+// SQLQueryInterpolated replaces each ? placeholder outside of literals and comments with one from the strategy
+func (q *positionalQuery) SQLQueryInterpolated(strategy interpolation_strategy.InterpolateStrategy) string {
+	interpolated, _ := replacePlaceholders(q.query, strategy)
+	return interpolated
+}
+
+// Interpolate returns the query for the strategy and the parameters in placeholder order
+func (q *positionalQuery) Interpolate(sqlQuery string, strategy interpolation_strategy.InterpolateStrategy) (interpolatedSQLQuery string, params []interface{}, err error) {
+	interpolatedSQLQuery, placeholders := replacePlaceholders(sqlQuery, strategy)
+	if placeholders != len(q.params) {
+		return "", nil, vparam.ErrParameterPlaceholderMismatch
+	}
+	return interpolatedSQLQuery, q.params, nil
+}
+
+func replacePlaceholders(sqlQuery string, strategy interpolation_strategy.InterpolateStrategy) (interpolated string, placeholders int) {
+	sb := strings.Builder{}
+	last := 0
+	for _, t := range tokenizeSQL(sqlQuery) {
+		if t.kind == tokenPlaceholder && t.text == vparam.AppenderPlaceholder {
+			sb.WriteString(sqlQuery[last:t.start])
+			sb.WriteString(strategy.InsertPlaceholderIntoSQL())
+			last = t.end
+			placeholders++
+		}
+	}
+	sb.WriteString(sqlQuery[last:])
+	return sb.String(), placeholders
+}
+
+// NewVerbatimQuery creates a query without parameters that is sent exactly as written. Question marks are not
+// placeholders, so operators such as postgres' jsonb ? work. Use it to run scripts
+func NewVerbatimQuery(query string) vparam.Queryer {
+	return verbatimQuery(query)
+}
+
+// verbatimQuery is a query without placeholders
+type verbatimQuery string
+
+// SQLQueryUnInterpolated returns the query
+func (q verbatimQuery) SQLQueryUnInterpolated() string {
+	return string(q)
+}
+
+// SQLQueryInterpolated returns the query, as it has no placeholders for the strategy to render
+func (q verbatimQuery) SQLQueryInterpolated(_ interpolation_strategy.InterpolateStrategy) string {
+	return string(q)
+}
+
+// Interpolate returns the query unchanged and no parameters
+func (q verbatimQuery) Interpolate(sqlQuery string, _ interpolation_strategy.InterpolateStrategy) (interpolatedSQLQuery string, params []interface{}, err error) {
+	return sqlQuery, nil, nil
+}
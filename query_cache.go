@@ -0,0 +1,372 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryCacheConfig sets the limits of a QueryCache
+type QueryCacheConfig struct {
+	// MaxEntries is the most result sets kept. Zero means no limit
+	MaxEntries int
+	// MaxBytes is the approximate most memory the cached values may use. Zero means no limit. Result sets larger than
+	// this are never cached
+	MaxBytes int64
+	// DefaultTTL is how long results are kept when the query did not ask for a TTL. Zero keeps them until they are evicted or invalidated
+	DefaultTTL time.Duration
+	// ShardResolver is the resolver given to InstallSingleSharded, if it is not ShardFromContext. Results are cached
+	// per shard, so the shard of each query must be known. Shard ids set with WithShard are always used
+	ShardResolver ShardResolver
+}
+
+// QueryCacheStats are the counters of a QueryCache
+type QueryCacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64
+	Invalidations uint64
+	// Entries is the number of result sets currently cached
+	Entries int
+	// Bytes is the approximate memory used by the cached values
+	Bytes int64
+}
+
+// QueryCache holds fully read result sets of queries marked as cacheable, keyed by the query's fingerprint, the
+// values of its literals and arguments and the database it is routed to: its shard, and whether it was sent to the
+// primary with WithPrimary. Only queries made outside of transactions are cached. Install it with
+// InstallQueryCache and mark queries with WithQueryCache or Cacheable
+type QueryCache struct {
+	cfg QueryCacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds *queryCacheEntry, most recently used at the front
+	lru *list.List
+	// tags maps each table tag to the keys of the entries tagged with it
+	tags  map[string]map[string]struct{}
+	bytes int64
+	stats QueryCacheStats
+	// generation is incremented by every invalidation so that results read before a write are not cached after it
+	generation uint64
+}
+
+type queryCacheEntry struct {
+	key     string
+	columns []string
+	values  [][]interface{}
+	tags    []string
+	bytes   int64
+	expires time.Time
+}
+
+// NewQueryCache creates an empty cache with the limits in the config
+func NewQueryCache(cfg QueryCacheConfig) *QueryCache {
+	return &QueryCache{
+		cfg:     cfg,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		tags:    make(map[string]map[string]struct{}),
+	}
+}
+
+// queryCachePolicy is how a query asked to be cached
+type queryCachePolicy struct {
+	ttl  time.Duration
+	tags []string
+}
+
+// WithQueryCache returns a context.Context that makes queries run with it cacheable
+// @param ttl how long to keep the results, zero uses QueryCacheConfig.DefaultTTL
+// @param tags the table tags used to invalidate the results, usually the names of the tables read by the query. Tags are
+// case-insensitive
+func WithQueryCache(ctx context.Context, ttl time.Duration, tags ...string) context.Context {
+	return context.WithValue(ctx, queryCacheContextKey, queryCachePolicy{ttl: ttl, tags: tags})
+}
+
+// Cacheable marks a single query as cacheable, see WithQueryCache
+func Cacheable(query vparam.Queryer, ttl time.Duration, tags ...string) vparam.Queryer {
+	return &cacheableQuery{
+		Queryer: query,
+		policy:  queryCachePolicy{ttl: ttl, tags: tags},
+	}
+}
+
+type cacheableQuery struct {
+	vparam.Queryer
+	policy queryCachePolicy
+}
+
+func cachePolicyOf(ctx context.Context, query vparam.Queryer) (policy queryCachePolicy, ok bool) {
+	if q, isCacheable := query.(*cacheableQuery); isCacheable {
+		return q.policy, true
+	}
+	if ctx != nil {
+		policy, ok = ctx.Value(queryCacheContextKey).(queryCachePolicy)
+	}
+	return
+}
+
+// InstallQueryCache adds middleware that answers cacheable queries from the cache, and caches the results of those
+// that miss. A hit does not call the rest of the middleware. Writes invalidate the cached results of the tables they
+// touch, see WithInvalidation
+// @param factory is the same interpolation strategy factory given to the installer, used to read the query arguments
+func InstallQueryCache(engine vsql_engine.SQLQueryer, cache *QueryCache, factory interpolation_strategy.InterpolationStrategyFactory) {
+	engine.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		policy, ok := cachePolicyOf(ctx, c.Query())
+		if !ok || c.QueryExecTransactioner() != nil {
+			c.Next(ctx)
+			return
+		}
+		key, err := queryCacheKey(ctx, c.Query(), factory, cache.cfg.ShardResolver)
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		if entry, hit := cache.get(key); hit {
+			c.SetRows(newMemoryRows(entry.columns, entry.values))
+			return
+		}
+		generation := cache.currentGeneration()
+		c.Next(ctx)
+		if c.Error() != nil || c.Rows() == nil {
+			return
+		}
+		columns, values, err := readAllRowser(c.Rows())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		cache.putIfCurrent(key, columns, values, policy, generation)
+		c.SetRows(newMemoryRows(columns, values))
+	})
+	installCacheInvalidation(engine, cache)
+}
+
+// queryCacheKey identifies a result set by the query's fingerprint, the literals in the query, the arguments and the
+// database the query is routed to
+// @param resolver is the shard resolver of the engine, if it is not ShardFromContext
+func queryCacheKey(ctx context.Context, query vparam.Queryer, factory interpolation_strategy.InterpolationStrategyFactory, resolver ShardResolver) (string, error) {
+	sqlQ := query.SQLQueryUnInterpolated()
+	_, args, err := query.Interpolate(sqlQ, factory())
+	if err != nil {
+		return "", err
+	}
+	fp := fingerprintOf(ctx, sqlQ)
+	h := sha256.New()
+	if err = writeRouteIdentity(h, ctx, resolver); err != nil {
+		return "", err
+	}
+	for _, t := range tokenizeSQL(sqlQ) {
+		if t.kind == tokenString || t.kind == tokenNumber {
+			_, _ = fmt.Fprintf(h, "%s\x00", t.text)
+		}
+	}
+	for _, arg := range args {
+		_, _ = fmt.Fprintf(h, "%T:%v\x00", arg, arg)
+	}
+	return fp.Hash + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeRouteIdentity writes what decides the database a query is sent to: the shard id and the use of the primary
+// instead of a replica. Replicas are interchangeable, so results read from any of them are shared
+func writeRouteIdentity(w io.Writer, ctx context.Context, resolver ShardResolver) error {
+	ids := make([]string, 0, 2)
+	if id, err := ShardFromContext(ctx); err == nil {
+		ids = append(ids, id)
+	}
+	if resolver != nil {
+		id, err := resolver(ctx)
+		if err != nil && err != ErrShardKeyMissing {
+			return err
+		}
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		_, _ = fmt.Fprintf(w, "shard:%s\x00", id)
+	}
+	if usePrimary(ctx) {
+		_, _ = io.WriteString(w, "primary\x00")
+	}
+	return nil
+}
+
+// Invalidate removes every result set tagged with any of the tags. Tags are case-insensitive. Without tags, nothing is
+// invalidated
+func (c *QueryCache) Invalidate(tags ...string) {
+	if len(tags) == 0 {
+		// results being read stay cacheable, as nothing they depend on was written
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, tag := range normalizeTags(tags) {
+		for key := range c.tags[tag] {
+			if el, ok := c.entries[key]; ok {
+				c.removeLocked(el)
+				c.stats.Invalidations++
+			}
+		}
+		delete(c.tags, tag)
+	}
+}
+
+// Clear removes every result set
+func (c *QueryCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.tags = make(map[string]map[string]struct{})
+	c.bytes = 0
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *QueryCache) Stats() QueryCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.lru.Len()
+	s.Bytes = c.bytes
+	return s
+}
+
+func (c *QueryCache) get(key string) (*queryCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	entry := el.Value.(*queryCacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.removeLocked(el)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+	return entry, true
+}
+
+func (c *QueryCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// putIfCurrent caches the result set unless the cache was invalidated since generation was read
+func (c *QueryCache) putIfCurrent(key string, columns []string, values [][]interface{}, policy queryCachePolicy, generation uint64) {
+	entry := &queryCacheEntry{
+		key:     key,
+		columns: columns,
+		values:  values,
+		tags:    normalizeTags(policy.tags),
+		bytes:   resultBytes(columns, values),
+	}
+	if c.cfg.MaxBytes > 0 && entry.bytes > c.cfg.MaxBytes {
+		return
+	}
+	ttl := policy.ttl
+	if ttl <= 0 {
+		ttl = c.cfg.DefaultTTL
+	}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.bytes += entry.bytes
+	for _, tag := range entry.tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	for (c.cfg.MaxEntries > 0 && c.lru.Len() > c.cfg.MaxEntries) || (c.cfg.MaxBytes > 0 && c.bytes > c.cfg.MaxBytes) {
+		c.removeLocked(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// normalizeTags lower-cases the tags, as the tags parsed from writes are table names, which are usually case-insensitive
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, len(tags))
+	for i, tag := range tags {
+		normalized[i] = strings.ToLower(tag)
+	}
+	return normalized
+}
+
+// removeLocked removes the entry from the cache and its tags. Caller must hold the lock
+func (c *QueryCache) removeLocked(el *list.Element) {
+	entry := c.lru.Remove(el).(*queryCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.bytes
+	for _, tag := range entry.tags {
+		delete(c.tags[tag], entry.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+// resultBytes approximates the memory used by a result set
+func resultBytes(columns []string, values [][]interface{}) (total int64) {
+	for _, column := range columns {
+		total += int64(len(column))
+	}
+	for _, row := range values {
+		for _, v := range row {
+			// the interface header, plus the data for variable length values
+			total += 16
+			switch d := v.(type) {
+			case []byte:
+				total += int64(len(d))
+			case string:
+				total += int64(len(d))
+			}
+		}
+	}
+	return
+}
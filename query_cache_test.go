@@ -0,0 +1,305 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"testing"
+	"time"
+)
+
+func TestQueryCache(t *testing.T) {
+	cache := NewQueryCache(QueryCacheConfig{MaxEntries: 2})
+	engine, _ := newSQLiteEngine(t, Config{})
+	InstallQueryCache(engine, cache, questionMarkFactory)
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE users (id INTEGER, name TEXT)")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Exec(ctx, vparam.New("INSERT INTO users VALUES (1, 'alice'), (2, 'bob')")); err != nil {
+		t.Fatal(err)
+	}
+
+	cached := WithQueryCache(ctx, time.Minute, "users")
+	query := func(ctx context.Context, q vparam.Queryer) (names []string) {
+		rows, err := engine.Query(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = rows.Close() }()
+		for row := rows.Next(); row != nil; row = rows.Next() {
+			var name string
+			if err = row.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		return
+	}
+
+	first := query(cached, vparam.NewAppendWithData("SELECT name FROM users WHERE id >= ? ORDER BY id", 1))
+	second := query(cached, vparam.NewAppendWithData("select name  from users where id >= ? order by id", 1))
+	if len(first) != 2 || len(second) != 2 {
+		t.Errorf("expected alice and bob twice, got %v then %v", first, second)
+	}
+	if s := cache.Stats(); s.Hits != 1 || s.Misses != 1 || s.Entries != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+
+	// different arguments are a different result set
+	if names := query(cached, vparam.NewAppendWithData("SELECT name FROM users WHERE id >= ? ORDER BY id", 2)); len(names) != 1 {
+		t.Errorf("expected bob, got %v", names)
+	}
+
+	cache.Invalidate("users")
+	if s := cache.Stats(); s.Entries != 0 || s.Invalidations != 2 {
+		t.Errorf("unexpected stats after invalidation: %+v", s)
+	}
+	if names := query(ctx, Cacheable(vparam.NewAppendWithData("SELECT name FROM users WHERE id >= ? ORDER BY id", 1), 0, "users")); len(names) != 2 {
+		t.Errorf("expected the invalidated query to be re-read, got %v", names)
+	}
+	if s := cache.Stats(); s.Entries != 1 {
+		t.Errorf("expected the query to be cached again: %+v", s)
+	}
+}
+
+func TestQueryCache_PerShard(t *testing.T) {
+	engine := vsql_engine.NewSingle()
+	InstallSingleSharded(engine, map[string]*sql.DB{"a": openReplica(t, "a"), "b": openReplica(t, "b")}, nil, questionMarkFactory, Config{})
+	cache := NewQueryCache(QueryCacheConfig{})
+	InstallQueryCache(engine, cache, questionMarkFactory)
+	defer func() { _ = engine.Close() }()
+	cached := WithQueryCache(context.Background(), time.Minute, "which")
+
+	for _, shard := range []string{"a", "b", "a", "b"} {
+		if name, err := whichDatabase(WithShard(cached, shard), engine); err != nil || name != shard {
+			t.Errorf("expected the results of shard %s, got %s: %v", shard, name, err)
+		}
+	}
+	if s := cache.Stats(); s.Hits != 2 || s.Misses != 2 || s.Entries != 2 {
+		t.Errorf("expected the results to be cached per shard: %+v", s)
+	}
+}
+
+func TestQueryCache_KeyedByTheQuerysOwnFingerprint(t *testing.T) {
+	engine := vsql_engine.NewSingle()
+	InstallSingle(engine, openReplica(t, "lower"), questionMarkFactory)
+	cache := NewQueryCache(QueryCacheConfig{})
+	InstallQueryCache(engine, cache, questionMarkFactory)
+	defer func() { _ = engine.Close() }()
+	cached := WithQueryCache(context.Background(), time.Minute, "which")
+	if name, err := whichDatabase(cached, engine); err != nil || name != "lower" {
+		t.Fatalf("expected the table's name, got %s: %v", name, err)
+	}
+
+	// a context.Context handed down from the first query carries its fingerprint
+	outer := withFingerprint(cached, vparam.New("SELECT name FROM which"))
+	rows, err := engine.Query(outer, vparam.New("SELECT upper(name) FROM which"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rows.Close() }()
+	var name string
+	if row := rows.Next(); row == nil || row.Scan(&name) != nil || name != "LOWER" {
+		t.Errorf("expected the other query not to be answered with the first one's results, got %s", name)
+	}
+}
+
+func TestQueryCache_PrimaryAndReplicas(t *testing.T) {
+	engine := vsql_engine.NewSingle()
+	InstallSingleReplicated(engine, openReplica(t, "primary"), []*sql.DB{openReplica(t, "replica")}, RoundRobin, questionMarkFactory, Config{})
+	cache := NewQueryCache(QueryCacheConfig{})
+	InstallQueryCache(engine, cache, questionMarkFactory)
+	defer func() { _ = engine.Close() }()
+	cached := WithQueryCache(context.Background(), time.Minute, "which")
+
+	if name, _ := whichDatabase(cached, engine); name != "replica" {
+		t.Errorf("expected the replica's results, got %s", name)
+	}
+	if name, _ := whichDatabase(WithPrimary(cached), engine); name != "primary" {
+		t.Errorf("expected reads from the primary not to be answered with the replica's results, got %s", name)
+	}
+}
+
+func TestQueryCache_InvalidatedByWrites(t *testing.T) {
+	cache := NewQueryCache(QueryCacheConfig{})
+	engine, _ := newSQLiteEngine(t, Config{})
+	InstallQueryCache(engine, cache, questionMarkFactory)
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE users (id INTEGER)")); err != nil {
+		t.Fatal(err)
+	}
+	count := func() (n int) {
+		rows, err := engine.Query(ctx, Cacheable(vparam.New("SELECT COUNT(*) FROM users"), 0, "users"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = rows.Close() }()
+		if err = rows.Next().Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	count()
+	if _, err := engine.Insert(ctx, vparam.New("INSERT INTO users VALUES (1)")); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("expected the insert to invalidate the count, got %d", n)
+	}
+
+	tx, err := engine.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec(ctx, vparam.New("DELETE FROM \"main\".Users")); err != nil {
+		t.Fatal(err)
+	}
+	if s := cache.Stats(); s.Entries != 1 {
+		t.Errorf("expected the write to be held until commit: %+v", s)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if s := cache.Stats(); s.Entries != 1 {
+		t.Errorf("expected the rolled back write to be discarded: %+v", s)
+	}
+
+	tx, err = engine.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := tx.Prepare(ctx, vparam.NewNamed("UPDATE users SET id = :id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stmt.Exec(ctx, vparam.NewNamedData(map[string]interface{}{"id": 2})); err != nil {
+		t.Fatal(err)
+	}
+	_ = stmt.Close()
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if s := cache.Stats(); s.Entries != 0 {
+		t.Errorf("expected the commit to invalidate the count: %+v", s)
+	}
+}
+
+func TestQueryCache_CommitsWithoutWritesKeepResultsBeingRead(t *testing.T) {
+	cache := NewQueryCache(QueryCacheConfig{})
+	engine, _ := newSQLiteEngine(t, Config{})
+	InstallQueryCache(engine, cache, questionMarkFactory)
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+
+	// a result being read while a transaction that wrote nothing commits
+	generation := cache.currentGeneration()
+	tx, err := engine.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Invalidate()
+	cache.putIfCurrent("a", []string{"id"}, [][]interface{}{{int64(1)}}, queryCachePolicy{tags: []string{"users"}}, generation)
+	if s := cache.Stats(); s.Entries != 1 {
+		t.Errorf("expected the result to be cached, as nothing was invalidated: %+v", s)
+	}
+}
+
+func TestQueryCache_TagsAreCaseInsensitive(t *testing.T) {
+	cache := NewQueryCache(QueryCacheConfig{})
+	row := [][]interface{}{{int64(1)}}
+	cache.putIfCurrent("a", []string{"id"}, row, queryCachePolicy{tags: []string{"Users"}}, 0)
+	cache.written(nil, writtenTables("DELETE FROM users"))
+	if s := cache.Stats(); s.Entries != 0 {
+		t.Errorf("expected the write to users to invalidate Users: %+v", s)
+	}
+	cache.putIfCurrent("b", []string{"id"}, row, queryCachePolicy{tags: []string{"orders"}}, cache.currentGeneration())
+	cache.written(nil, writtenTags(WithInvalidation(context.Background(), "ORDERS"), nil))
+	if s := cache.Stats(); s.Entries != 0 {
+		t.Errorf("expected the declared ORDERS tag to invalidate orders: %+v", s)
+	}
+}
+
+func TestWrittenTables(t *testing.T) {
+	cases := map[string][]string{
+		"INSERT INTO Users (id) VALUES (1)":                   {"users"},
+		"insert ignore into `Orders` values (1)":              {"Orders"},
+		"UPDATE low_priority app.accounts SET x = 1":          {"accounts"},
+		"DELETE FROM \"Line\"\"Items\" WHERE id = 1":          {"Line\"Items"},
+		"truncate table logs; drop table if exists tmp":       {"logs", "tmp"},
+		"SELECT * FROM users":                                 nil,
+		"COPY \"users\" (\"id\") FROM STDIN":                  {"users"},
+		"COPY users TO STDOUT":                                nil,
+		"LOAD DATA LOCAL INFILE 'x' INTO TABLE `app`.`Logs`":  {"Logs"},
+		"/* note */ REPLACE INTO [cart] VALUES (1); SELECT 1": {"cart"},
+	}
+	for query, expected := range cases {
+		actual := writtenTables(query)
+		if !equalParts(actual, expected) {
+			t.Errorf("%s: expected %v, got %v", query, expected, actual)
+		}
+	}
+}
+
+func TestQueryCache_Limits(t *testing.T) {
+	cache := NewQueryCache(QueryCacheConfig{MaxEntries: 2, MaxBytes: 100})
+	policy := queryCachePolicy{tags: []string{"t"}}
+	row := [][]interface{}{{int64(1)}}
+	cache.putIfCurrent("a", []string{"id"}, row, policy, 0)
+	cache.putIfCurrent("b", []string{"id"}, row, policy, 0)
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	cache.putIfCurrent("c", []string{"id"}, row, policy, 0)
+	if _, ok := cache.get("b"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	cache.putIfCurrent("big", []string{"blob"}, [][]interface{}{{make([]byte, 200)}}, policy, 0)
+	if _, ok := cache.get("big"); ok {
+		t.Error("expected a result larger than MaxBytes to be skipped")
+	}
+	cache.putIfCurrent("expired", []string{"id"}, row, queryCachePolicy{ttl: time.Nanosecond}, 0)
+	time.Sleep(time.Millisecond)
+	if _, ok := cache.get("expired"); ok {
+		t.Error("expected the entry to expire")
+	}
+}
+
+func TestQueryCache_HitScansLikeGoRows(t *testing.T) {
+	var rows vrows.Rowser = newMemoryRows([]string{"name"}, [][]interface{}{{[]byte("alice")}})
+	row := rows.Next()
+	var b []byte
+	if err := row.Scan(&b); err != nil {
+		t.Fatal(err)
+	}
+	b[0] = 'A'
+	var s string
+	if err := row.Scan(&s); err != nil || s != "alice" {
+		t.Errorf("expected scanning into []byte to copy the cached value, got %q, %v", s, err)
+	}
+}
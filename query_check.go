@@ -0,0 +1,86 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+)
+
+// queryCheck returns the error that refuses the query, or nil to let it run. query is nil for statements not created
+// by this package
+type queryCheck func(ctx context.Context, query vparam.Queryer) error
+
+// installQueryCheck adds middleware that runs the check on every query, insert, exec and statement preparation, and
+// stops the call with the check's error
+func installQueryCheck(engine vsql_engine.SQLQueryer, check queryCheck) {
+	engine.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		if err := check(ctx, c.Query()); err != nil {
+			c.SetError(err)
+			return
+		}
+		c.Next(ctx)
+	})
+	engine.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		if err := check(ctx, c.Query()); err != nil {
+			c.SetError(err)
+			return
+		}
+		c.Next(ctx)
+	})
+	engine.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		if err := check(ctx, c.Query()); err != nil {
+			c.SetError(err)
+			return
+		}
+		c.Next(ctx)
+	})
+	engine.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		if err := check(ctx, c.Query()); err != nil {
+			c.SetError(err)
+			return
+		}
+		c.Next(ctx)
+	})
+}
+
+// installStatementCheck adds middleware that runs the check on every query, insert and exec of a prepared statement
+// with the query it was prepared from, and stops the call with the check's error
+func installStatementCheck(engine vsql_engine.SQLQueryer, check queryCheck) {
+	engine.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		if err := check(ctx, statementQuery(c.Statement())); err != nil {
+			c.SetError(err)
+			return
+		}
+		c.Next(ctx)
+	})
+	engine.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		if err := check(ctx, statementQuery(c.Statement())); err != nil {
+			c.SetError(err)
+			return
+		}
+		c.Next(ctx)
+	})
+	engine.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		if err := check(ctx, statementQuery(c.Statement())); err != nil {
+			c.SetError(err)
+			return
+		}
+		c.Next(ctx)
+	})
+}
@@ -0,0 +1,126 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"sync/atomic"
+)
+
+// ReplicaBalancer chooses how reads are spread across replicas
+type ReplicaBalancer int
+
+const (
+	// RoundRobin sends each read to the next replica in turn
+	RoundRobin ReplicaBalancer = iota
+	// LeastConnections sends each read to the replica with the fewest connections in use
+	LeastConnections
+)
+
+// InstallSingleReplicated is InstallSingleWithConfig for a primary database with read replicas. Queries that are not
+// part of a transaction are sent to a replica, everything else: execs, inserts, transactions and prepared statements
+// are sent to the primary. Use WithPrimary to force reads to the primary, such as when reading your own writes.
+// @param primary is the database that receives all writes
+// @param replicas are the databases that receive reads. If empty, reads go to the primary
+// @param balancer chooses how reads are spread across the replicas
+func InstallSingleReplicated(engine vsql_engine.SingleTXer, primary *sql.DB, replicas []*sql.DB, balancer ReplicaBalancer, factory interpolation_strategy.InterpolationStrategyFactory, cfg Config) {
+	r := newReplicaRouter(primary, replicas, balancer)
+	r.health = cfg.ReplicaHealth
+	installSingle(engine, r, factory, cfg)
+	if r.health != nil {
+		// stop checking before the databases are closed
+		engine.ConnCloseMW().Prepend(func(ctx context.Context, c engine_context.Er) {
+			r.health.Stop()
+			c.Next(ctx)
+		})
+	}
+}
+
+// WithPrimary returns a context.Context that sends reads made with it to the primary database instead of a replica
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v, _ := ctx.Value(primaryContextKey).(bool)
+	return v
+}
+
+type replicaRouter struct {
+	primary  *sql.DB
+	replicas []*sql.DB
+	balancer ReplicaBalancer
+	// health, if set, removes unhealthy replicas from consideration
+	health *ReplicaHealth
+	// next is the round-robin position, accessed atomically
+	next uint64
+}
+
+func newReplicaRouter(primary *sql.DB, replicas []*sql.DB, balancer ReplicaBalancer) *replicaRouter {
+	return &replicaRouter{
+		primary:  primary,
+		replicas: replicas,
+		balancer: balancer,
+	}
+}
+
+func (r *replicaRouter) route(ctx context.Context, op operation) (*sql.DB, error) {
+	if op != opQuery || len(r.replicas) == 0 || usePrimary(ctx) {
+		return r.primary, nil
+	}
+	candidates := r.replicas
+	if r.health != nil {
+		candidates = r.health.healthy(candidates)
+		// with no healthy replicas, the primary is the only place left to read from
+		if len(candidates) == 0 {
+			return r.primary, nil
+		}
+	}
+	return r.pick(candidates), nil
+}
+
+// pick chooses a replica from the candidates using the balancer
+func (r *replicaRouter) pick(candidates []*sql.DB) *sql.DB {
+	start := int(atomic.AddUint64(&r.next, 1) % uint64(len(candidates)))
+	if r.balancer != LeastConnections {
+		return candidates[start]
+	}
+	// start at the round-robin position so that ties are spread out instead of always landing on the first replica
+	best := candidates[start]
+	bestInUse := best.Stats().InUse
+	for i := 1; i < len(candidates); i++ {
+		candidate := candidates[(start+i)%len(candidates)]
+		if inUse := candidate.Stats().InUse; inUse < bestInUse {
+			best, bestInUse = candidate, inUse
+		}
+	}
+	return best
+}
+
+func (r *replicaRouter) all() []*sql.DB {
+	return append([]*sql.DB{r.primary}, r.replicas...)
+}
+
+func (r *replicaRouter) checkBound(ctx context.Context, bound *sql.DB) error {
+	return nil
+}
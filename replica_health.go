@@ -0,0 +1,307 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ReplicaLagFunc measures how far behind the primary a replica is. Use MySQLReplicaLag, PostgresReplicaLag or your own
+type ReplicaLagFunc func(ctx context.Context, db *sql.DB) (lag time.Duration, err error)
+
+// ErrReplicaNotReplicating is returned by the lag functions when the database is not replicating from a primary
+var ErrReplicaNotReplicating = errors.New("replica is not replicating")
+
+// MySQLReplicaLag reads Seconds_Behind_Source from SHOW REPLICA STATUS. Servers older than MySQL 8.0.22, and MariaDB
+// before 10.5.1, do not know that statement, so Seconds_Behind_Master from SHOW SLAVE STATUS is read instead
+func MySQLReplicaLag(ctx context.Context, db *sql.DB) (lag time.Duration, err error) {
+	return replicaStatusLag(ctx, db, "SHOW REPLICA STATUS", "SHOW SLAVE STATUS")
+}
+
+// replicaStatusLag runs each statement until one succeeds and reads the seconds behind the primary from its result
+// @return err the error of the last statement if none succeeded
+func replicaStatusLag(ctx context.Context, db *sql.DB, statements ...string) (lag time.Duration, err error) {
+	for _, statement := range statements {
+		var rows *sql.Rows
+		rows, err = db.QueryContext(ctx, statement)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, err
+			}
+			continue
+		}
+		return readSecondsBehind(rows)
+	}
+	return 0, err
+}
+
+// readSecondsBehind reads Seconds_Behind_Source, or Seconds_Behind_Master as older servers and MariaDB name it, from
+// the result of a replica status statement and closes the rows
+func readSecondsBehind(rows *sql.Rows) (lag time.Duration, err error) {
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, ErrReplicaNotReplicating
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		// NULL means the replication threads are not running
+		if !values[i].Valid {
+			return 0, ErrReplicaNotReplicating
+		}
+		var seconds int64
+		if _, err = fmt.Sscan(values[i].String, &seconds); err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, ErrReplicaNotReplicating
+}
+
+// PostgresReplicaLag compares the time of the last replayed transaction to now. A replica with no writes to replay
+// reports the time since the last write, so pair this with a periodic heartbeat write on the primary if writes are rare
+func PostgresReplicaLag(ctx context.Context, db *sql.DB) (lag time.Duration, err error) {
+	var seconds sql.NullFloat64
+	err = db.QueryRowContext(ctx, "SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())").Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+	if !seconds.Valid {
+		return 0, ErrReplicaNotReplicating
+	}
+	return time.Duration(seconds.Float64 * float64(time.Second)), nil
+}
+
+// HealthConfig controls how replicas are checked
+type HealthConfig struct {
+	// Interval is the time between checks. Defaults to 5 seconds
+	Interval time.Duration
+	// Timeout bounds each ping and lag measurement. Defaults to Interval
+	Timeout time.Duration
+	// Lag measures replication lag. If nil, only pings are checked
+	Lag ReplicaLagFunc
+	// MaxLag is the most a replica may lag before it is ejected. Ignored if Lag is nil or MaxLag is zero
+	MaxLag time.Duration
+	// FailureThreshold is the number of consecutive failed checks before a replica is ejected. Defaults to 1
+	FailureThreshold int
+	// RecoveryThreshold is the number of consecutive passed checks before an ejected replica is reinstated. Defaults to 1
+	RecoveryThreshold int
+}
+
+// ReplicaState is a snapshot of what is known about a replica
+type ReplicaState struct {
+	// Index is the position of the replica in the list passed to NewReplicaHealth
+	Index int
+	// Healthy is true if the replica receives reads
+	Healthy bool
+	// Lag is the most recently measured replication lag
+	Lag time.Duration
+	// LastError is why the most recent check failed, nil if it passed
+	LastError error
+	// LastChecked is when the most recent check finished, zero if never checked
+	LastChecked time.Time
+	// ConsecutiveFailures is the number of checks that have failed in a row
+	ConsecutiveFailures int
+	// ConsecutiveSuccesses is the number of checks that have passed in a row
+	ConsecutiveSuccesses int
+}
+
+// ReplicaHealth periodically pings replicas and measures their lag, ejecting them from read routing when they fail
+// and reinstating them once they recover. Pass it to InstallSingleReplicated using Config.ReplicaHealth.
+// Replicas start out healthy until checked.
+type ReplicaHealth struct {
+	replicas []*sql.DB
+	cfg      HealthConfig
+
+	mu     sync.RWMutex
+	states []ReplicaState
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	started   bool
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// NewReplicaHealth creates a health tracker for the replicas. Call Start to begin checking
+func NewReplicaHealth(replicas []*sql.DB, cfg HealthConfig) *ReplicaHealth {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = cfg.Interval
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 1
+	}
+	if cfg.RecoveryThreshold <= 0 {
+		cfg.RecoveryThreshold = 1
+	}
+	h := &ReplicaHealth{
+		replicas: replicas,
+		cfg:      cfg,
+		states:   make([]ReplicaState, len(replicas)),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	for i := range h.states {
+		h.states[i].Index = i
+		h.states[i].Healthy = true
+	}
+	return h
+}
+
+// Start checks every replica immediately, then again every Interval until Stop is called
+func (h *ReplicaHealth) Start() {
+	h.startOnce.Do(h.run)
+}
+
+func (h *ReplicaHealth) run() {
+	h.mu.Lock()
+	h.started = true
+	h.mu.Unlock()
+	go func() {
+		defer close(h.stopped)
+		ticker := time.NewTicker(h.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			h.Check(context.Background())
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends periodic checking and waits for a check in progress to finish. Safe to call more than once and if Start was never called
+func (h *ReplicaHealth) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+	})
+	h.mu.RLock()
+	started := h.started
+	h.mu.RUnlock()
+	if started {
+		<-h.stopped
+	}
+}
+
+// Check checks all replicas concurrently and updates their states
+func (h *ReplicaHealth) Check(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	wg.Add(len(h.replicas))
+	for i, db := range h.replicas {
+		go func(i int, db *sql.DB) {
+			defer wg.Done()
+			lag, err := h.checkOne(ctx, db)
+			h.record(i, lag, err)
+		}(i, db)
+	}
+	wg.Wait()
+}
+
+func (h *ReplicaHealth) checkOne(ctx context.Context, db *sql.DB) (lag time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+	if err = db.PingContext(ctx); err != nil {
+		return 0, err
+	}
+	if h.cfg.Lag == nil {
+		return 0, nil
+	}
+	lag, err = h.cfg.Lag(ctx, db)
+	if err != nil {
+		return lag, err
+	}
+	if h.cfg.MaxLag > 0 && lag > h.cfg.MaxLag {
+		return lag, fmt.Errorf("replica lag of %s exceeds the maximum of %s", lag, h.cfg.MaxLag)
+	}
+	return lag, nil
+}
+
+func (h *ReplicaHealth) record(i int, lag time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &h.states[i]
+	s.Lag = lag
+	s.LastError = err
+	s.LastChecked = time.Now()
+	if err != nil {
+		s.ConsecutiveFailures++
+		s.ConsecutiveSuccesses = 0
+		if s.ConsecutiveFailures >= h.cfg.FailureThreshold {
+			s.Healthy = false
+		}
+		return
+	}
+	s.ConsecutiveSuccesses++
+	s.ConsecutiveFailures = 0
+	if s.ConsecutiveSuccesses >= h.cfg.RecoveryThreshold {
+		s.Healthy = true
+	}
+}
+
+// States returns a snapshot of every replica's state, in the order the replicas were given to NewReplicaHealth
+func (h *ReplicaHealth) States() []ReplicaState {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]ReplicaState, len(h.states))
+	copy(out, h.states)
+	return out
+}
+
+// healthy filters the candidates down to those that are healthy. Databases this tracker does not know about are considered healthy
+func (h *ReplicaHealth) healthy(candidates []*sql.DB) []*sql.DB {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]*sql.DB, 0, len(candidates))
+	for _, candidate := range candidates {
+		healthy := true
+		for i, db := range h.replicas {
+			if db == candidate {
+				healthy = h.states[i].Healthy
+				break
+			}
+		}
+		if healthy {
+			out = append(out, candidate)
+		}
+	}
+	return out
+}
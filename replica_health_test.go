@@ -0,0 +1,156 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/wojnosystems/vsql_engine"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplicaHealth_EjectsAndReinstates(t *testing.T) {
+	a, b := &sql.DB{}, &sql.DB{}
+	h := NewReplicaHealth([]*sql.DB{a, b}, HealthConfig{FailureThreshold: 2, RecoveryThreshold: 2})
+
+	h.record(0, 0, errors.New("down"))
+	if len(h.healthy([]*sql.DB{a, b})) != 2 {
+		t.Fatal("expected replica to stay healthy below the failure threshold")
+	}
+	h.record(0, 0, errors.New("down"))
+	if healthy := h.healthy([]*sql.DB{a, b}); len(healthy) != 1 || healthy[0] != b {
+		t.Fatal("expected replica to be ejected at the failure threshold")
+	}
+	h.record(0, 0, nil)
+	if len(h.healthy([]*sql.DB{a, b})) != 1 {
+		t.Fatal("expected replica to stay ejected below the recovery threshold")
+	}
+	h.record(0, 0, nil)
+	if len(h.healthy([]*sql.DB{a, b})) != 2 {
+		t.Fatal("expected replica to be reinstated at the recovery threshold")
+	}
+	if states := h.States(); !states[0].Healthy || states[0].ConsecutiveSuccesses != 2 {
+		t.Errorf("unexpected state: %+v", states[0])
+	}
+}
+
+func TestReplicaStatusLag(t *testing.T) {
+	db := openReplica(t, "replica")
+	defer func() { _ = db.Close() }()
+	ctx := context.Background()
+	// SQLite does not know SHOW, standing in for a server that predates SHOW REPLICA STATUS
+	if lag, err := replicaStatusLag(ctx, db, "SHOW REPLICA STATUS", "SELECT 5 AS Seconds_Behind_Master"); err != nil || lag != 5*time.Second {
+		t.Errorf("expected to fall back to the older statement, got %s: %v", lag, err)
+	}
+	if lag, err := replicaStatusLag(ctx, db, "SELECT 2 AS Seconds_Behind_Source"); err != nil || lag != 2*time.Second {
+		t.Errorf("expected Seconds_Behind_Source to be read, got %s: %v", lag, err)
+	}
+	if _, err := replicaStatusLag(ctx, db, "SELECT NULL AS Seconds_Behind_Source"); err != ErrReplicaNotReplicating {
+		t.Errorf("expected ErrReplicaNotReplicating, got: %v", err)
+	}
+	if _, err := replicaStatusLag(ctx, db, "SHOW REPLICA STATUS", "SHOW SLAVE STATUS"); err == nil {
+		t.Error("expected the error of the last statement")
+	}
+}
+
+func TestReplicaHealth_EjectsReplicasOverMaxLag(t *testing.T) {
+	current, behind := openReplica(t, "current"), openReplica(t, "behind")
+	defer func() { _ = current.Close() }()
+	defer func() { _ = behind.Close() }()
+	lags := map[*sql.DB]time.Duration{current: time.Second, behind: time.Minute}
+	h := NewReplicaHealth([]*sql.DB{current, behind}, HealthConfig{
+		Lag: func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+			return lags[db], nil
+		},
+		MaxLag: 10 * time.Second,
+	})
+	h.Check(context.Background())
+	states := h.States()
+	if !states[0].Healthy || states[0].Lag != time.Second || states[0].LastError != nil || states[0].LastChecked.IsZero() {
+		t.Errorf("expected the current replica to stay healthy: %+v", states[0])
+	}
+	if states[1].Healthy || states[1].Lag != time.Minute || states[1].LastError == nil {
+		t.Errorf("expected the replica over the maximum lag to be ejected: %+v", states[1])
+	}
+}
+
+func TestReplicaHealth_StartAndStop(t *testing.T) {
+	db := openReplica(t, "replica")
+	defer func() { _ = db.Close() }()
+	mu := sync.Mutex{}
+	checks := 0
+	countChecks := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return checks
+	}
+	h := NewReplicaHealth([]*sql.DB{db}, HealthConfig{
+		Interval: time.Millisecond,
+		Lag: func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			checks++
+			return 0, nil
+		},
+	})
+	h.Start()
+	h.Start()
+	waitFor(t, func() bool {
+		return countChecks() >= 3
+	})
+	h.Stop()
+	h.Stop()
+	stoppedAt := countChecks()
+	time.Sleep(10 * time.Millisecond)
+	if countChecks() != stoppedAt {
+		t.Error("expected no checks after Stop")
+	}
+
+	// stopping a tracker that was never started does not wait for it
+	NewReplicaHealth([]*sql.DB{db}, HealthConfig{}).Stop()
+}
+
+func TestInstallSingleReplicated_RoutesAroundUnhealthyReplicas(t *testing.T) {
+	primary, up, down := openReplica(t, "primary"), openReplica(t, "up"), openReplica(t, "down")
+	failing := map[*sql.DB]bool{down: true}
+	h := NewReplicaHealth([]*sql.DB{up, down}, HealthConfig{
+		Lag: func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+			if failing[db] {
+				return 0, errors.New("replication stopped")
+			}
+			return 0, nil
+		},
+	})
+	engine := vsql_engine.NewSingle()
+	InstallSingleReplicated(engine, primary, []*sql.DB{up, down}, RoundRobin, questionMarkFactory, Config{ReplicaHealth: h})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+
+	h.Check(ctx)
+	for i := 0; i < 4; i++ {
+		if name, err := whichDatabase(ctx, engine); err != nil || name != "up" {
+			t.Errorf("expected reads to avoid the unhealthy replica, got %s: %v", name, err)
+		}
+	}
+	failing[up] = true
+	h.Check(ctx)
+	if name, err := whichDatabase(ctx, engine); err != nil || name != "primary" {
+		t.Errorf("expected reads to go to the primary without healthy replicas, got %s: %v", name, err)
+	}
+}
@@ -0,0 +1,133 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vquery"
+	"github.com/wojnosystems/vsql_engine"
+	"testing"
+)
+
+func TestReplicaRouter_RoundRobin(t *testing.T) {
+	primary, a, b := &sql.DB{}, &sql.DB{}, &sql.DB{}
+	r := newReplicaRouter(primary, []*sql.DB{a, b}, RoundRobin)
+	ctx := context.Background()
+
+	seen := map[*sql.DB]int{}
+	for i := 0; i < 4; i++ {
+		db, err := r.route(ctx, opQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[db]++
+	}
+	if seen[a] != 2 || seen[b] != 2 {
+		t.Errorf("expected reads to alternate between the replicas, got a=%d b=%d", seen[a], seen[b])
+	}
+	for _, op := range []operation{opExec, opBegin, opPrepare} {
+		if db, _ := r.route(ctx, op); db != primary {
+			t.Errorf("expected %s to go to the primary", op)
+		}
+	}
+	if db, _ := r.route(WithPrimary(ctx), opQuery); db != primary {
+		t.Error("expected WithPrimary to send reads to the primary")
+	}
+	if db, _ := newReplicaRouter(primary, nil, RoundRobin).route(ctx, opQuery); db != primary {
+		t.Error("expected reads to go to the primary without replicas")
+	}
+}
+
+func TestReplicaRouter_LeastConnections(t *testing.T) {
+	busy, idle := openReplica(t, "busy"), openReplica(t, "idle")
+	defer func() { _ = busy.Close() }()
+	defer func() { _ = idle.Close() }()
+	conn, err := busy.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	r := newReplicaRouter(&sql.DB{}, []*sql.DB{busy, idle}, LeastConnections)
+	for i := 0; i < 4; i++ {
+		if db, _ := r.route(context.Background(), opQuery); db != idle {
+			t.Fatal("expected reads to go to the replica with the fewest connections in use")
+		}
+	}
+}
+
+func TestInstallSingleReplicated(t *testing.T) {
+	primary, replica := openReplica(t, "primary"), openReplica(t, "replica")
+	engine := vsql_engine.NewSingle()
+	InstallSingleReplicated(engine, primary, []*sql.DB{replica}, RoundRobin, questionMarkFactory, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+
+	if name, err := whichDatabase(ctx, engine); err != nil || name != "replica" {
+		t.Errorf("expected reads to go to the replica, got %s: %v", name, err)
+	}
+	if name, err := whichDatabase(WithPrimary(ctx), engine); err != nil || name != "primary" {
+		t.Errorf("expected WithPrimary reads to go to the primary, got %s: %v", name, err)
+	}
+	if _, err := engine.Exec(ctx, vparam.New("UPDATE which SET name = 'written'")); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := whichDatabase(WithPrimary(ctx), engine); name != "written" {
+		t.Error("expected writes to go to the primary")
+	}
+
+	tx, err := engine.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := whichDatabase(ctx, tx); err != nil || name != "written" {
+		t.Errorf("expected reads in a transaction to go to the primary, got %s: %v", name, err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openReplica opens a private in-memory database with a table naming it
+func openReplica(t *testing.T, name string) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec("CREATE TABLE which (name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO which VALUES (?)", name); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// whichDatabase returns the name of the database the query was sent to
+func whichDatabase(ctx context.Context, q vquery.Queryer) (name string, err error) {
+	rows, err := q.Query(ctx, vparam.New("SELECT name FROM which"))
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = rows.Close() }()
+	if row := rows.Next(); row != nil {
+		err = row.Scan(&name)
+	}
+	return
+}
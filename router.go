@@ -0,0 +1,50 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+)
+
+// router picks the database a call that is not part of a transaction is made against. Calls in a transaction always
+// use the database the transaction was started on
+type router interface {
+	// route returns the database to perform the operation on
+	route(ctx context.Context, op operation) (*sql.DB, error)
+	// all returns every database the router knows about, these are pinged and closed together
+	all() []*sql.DB
+	// checkBound verifies that a call made in a transaction or on a prepared statement, which are tied to the database
+	// bound, is allowed. bound is nil if the database is not known
+	checkBound(ctx context.Context, bound *sql.DB) error
+}
+
+// singleRouter sends every call to the same database
+type singleRouter struct {
+	db *sql.DB
+}
+
+func (r *singleRouter) route(ctx context.Context, op operation) (*sql.DB, error) {
+	return r.db, nil
+}
+
+func (r *singleRouter) all() []*sql.DB {
+	return []*sql.DB{r.db}
+}
+
+func (r *singleRouter) checkBound(ctx context.Context, bound *sql.DB) error {
+	return nil
+}
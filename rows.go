@@ -16,12 +16,15 @@
 package vsql_engine_go
 
 import (
+	"context"
 	"database/sql"
 	"github.com/wojnosystems/vsql/vrows"
 )
 
 type goRows struct {
-	sqlRows            *sql.Rows
+	sqlRows *sql.Rows
+	// cancel releases the deadline the rows were queried with, if any. Called when the rows are closed
+	cancel context.CancelFunc
 }
 
 // Next calls Next() on the sql.Rows object
@@ -34,7 +37,40 @@ func (m *goRows) Next() vrows.Rower {
 	return nil
 }
 
-// Close cleans up the Rows object, releasing it's object back to the pool. Call this when you're done with your vquery results
+// Close cleans up the Rows object, releasing it's object back to the pool. Call this when you're done with your vquery results.
+// As vrows.Rowser has no Err, the error that ended reading the rows early, if any, is returned here
 func (m *goRows) Close() error {
-	return m.sqlRows.Close()
+	err := m.sqlRows.Close()
+	if err == nil {
+		err = m.sqlRows.Err()
+	}
+	if m.cancel != nil {
+		m.cancel()
+	}
+	return err
+}
+
+// cancelOnClose ties the cancel function of a query's context.Context to the rows so the deadline is released when the
+// rows are closed instead of when the query returns, which would abort reading the rows
+func cancelOnClose(rows vrows.Rowser, cancel context.CancelFunc) vrows.Rowser {
+	if r, ok := rows.(*goRows); ok {
+		r.cancel = cancel
+		return r
+	}
+	return &cancelingRows{
+		Rowser: rows,
+		cancel: cancel,
+	}
+}
+
+type cancelingRows struct {
+	vrows.Rowser
+	cancel context.CancelFunc
+}
+
+// Close closes the wrapped rows, then releases the deadline
+func (m *cancelingRows) Close() error {
+	err := m.Rowser.Close()
+	m.cancel()
+	return err
 }
@@ -0,0 +1,43 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"testing"
+)
+
+func TestGoRows_CloseReturnsTheErrorThatEndedTheRows(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rows, err := engine.Query(ctx, vparam.New("WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 100000) SELECT i FROM n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() == nil {
+		t.Fatal("expected rows")
+	}
+	cancel()
+	// rows read before the cancellation was noticed may still be returned
+	for rows.Next() != nil {
+	}
+	if err = rows.Close(); !IsCanceled(err) {
+		t.Errorf("expected the cancellation that ended the rows, got: %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vquery"
+	"github.com/wojnosystems/vsql/vrows"
+	"sync"
+)
+
+// ScatterOptions controls how ScatterQuery runs a query across shards and merges the results
+type ScatterOptions struct {
+	// Parallelism is the most shards queried at once. Zero or less queries all of them at once
+	Parallelism int
+	// OrderBy is the name of the column to merge the results by. Each shard must return its rows already sorted by
+	// this column, such as with ORDER BY, the results are then interleaved to keep the order. If empty, the results are
+	// concatenated in the order of the shards.
+	// The merge order is binary: numbers and times compare by value and text compares byte by byte. It matches the order
+	// of the shards only for columns they sort the same way, such as numbers or text with a binary collation like C or
+	// utf8mb4_bin. Text sorted by a case-insensitive or language collation may be merged out of order
+	OrderBy string
+	// OrderByNumber compares text values of the OrderBy column as numbers. Set it when the driver returns numbers as
+	// text, as MySQL does for queries without parameters. Otherwise text always compares byte by byte, so "10" is
+	// before "9"
+	OrderByNumber bool
+	// Descending merges by OrderBy largest first, use it when the shards sort with ORDER BY ... DESC
+	Descending bool
+	// ShardContext returns the context.Context that routes a call to the shard with the id. WithShard is used if nil,
+	// set it when the engine was installed with a custom ShardResolver
+	ShardContext func(ctx context.Context, shardID string) context.Context
+}
+
+// ScatterQuery runs the same read query on several shards of an engine set up with InstallSingleSharded and returns
+// one result that merges the rows of the shards as they are read. Each query goes through the engine, so its
+// middleware applies to each shard as it does to any other query.
+// The first error, while starting the queries or while reading their rows, cancels the queries of the other shards.
+// Errors while starting are returned here, errors while reading end the rows and are returned by Close.
+// The rows of every shard stay open until they are read to the end or the result is closed
+// @param q the sharded engine
+// @param shardIDs the shards to query. Results are concatenated in this order and rows with equal OrderBy values are
+// kept in this order
+// @param query the query to run on each shard
+func ScatterQuery(ctx context.Context, q vquery.Queryer, shardIDs []string, query vparam.Queryer, opts ScatterOptions) (rows vrows.Rowser, err error) {
+	shardContext := opts.ShardContext
+	if shardContext == nil {
+		shardContext = WithShard
+	}
+	scatterCtx, cancel := context.WithCancel(ctx)
+
+	parallelism := opts.Parallelism
+	if parallelism <= 0 || parallelism > len(shardIDs) {
+		parallelism = len(shardIDs)
+	}
+	slots := make(chan struct{}, parallelism)
+	results := make([]vrows.Rowser, len(shardIDs))
+	var firstErr error
+	errOnce := sync.Once{}
+	wg := sync.WaitGroup{}
+	wg.Add(len(shardIDs))
+	for i, shardID := range shardIDs {
+		go func(i int, shardID string) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+			case <-scatterCtx.Done():
+				return
+			}
+			defer func() { <-slots }()
+			result, err := q.Query(shardContext(scatterCtx, shardID), query)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf(`shard "%s": %w`, shardID, err)
+					cancel()
+				})
+				return
+			}
+			results[i] = result
+		}(i, shardID)
+	}
+	wg.Wait()
+	// the caller giving up explains the errors of the queries it interrupted
+	if err = ctx.Err(); err == nil {
+		err = firstErr
+	}
+	if err != nil {
+		for _, result := range results {
+			if result != nil {
+				_ = result.Close()
+			}
+		}
+		cancel()
+		return nil, err
+	}
+	return newScatterRows(results, shardIDs, cancel, opts), nil
+}
+
+// scatterRows merges the rows of several shards while they are read. Only the next row of each shard is held
+type scatterRows struct {
+	streams []*scatterStream
+	opts    ScatterOptions
+	// cancel stops the queries of every shard
+	cancel  context.CancelFunc
+	columns []string
+	// key is the position of the OrderBy column, known once the first row is read
+	key int
+	// current is the stream being read when concatenating
+	current int
+	// last is the stream of the row returned last when ordering, it is advanced by the following call to Next
+	last    *scatterStream
+	started bool
+	err     error
+}
+
+// scatterStream is the rows of one shard
+type scatterStream struct {
+	shardID string
+	rows    vrows.Rowser
+	head    []interface{}
+	done    bool
+}
+
+func newScatterRows(rows []vrows.Rowser, shardIDs []string, cancel context.CancelFunc, opts ScatterOptions) *scatterRows {
+	streams := make([]*scatterStream, len(rows))
+	for i, r := range rows {
+		streams[i] = &scatterStream{shardID: shardIDs[i], rows: r}
+	}
+	return &scatterRows{
+		streams: streams,
+		opts:    opts,
+		cancel:  cancel,
+		key:     -1,
+	}
+}
+
+// Next returns the next row or nil if no more rows are available or reading a shard failed
+func (s *scatterRows) Next() vrows.Rower {
+	if s.err != nil {
+		return nil
+	}
+	var stream *scatterStream
+	var err error
+	if s.opts.OrderBy == "" {
+		stream, err = s.nextConcatenated()
+	} else {
+		stream, err = s.nextOrdered()
+	}
+	if err != nil {
+		s.err = err
+		s.cancel()
+		return nil
+	}
+	if stream == nil {
+		return nil
+	}
+	return &memoryRow{
+		columns: s.columns,
+		values:  stream.head,
+	}
+}
+
+func (s *scatterRows) nextConcatenated() (*scatterStream, error) {
+	for ; s.current < len(s.streams); s.current++ {
+		stream := s.streams[s.current]
+		if err := s.advance(stream); err != nil {
+			return nil, err
+		}
+		if !stream.done {
+			return stream, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *scatterRows) nextOrdered() (*scatterStream, error) {
+	if !s.started {
+		s.started = true
+		for _, stream := range s.streams {
+			if err := s.advance(stream); err != nil {
+				return nil, err
+			}
+		}
+	} else if s.last != nil {
+		if err := s.advance(s.last); err != nil {
+			return nil, err
+		}
+	}
+	s.last = nil
+	for _, stream := range s.streams {
+		if stream.done {
+			continue
+		}
+		if s.last == nil {
+			s.last = stream
+			continue
+		}
+		c := compareValues(stream.head[s.key], s.last.head[s.key], s.opts.OrderByNumber)
+		if s.opts.Descending {
+			c = -c
+		}
+		// strictly less keeps rows with equal keys in shard order
+		if c < 0 {
+			s.last = stream
+		}
+	}
+	return s.last, nil
+}
+
+// advance reads the next row of the stream into its head. The stream is closed after its last row to report its errors
+func (s *scatterRows) advance(stream *scatterStream) error {
+	if stream.done {
+		return nil
+	}
+	row := stream.rows.Next()
+	if row == nil {
+		stream.done, stream.head = true, nil
+		if err := stream.rows.Close(); err != nil {
+			return fmt.Errorf(`shard "%s": %w`, stream.shardID, err)
+		}
+		return nil
+	}
+	columns := row.Columns()
+	if s.columns == nil {
+		if err := s.setColumns(columns); err != nil {
+			return err
+		}
+	} else if !equalParts(s.columns, columns) {
+		return fmt.Errorf(`shard "%s" returned columns %v, expected %v`, stream.shardID, columns, s.columns)
+	}
+	values, err := scanRow(row, len(columns))
+	if err != nil {
+		return fmt.Errorf(`shard "%s": %w`, stream.shardID, err)
+	}
+	stream.head = values
+	return nil
+}
+
+// setColumns keeps the columns of the first row read and finds the OrderBy column among them
+func (s *scatterRows) setColumns(columns []string) error {
+	s.columns = columns
+	if s.opts.OrderBy == "" {
+		return nil
+	}
+	for i, column := range columns {
+		if column == s.opts.OrderBy {
+			s.key = i
+			return nil
+		}
+	}
+	return fmt.Errorf(`order by column "%s" is not in the results`, s.opts.OrderBy)
+}
+
+// Close closes the rows of every shard and cancels their queries
+// @return err the error that ended the rows early, or the first error closing the rows of a shard
+func (s *scatterRows) Close() (err error) {
+	for _, stream := range s.streams {
+		if stream.done {
+			continue
+		}
+		stream.done = true
+		if closeErr := stream.rows.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf(`shard "%s": %w`, stream.shardID, closeErr)
+		}
+	}
+	s.cancel()
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
@@ -0,0 +1,292 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScatterRows_OrderBy(t *testing.T) {
+	columns := []string{"id", "name"}
+	rows := newScatterRows([]vrows.Rowser{
+		newMemoryRows(columns, [][]interface{}{{int64(1), []byte("a")}, {int64(4), []byte("d")}}),
+		newMemoryRows(columns, [][]interface{}{{int64(2), []byte("b")}, {int64(3), []byte("c")}, {int64(5), []byte("e")}}),
+	}, []string{"x", "y"}, func() {}, ScatterOptions{OrderBy: "id"})
+	expected := []string{"a", "b", "c", "d", "e"}
+	for i, e := range expected {
+		row := rows.Next()
+		if row == nil {
+			t.Fatalf("expected %d rows, got %d", len(expected), i)
+		}
+		var id int
+		var name string
+		if err := row.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		if id != i+1 || name != e {
+			t.Errorf("row %d: expected (%d, %s), got (%d, %s)", i, i+1, e, id, name)
+		}
+	}
+	if rows.Next() != nil {
+		t.Error("expected no more rows")
+	}
+	if err := rows.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScatterRows_Concatenate(t *testing.T) {
+	columns := []string{"id"}
+	rows := newScatterRows([]vrows.Rowser{
+		newMemoryRows(columns, [][]interface{}{{int64(3)}}),
+		newMemoryRows(columns, nil),
+		newMemoryRows(columns, [][]interface{}{{int64(1)}, {nil}}),
+	}, []string{"x", "y", "z"}, func() {}, ScatterOptions{})
+	expected := []*int64{int64Ptr(3), int64Ptr(1), nil}
+	for i, e := range expected {
+		var id *int64
+		if err := rows.Next().Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		if (e == nil) != (id == nil) || (e != nil && *e != *id) {
+			t.Errorf("row %d: expected %v, got %v", i, e, id)
+		}
+	}
+	if rows.Next() != nil {
+		t.Error("expected no more rows")
+	}
+}
+
+func TestScatterRows_MismatchedColumns(t *testing.T) {
+	canceled := false
+	rows := newScatterRows([]vrows.Rowser{
+		newMemoryRows([]string{"id"}, [][]interface{}{{int64(1)}}),
+		newMemoryRows([]string{"name"}, [][]interface{}{{"a"}}),
+	}, []string{"x", "y"}, func() { canceled = true }, ScatterOptions{OrderBy: "id"})
+	if rows.Next() != nil {
+		t.Error("expected no rows when the shards return different columns")
+	}
+	if err := rows.Close(); err == nil || !strings.Contains(err.Error(), `shard "y"`) {
+		t.Errorf("expected the shard returning different columns to be reported, got: %v", err)
+	}
+	if !canceled {
+		t.Error("expected the queries to be canceled")
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	big := int64(1) << 53
+	if compareValues(big+1, big, false) <= 0 {
+		t.Error("expected integers above 2^53 to compare exactly")
+	}
+	if compareValues(uint64(1)<<63, int64(1), false) <= 0 {
+		t.Error("expected uint64 values above the int64 range to be larger")
+	}
+	if compareValues([]byte("9"), []byte("10"), false) <= 0 {
+		t.Error("expected text to compare byte by byte")
+	}
+	if compareValues([]byte("9"), []byte("10"), true) >= 0 {
+		t.Error("expected numbers returned as text to compare numerically")
+	}
+	if compareValues([]byte("2.5"), float64(10), true) >= 0 {
+		t.Error("expected decimals returned as text to compare numerically")
+	}
+	if compareValues([]byte("b"), []byte("a"), true) <= 0 {
+		t.Error("expected text that is not a number to compare byte by byte")
+	}
+}
+
+func TestScatterQuery_FansOut(t *testing.T) {
+	engine, dbs := newShardedEngine(t, "b", "c", "a")
+	defer func() { _ = engine.Close() }()
+	// holding the only connection of each database makes the queries wait for it, showing which ones were started
+	conns := holdConnections(t, dbs)
+	done := make(chan vrows.Rowser, 1)
+	go func() {
+		rows, err := ScatterQuery(context.Background(), engine, []string{"b", "c", "a"}, vparam.New("SELECT name FROM which"), ScatterOptions{OrderBy: "name"})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- rows
+	}()
+	waitFor(t, func() bool {
+		for _, db := range dbs {
+			if db.Stats().WaitCount == 0 {
+				return false
+			}
+		}
+		return true
+	})
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	rows := <-done
+	if rows == nil {
+		t.FailNow()
+	}
+	var names []string
+	for row := rows.Next(); row != nil; row = rows.Next() {
+		var name string
+		if err := row.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Errorf("expected the rows of every shard in order, got: %v", names)
+	}
+}
+
+func TestScatterQuery_Parallelism(t *testing.T) {
+	engine, dbs := newShardedEngine(t, "a", "b")
+	defer func() { _ = engine.Close() }()
+	conns := holdConnections(t, dbs)
+	done := make(chan error, 1)
+	go func() {
+		rows, err := ScatterQuery(context.Background(), engine, []string{"a", "b"}, vparam.New("SELECT name FROM which"), ScatterOptions{Parallelism: 1})
+		if err == nil {
+			err = rows.Close()
+		}
+		done <- err
+	}()
+	waitFor(t, func() bool {
+		return dbs[0].Stats().WaitCount+dbs[1].Stats().WaitCount > 0
+	})
+	time.Sleep(10 * time.Millisecond)
+	if waiting := dbs[0].Stats().WaitCount + dbs[1].Stats().WaitCount; waiting != 1 {
+		t.Errorf("expected one shard to be queried at a time, %d were", waiting)
+	}
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScatterQuery_Canceled(t *testing.T) {
+	engine, dbs := newShardedEngine(t, "a", "b")
+	defer func() { _ = engine.Close() }()
+	conns := holdConnections(t, dbs)
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := ScatterQuery(ctx, engine, []string{"a", "b"}, vparam.New("SELECT name FROM which"), ScatterOptions{})
+		done <- err
+	}()
+	waitFor(t, func() bool {
+		return dbs[0].Stats().WaitCount > 0
+	})
+	cancel()
+	select {
+	case err := <-done:
+		if !IsCanceled(err) {
+			t.Errorf("expected the cancellation to be returned, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected canceling to stop the queries")
+	}
+}
+
+func TestScatterQuery_FailureCancelsOtherShards(t *testing.T) {
+	engine, dbs := newShardedEngine(t, "a", "b")
+	defer func() { _ = engine.Close() }()
+	conns := holdConnections(t, dbs)
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	broken, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = broken.Close() }()
+	withBroken := vsql_engine.NewSingle()
+	InstallSingleSharded(withBroken, map[string]*sql.DB{"a": dbs[0], "b": dbs[1], "broken": broken}, nil, questionMarkFactory, Config{})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ScatterQuery(context.Background(), withBroken, []string{"a", "b", "broken"}, vparam.New("SELECT name FROM which"), ScatterOptions{})
+		done <- err
+	}()
+	// a and b wait for their held connections until the failure of broken cancels them
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), `shard "broken"`) {
+			t.Errorf("expected the error of the failing shard, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the failing shard to cancel the others")
+	}
+}
+
+// newShardedEngine installs a sharded engine over a private database for each shard, named after it
+// @return dbs the database of each shard, in the order of the names
+func newShardedEngine(t *testing.T, names ...string) (engine vsql_engine.SingleTXer, dbs []*sql.DB) {
+	shards := make(map[string]*sql.DB, len(names))
+	for _, name := range names {
+		db := openReplica(t, name)
+		shards[name] = db
+		dbs = append(dbs, db)
+	}
+	engine = vsql_engine.NewSingle()
+	InstallSingleSharded(engine, shards, nil, questionMarkFactory, Config{})
+	return engine, dbs
+}
+
+// holdConnections takes the only connection of each database
+func holdConnections(t *testing.T, dbs []*sql.DB) []*sql.Conn {
+	conns := make([]*sql.Conn, len(dbs))
+	for i, db := range dbs {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = conn
+	}
+	return conns
+}
+
+// waitFor polls until the condition is met, failing the test if it takes too long
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
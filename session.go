@@ -0,0 +1,198 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql/vstmt"
+)
+
+// Session is a series of calls pinned to a single connection of the pool, without a transaction. Use it for work that
+// depends on the state of the connection, such as temporary tables, session variables and advisory locks. Each call
+// is committed on its own, as it would be on the database
+type Session interface {
+	vsql.QueryExecer
+	// Release returns the connection to the pool. The session and its statements may not be used once it is released
+	Release() error
+}
+
+// BeginSession pins a connection of the engine's database until the session is released. The session's queries,
+// inserts, execs and statements go through all of the engine's middleware, which sees them with
+// engine_context.Queryer's QueryExecTransactioner set, but the session is not a transaction: the cache invalidation
+// and audit middleware treat its writes as if they were made outside of a transaction, and the query cache does not
+// answer its queries, as their results may depend on the state of the connection. The session is started by the
+// engine's Begin middleware, which can tell it apart from a transaction with IsSessionBegin, and skip it. Release does
+// not run the Commit or Rollback middleware
+// @param db is an engine set up with InstallSingle, or one of its variants
+func BeginSession(ctx context.Context, db vsql.SQLer) (Session, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	request := &sessionRequest{}
+	qet, err := db.Begin(context.WithValue(ctx, sessionContextKey, request), nil)
+	if err != nil {
+		if request.qes != nil {
+			// other Begin middleware refused the session once it was started, give its connection back
+			_ = request.qes.release()
+		}
+		return nil, err
+	}
+	if request.qes == nil {
+		// the Begin middleware of InstallSingle did not start the session, end whatever was started instead
+		_ = qet.Rollback()
+		return nil, ErrSessionNotStarted
+	}
+	return &session{calls: qet, qes: request.qes}, nil
+}
+
+// ErrSessionNotStarted is returned by BeginSession when the engine was not set up with InstallSingle, or one of its
+// variants
+var ErrSessionNotStarted = errors.New("the engine did not start a session")
+
+// IsSessionBegin is true when the Begin middleware is called by BeginSession to start a Session rather than a
+// transaction. The session is never committed or rolled back, so middleware that pairs Begin with Commit and Rollback,
+// such as to count open transactions, should skip it
+func IsSessionBegin(ctx context.Context) bool {
+	return sessionRequestFrom(ctx) != nil
+}
+
+// sessionRequest is set by BeginSession, and receives the session started by the Begin middleware
+type sessionRequest struct {
+	qes *queryExecSession
+}
+
+func sessionRequestFrom(ctx context.Context) *sessionRequest {
+	if ctx == nil {
+		return nil
+	}
+	request, _ := ctx.Value(sessionContextKey).(*sessionRequest)
+	return request
+}
+
+// session runs its calls through the engine's transaction wrapper around a queryExecSession
+type session struct {
+	// calls is the engine's wrapper, which runs the calls through the middleware
+	calls vsql.QueryExecTransactioner
+	qes   *queryExecSession
+}
+
+func (s *session) Query(ctx context.Context, query vparam.Queryer) (vrows.Rowser, error) {
+	return s.calls.Query(ctx, query)
+}
+
+func (s *session) Insert(ctx context.Context, query vparam.Queryer) (vresult.InsertResulter, error) {
+	return s.calls.Insert(ctx, query)
+}
+
+func (s *session) Exec(ctx context.Context, query vparam.Queryer) (vresult.Resulter, error) {
+	return s.calls.Exec(ctx, query)
+}
+
+func (s *session) Prepare(ctx context.Context, query vparam.Queryer) (vstmt.Statementer, error) {
+	return s.calls.Prepare(ctx, query)
+}
+
+// Release ends the session directly, rather than through the engine's Commit or Rollback
+func (s *session) Release() error {
+	return s.qes.release()
+}
+
+// queryExecSession runs calls on a connection pinned by BeginSession. It takes the place of a queryExecTransaction in
+// the middleware. It is released by the Session, Commit and Rollback only implement vsql.QueryExecTransactioner
+type queryExecSession struct {
+	conn *sql.Conn
+	// ctx is the context.Context the session was started with
+	ctx context.Context
+	// db is the database the connection belongs to
+	db                         *sql.DB
+	interpolateStrategyFactory interpolation_strategy.InterpolationStrategyFactory
+	endHooks
+}
+
+func newQueryExecSession(conn *sql.Conn, db *sql.DB, interpolateStrategyFactory interpolation_strategy.InterpolationStrategyFactory) *queryExecSession {
+	return &queryExecSession{
+		conn:                       conn,
+		db:                         db,
+		interpolateStrategyFactory: interpolateStrategyFactory,
+	}
+}
+
+// release runs the end hooks and returns the connection to the pool
+func (q *queryExecSession) release() error {
+	hookErr := q.runEndHooks()
+	if err := q.conn.Close(); err != nil {
+		return err
+	}
+	return hookErr
+}
+
+// Commit releases the connection back to the pool
+func (q *queryExecSession) Commit() error {
+	return q.release()
+}
+
+// Rollback releases the connection back to the pool. Nothing is rolled back, as each call was committed on its own
+func (q *queryExecSession) Rollback() error {
+	return q.release()
+}
+
+func (q *queryExecSession) Query(ctx context.Context, query vparam.Queryer) (rows vrows.Rowser, err error) {
+	queryString, values, err := query.Interpolate(query.SQLQueryUnInterpolated(), q.interpolateStrategyFactory())
+	if err != nil {
+		return nil, err
+	}
+	sqlRows, err := q.conn.QueryContext(ctx, queryString, values...)
+	if err != nil {
+		return nil, err
+	}
+	return &goRows{sqlRows: sqlRows}, nil
+}
+
+func (q *queryExecSession) Insert(ctx context.Context, query vparam.Queryer) (result vresult.InsertResulter, err error) {
+	queryString, values, err := query.Interpolate(query.SQLQueryUnInterpolated(), q.interpolateStrategyFactory())
+	if err != nil {
+		return nil, err
+	}
+	res, err := q.conn.ExecContext(ctx, queryString, values...)
+	if err != nil {
+		return nil, err
+	}
+	return &goInsertResult{result: res}, nil
+}
+
+func (q *queryExecSession) Exec(ctx context.Context, query vparam.Queryer) (result vresult.Resulter, err error) {
+	return q.Insert(ctx, query)
+}
+
+// Prepare prepares the statement on the session's connection. It is not shared through the StatementCache, as the
+// cache's statements may be prepared on any connection
+func (q *queryExecSession) Prepare(ctx context.Context, query vparam.Queryer) (stmt vstmt.Statementer, err error) {
+	goStmt, err := q.conn.PrepareContext(ctx, query.SQLQueryInterpolated(q.interpolateStrategyFactory()))
+	if err != nil {
+		return nil, err
+	}
+	stmtWrapper := newStatement(goStmt, q.interpolateStrategyFactory)
+	stmtWrapper.originalQuery = query
+	stmtWrapper.db = q.db
+	return stmtWrapper, nil
+}
@@ -0,0 +1,150 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql_engine"
+	"io"
+)
+
+// SessionInitError is returned when a new connection could not be initialized. The connection is closed
+type SessionInitError struct {
+	// Statement is the init statement that failed
+	Statement string
+	Err       error
+}
+
+func (e *SessionInitError) Error() string {
+	return fmt.Sprintf("unable to initialize the connection with %q: %v", e.Statement, e.Err)
+}
+
+// Unwrap returns the error reported by the driver
+func (e *SessionInitError) Unwrap() error {
+	return e.Err
+}
+
+// NewSessionConnector wraps the connector so that every connection it creates runs the statements, in order, before
+// database/sql hands it out. Use this for session settings that must be the same on every connection, such as
+// "SET TIME ZONE 'UTC'", "SET search_path TO app" or "SET SESSION sql_mode = 'STRICT_ALL_TABLES'". Statements run
+// without arguments, so they are written in the dialect directly
+func NewSessionConnector(connector driver.Connector, statements ...string) driver.Connector {
+	return &sessionConnector{
+		connector:  connector,
+		statements: statements,
+	}
+}
+
+// NewDSNConnector returns the connector of a driver for the data source name, for drivers that do not export one
+func NewDSNConnector(d driver.Driver, dsn string) (driver.Connector, error) {
+	if dc, ok := d.(driver.DriverContext); ok {
+		return dc.OpenConnector(dsn)
+	}
+	return &dsnConnector{driver: d, dsn: dsn}, nil
+}
+
+// InstallSingleConnector is InstallSingleWithConfig for a database opened from the connector. Every connection runs
+// the sessionInit statements before it is used, so queries run through the engine always see the same session state,
+// whichever connection of the pool they get. The other installers take a database that is already open, so use
+// NewSessionConnector to open theirs
+// @param sessionInit are statements run on every new connection, such as setting the time zone, see NewSessionConnector
+// @return db is the database opened from the connector, for setting up its pool and closing it
+func InstallSingleConnector(engine vsql_engine.SingleTXer, connector driver.Connector, factory interpolation_strategy.InterpolationStrategyFactory, cfg Config, sessionInit ...string) (db *sql.DB) {
+	if len(sessionInit) != 0 {
+		connector = NewSessionConnector(connector, sessionInit...)
+	}
+	db = sql.OpenDB(connector)
+	InstallSingleWithConfig(engine, db, factory, cfg)
+	return db
+}
+
+type sessionConnector struct {
+	connector  driver.Connector
+	statements []string
+}
+
+// Connect creates the connection and runs the init statements on it
+func (c *sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, statement := range c.statements {
+		if err = execOnConn(ctx, conn, statement); err != nil {
+			_ = conn.Close()
+			return nil, &SessionInitError{Statement: statement, Err: err}
+		}
+	}
+	return conn, nil
+}
+
+// Driver returns the driver of the wrapped connector
+func (c *sessionConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// Close closes the wrapped connector, if it can be closed. sql.DB.Close calls it
+func (c *sessionConnector) Close() error {
+	if closer, ok := c.connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// execOnConn runs a statement without arguments on a driver connection, preparing it if the driver cannot exec directly
+func execOnConn(ctx context.Context, conn driver.Conn, statement string) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(ctx, statement, nil)
+		if err != driver.ErrSkip {
+			return err
+		}
+	}
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, statement)
+	} else {
+		stmt, err = conn.Prepare(statement)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stmt.Close() }()
+	if execer, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = execer.ExecContext(ctx, nil)
+		return err
+	}
+	_, err = stmt.Exec(nil)
+	return err
+}
+
+// dsnConnector opens connections with a driver that does not implement driver.DriverContext
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c *dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
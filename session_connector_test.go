@@ -0,0 +1,100 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/mattn/go-sqlite3"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"testing"
+)
+
+func TestInstallSingleConnector_SessionInit(t *testing.T) {
+	connector, err := NewDSNConnector(&sqlite3.SQLiteDriver{}, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := vsql_engine.NewSingle()
+	db := InstallSingleConnector(engine, connector, questionMarkFactory, Config{}, "PRAGMA foreign_keys = ON", "PRAGMA recursive_triggers = ON")
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+
+	// hold a transaction open so that the query below needs a second connection
+	tx, err := engine.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	var foreignKeys int
+	if err = db.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+		t.Errorf("expected the pool's connection to be initialized, got %d: %v", foreignKeys, err)
+	}
+	row, err := engine.Query(ctx, vparam.New("PRAGMA recursive_triggers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = row.Close() }()
+	var enabled int
+	if r := row.Next(); r == nil || r.Scan(&enabled) != nil || enabled != 1 {
+		t.Errorf("expected the engine's connection to be initialized, got %d", enabled)
+	}
+	if s := db.Stats(); s.OpenConnections < 2 {
+		t.Errorf("expected more than one connection to have been initialized: %+v", s)
+	}
+}
+
+func TestSessionConnector_InitError(t *testing.T) {
+	connector, err := NewDSNConnector(&sqlite3.SQLiteDriver{}, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := vsql_engine.NewSingle()
+	InstallSingleConnector(engine, connector, questionMarkFactory, Config{}, "SET TIME ZONE 'UTC'")
+	defer func() { _ = engine.Close() }()
+	_, err = engine.Exec(context.Background(), vparam.New("SELECT 1"))
+	if e, ok := err.(*SessionInitError); !ok || e.Statement != "SET TIME ZONE 'UTC'" {
+		t.Errorf("expected the init error, got: %v", err)
+	}
+}
+
+func TestSessionConnector_Close(t *testing.T) {
+	connector, err := NewDSNConnector(&sqlite3.SQLiteDriver{}, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closing := &closingConnector{Connector: connector}
+	db := sql.OpenDB(NewSessionConnector(closing, "PRAGMA foreign_keys = ON"))
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !closing.closed {
+		t.Error("expected closing the database to close the wrapped connector")
+	}
+}
+
+// closingConnector records whether it was closed
+type closingConnector struct {
+	driver.Connector
+	closed bool
+}
+
+func (c *closingConnector) Close() error {
+	c.closed = true
+	return nil
+}
@@ -0,0 +1,147 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vquery"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"testing"
+	"time"
+)
+
+func TestBeginSession(t *testing.T) {
+	// tables are shared by the connections of a shared cache, temporary tables are only visible on the connection that made them
+	db, err := sql.Open("sqlite3", "file:session_test?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(2)
+	engine := vsql_engine.NewSingle()
+	InstallSingle(engine, db, questionMarkFactory)
+	cache := NewQueryCache(QueryCacheConfig{})
+	InstallQueryCache(engine, cache, questionMarkFactory)
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	cached := WithQueryCache(ctx, time.Minute, "users")
+
+	count := func(ctx context.Context, q vquery.Queryer, table string) (n int, err error) {
+		rows, err := q.Query(ctx, vparam.New("SELECT COUNT(*) FROM "+table))
+		if err != nil {
+			return 0, err
+		}
+		defer func() { _ = rows.Close() }()
+		if row := rows.Next(); row != nil {
+			err = row.Scan(&n)
+		}
+		return
+	}
+	if _, err = engine.Exec(ctx, vparam.New("CREATE TABLE users (id INTEGER)")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = count(cached, engine, "users"); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := BeginSession(ctx, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Exec(ctx, vparam.New("CREATE TEMP TABLE scratch (id INTEGER)")); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := s.Prepare(ctx, vparam.NewNamed("INSERT INTO scratch VALUES (:id)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 2, 3} {
+		if _, err = stmt.Exec(ctx, vparam.NewNamedData(map[string]interface{}{"id": id})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = stmt.Close()
+	if n, err := count(ctx, s, "scratch"); err != nil || n != 3 {
+		t.Errorf("expected the session to see its temporary table, got %d: %v", n, err)
+	}
+	if _, err = count(ctx, engine, "scratch"); err == nil {
+		t.Error("expected the temporary table to be missing from the other connection")
+	}
+
+	// a session is not a transaction: its writes invalidate right away and are visible to other connections
+	if _, err = s.Exec(ctx, vparam.New("INSERT INTO users SELECT id FROM scratch")); err != nil {
+		t.Fatal(err)
+	}
+	if st := cache.Stats(); st.Entries != 0 {
+		t.Errorf("expected the session's write to invalidate the cache: %+v", st)
+	}
+	if n, err := count(cached, engine, "users"); err != nil || n != 3 {
+		t.Errorf("expected the session's write to be committed, got %d: %v", n, err)
+	}
+	if _, err = count(cached, s, "users"); err != nil {
+		t.Fatal(err)
+	}
+	if st := cache.Stats(); st.Hits != 0 {
+		t.Errorf("expected session queries not to be answered from the cache: %+v", st)
+	}
+
+	if err = s.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = count(ctx, s, "scratch"); err == nil {
+		t.Error("expected the released session to be unusable")
+	}
+}
+
+func TestBeginSession_SkipsTransactionMiddleware(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	var sessions, transactions, ended int
+	engine.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		if IsSessionBegin(ctx) {
+			sessions++
+		} else {
+			transactions++
+		}
+		c.Next(ctx)
+	})
+	engine.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		ended++
+		c.Next(ctx)
+	})
+	engine.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		ended++
+		c.Next(ctx)
+	})
+
+	s, err := BeginSession(context.Background(), engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(vsql.QueryExecTransactioner); ok {
+		t.Error("expected the session not to be committable")
+	}
+	if err = s.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 1 || transactions != 0 || ended != 0 {
+		t.Errorf("expected the session to be told apart and released without Commit or Rollback, got sessions=%d transactions=%d ended=%d", sessions, transactions, ended)
+	}
+}
@@ -0,0 +1,115 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql_engine"
+)
+
+// ShardResolver reads the shard id for a call from its context.Context
+// @return shardID the key of the shard in the map passed to InstallSingleSharded
+// @return err ErrShardKeyMissing if the context has no shard key, or any error encountered while resolving the shard
+type ShardResolver func(ctx context.Context) (shardID string, err error)
+
+// ErrShardKeyMissing is returned when a call is made without a shard key in its context.Context
+var ErrShardKeyMissing = errors.New("no shard key was found in the context")
+
+// ErrCrossShardTransaction is returned when a call in a transaction, or on a prepared statement, resolves to a
+// different shard than the one the transaction was started on, or the statement was prepared on
+var ErrCrossShardTransaction = errors.New("transactions and prepared statements cannot span shards")
+
+// ErrUnknownShard is returned when the resolver returns a shard id that was not configured
+type ErrUnknownShard struct {
+	ShardID string
+}
+
+func (e ErrUnknownShard) Error() string {
+	return fmt.Sprintf(`shard "%s" is not configured`, e.ShardID)
+}
+
+// WithShard returns a context.Context that routes calls made with it to the shard with the id. Used by ShardFromContext
+func WithShard(ctx context.Context, shardID string) context.Context {
+	return context.WithValue(ctx, shardContextKey, shardID)
+}
+
+// ShardFromContext is a ShardResolver that returns the shard id set with WithShard
+func ShardFromContext(ctx context.Context) (shardID string, err error) {
+	if ctx != nil {
+		if id, ok := ctx.Value(shardContextKey).(string); ok {
+			return id, nil
+		}
+	}
+	return "", ErrShardKeyMissing
+}
+
+// InstallSingleSharded is InstallSingleWithConfig for data split across several databases. Every query, exec, insert,
+// prepare and transaction is sent to the shard the resolver returns for the call's context.Context. Transactions and
+// prepared statements stay on the shard they were started on: calls that resolve to another shard fail with
+// ErrCrossShardTransaction, calls without a shard key use the shard they are bound to.
+// Ping and Close apply to all shards.
+// @param shards the databases, keyed by shard id
+// @param resolver determines the shard id of each call. ShardFromContext is used if nil
+func InstallSingleSharded(engine vsql_engine.SingleTXer, shards map[string]*sql.DB, resolver ShardResolver, factory interpolation_strategy.InterpolationStrategyFactory, cfg Config) {
+	if resolver == nil {
+		resolver = ShardFromContext
+	}
+	installSingle(engine, &shardRouter{shards: shards, resolver: resolver}, factory, cfg)
+}
+
+type shardRouter struct {
+	shards   map[string]*sql.DB
+	resolver ShardResolver
+}
+
+func (r *shardRouter) route(ctx context.Context, op operation) (*sql.DB, error) {
+	id, err := r.resolver(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db, ok := r.shards[id]
+	if !ok {
+		return nil, ErrUnknownShard{ShardID: id}
+	}
+	return db, nil
+}
+
+func (r *shardRouter) all() []*sql.DB {
+	dbs := make([]*sql.DB, 0, len(r.shards))
+	for _, db := range r.shards {
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
+func (r *shardRouter) checkBound(ctx context.Context, bound *sql.DB) error {
+	db, err := r.route(ctx, opQuery)
+	if errors.Is(err, ErrShardKeyMissing) {
+		// already bound to a shard, nothing to disagree with
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bound != nil && db != bound {
+		return ErrCrossShardTransaction
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vstmt"
+	"testing"
+)
+
+func TestShardRouter(t *testing.T) {
+	a, b := &sql.DB{}, &sql.DB{}
+	r := &shardRouter{
+		shards:   map[string]*sql.DB{"a": a, "b": b},
+		resolver: ShardFromContext,
+	}
+	ctx := context.Background()
+
+	if _, err := r.route(ctx, opQuery); err != ErrShardKeyMissing {
+		t.Errorf("expected ErrShardKeyMissing, got: %v", err)
+	}
+	if db, err := r.route(WithShard(ctx, "b"), opExec); err != nil || db != b {
+		t.Errorf("expected shard b, got: %v", err)
+	}
+	if _, err := r.route(WithShard(ctx, "c"), opExec); err != (ErrUnknownShard{ShardID: "c"}) {
+		t.Errorf("expected ErrUnknownShard, got: %v", err)
+	}
+	if err := r.checkBound(WithShard(ctx, "b"), a); err != ErrCrossShardTransaction {
+		t.Errorf("expected ErrCrossShardTransaction, got: %v", err)
+	}
+	if err := r.checkBound(ctx, a); err != nil {
+		t.Errorf("expected calls without a shard key to use the bound shard, got: %v", err)
+	}
+}
+
+func TestShardRouter_WrappedKeyMissing(t *testing.T) {
+	a := &sql.DB{}
+	r := &shardRouter{
+		shards: map[string]*sql.DB{"a": a},
+		resolver: func(ctx context.Context) (string, error) {
+			return "", fmt.Errorf("reading the tenant: %w", ErrShardKeyMissing)
+		},
+	}
+	if err := r.checkBound(context.Background(), a); err != nil {
+		t.Errorf("expected a wrapped ErrShardKeyMissing to use the bound shard, got: %v", err)
+	}
+}
+
+func TestInstallSingleSharded(t *testing.T) {
+	engine, dbs := newShardedEngine(t, "a", "b")
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	onA, onB := WithShard(ctx, "a"), WithShard(ctx, "b")
+
+	if name, err := whichDatabase(onB, engine); err != nil || name != "b" {
+		t.Errorf("expected the query to go to shard b, got %s: %v", name, err)
+	}
+	if _, err := whichDatabase(ctx, engine); !errors.Is(err, ErrShardKeyMissing) {
+		t.Errorf("expected ErrShardKeyMissing, got: %v", err)
+	}
+	if _, err := engine.Exec(onA, vparam.New("UPDATE which SET name = 'written'")); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := dbs[0].QueryRow("SELECT name FROM which").Scan(&name); err != nil || name != "written" {
+		t.Errorf("expected the exec to go to shard a, got %s: %v", name, err)
+	}
+
+	stmt, err := engine.Prepare(onB, vparam.New("SELECT name FROM which"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := whichStatement(ctx, stmt); err != nil || name != "b" {
+		t.Errorf("expected the statement to be prepared on shard b, got %s: %v", name, err)
+	}
+	if _, err := whichStatement(onA, stmt); !errors.Is(err, ErrCrossShardTransaction) {
+		t.Errorf("expected the statement to stay on its shard, got: %v", err)
+	}
+	if err = stmt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := engine.Begin(onB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := whichDatabase(ctx, tx); err != nil || name != "b" {
+		t.Errorf("expected the transaction to be started on shard b, got %s: %v", name, err)
+	}
+	if _, err := whichDatabase(onA, tx); !errors.Is(err, ErrCrossShardTransaction) {
+		t.Errorf("expected the transaction to stay on its shard, got: %v", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// whichStatement returns the name of the database a statement reading the which table was prepared on
+func whichStatement(ctx context.Context, stmt vstmt.Statementer) (name string, err error) {
+	rows, err := stmt.Query(ctx, vparam.New(""))
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = rows.Close() }()
+	if row := rows.Next(); row != nil {
+		err = row.Scan(&name)
+	}
+	return
+}
@@ -19,6 +19,7 @@ import (
 	"context"
 	"database/sql"
 	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql/vparam"
 	"github.com/wojnosystems/vsql/vresult"
 	"github.com/wojnosystems/vsql/vrows"
 	"github.com/wojnosystems/vsql/vstmt"
@@ -31,11 +32,45 @@ import (
 // @param db is the database connection handle that will be used when database calls need to be made to store or retrieve data or start transactions, etc.
 // @param factory is a callback that creates a new interpolation_strategy.InterpolateStrategy. Each call to the factory should create a new instance with a new state if required. For MySQL, this is not necessary, but for postgres, the new instance should be the start of a query interpolation
 func InstallSingle(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolation_strategy.InterpolationStrategyFactory) {
+	InstallSingleWithConfig(engine, db, factory, Config{})
+}
+
+// InstallSingleWithConfig is InstallSingle with optional behaviors, such as default timeouts, turned on by the config
+// @param cfg the optional behaviors to enable. The zero value is identical to calling InstallSingle
+func InstallSingleWithConfig(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolation_strategy.InterpolationStrategyFactory, cfg Config) {
+	installSingle(engine, &singleRouter{db: db}, factory, cfg)
+}
+
+// installSingle injects the middleware, asking the router which database to use for each call not made in a transaction
+func installSingle(engine vsql_engine.SingleTXer, r router, factory interpolation_strategy.InterpolationStrategyFactory, cfg Config) {
+	timeouts := cfg.Timeouts
 
 	// Starting transactions
 	engine.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
-		tx, err := db.BeginTx(ctx, c.TxOptions().ToTxOptions())
-		c.SetQueryExecTransactioner(newQueryExecTransaction(tx, factory))
+		db, err := r.route(ctx, opBegin)
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		if request := sessionRequestFrom(ctx); request != nil {
+			conn, err := db.Conn(ctx)
+			if err != nil {
+				c.SetError(err)
+				return
+			}
+			qes := newQueryExecSession(conn, db, factory)
+			qes.ctx = ctx
+			request.qes = qes
+			c.SetQueryExecTransactioner(qes)
+			c.Next(ctx)
+			return
+		}
+		tx, release, err := timeouts.beginTx(ctx, db, c.TxOptions().ToTxOptions())
+		qet := newQueryExecTransaction(tx, db, factory)
+		qet.ctx = ctx
+		qet.release = release
+		qet.statementCache = cfg.StatementCache
+		c.SetQueryExecTransactioner(qet)
 		if err != nil {
 			c.SetError(err)
 			return
@@ -47,20 +82,32 @@ func InstallSingle(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolat
 	engine.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
 		var err error
 		var stmtWrap vstmt.Statementer
+		d := timeouts.derive(ctx, opPrepare)
+		defer d.cancel()
 		if c.QueryExecTransactioner() != nil {
-			stmtWrap, err = c.QueryExecTransactioner().Prepare(ctx, c.Query())
-			if err != nil {
+			if err = r.checkBound(ctx, boundDatabase(c.QueryExecTransactioner())); err != nil {
 				c.SetError(err)
 				return
 			}
+			stmtWrap, err = c.QueryExecTransactioner().Prepare(d.ctx, c.Query())
+			if err != nil {
+				c.SetError(d.classify(err))
+				return
+			}
 		} else {
-			goStmt, err := db.PrepareContext(ctx, c.Query().SQLQueryInterpolated(factory()))
+			db, err := r.route(ctx, opPrepare)
 			if err != nil {
 				c.SetError(err)
 				return
 			}
-			stmtWrap := newStatement(goStmt, factory)
-			stmtWrap.originalQuery = c.Query()
+			goStmtWrap, err := prepareOnDB(d.ctx, db, c.Query(), factory, cfg.StatementCache)
+			if err != nil {
+				c.SetError(d.classify(err))
+				return
+			}
+			// batches of the statement run through the engine, see statement.ExecBatch
+			goStmtWrap.engine = engine
+			stmtWrap = goStmtWrap
 		}
 		c.SetStatement(stmtWrap)
 		c.Next(ctx)
@@ -73,24 +120,39 @@ func InstallSingle(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolat
 			c.SetError(err)
 			return
 		}
+		// The deadline must outlive this call as the rows are read after the query returns. It is released when the rows are closed
+		d := timeouts.derive(ctx, opQuery)
 		var rowsWrap vrows.Rowser
 		if c.QueryExecTransactioner() != nil {
-			rowsWrap, err = c.QueryExecTransactioner().Query(ctx, c.Query())
-			if err != nil {
+			if err = r.checkBound(ctx, boundDatabase(c.QueryExecTransactioner())); err != nil {
+				d.cancel()
 				c.SetError(err)
 				return
 			}
+			rowsWrap, err = c.QueryExecTransactioner().Query(d.ctx, c.Query())
+			if err != nil {
+				d.cancel()
+				c.SetError(d.classify(err))
+				return
+			}
 		} else {
-			goRowsOut, err := db.QueryContext(ctx, sqlQ, args...)
+			db, err := r.route(ctx, opQuery)
 			if err != nil {
+				d.cancel()
 				c.SetError(err)
 				return
 			}
+			goRowsOut, err := db.QueryContext(d.ctx, sqlQ, args...)
+			if err != nil {
+				d.cancel()
+				c.SetError(d.classify(err))
+				return
+			}
 			rowsWrap = &goRows{
 				sqlRows: goRowsOut,
 			}
 		}
-		c.SetRows(rowsWrap)
+		c.SetRows(cancelOnClose(rowsWrap, d.cancel))
 		c.Next(ctx)
 	})
 
@@ -101,19 +163,30 @@ func InstallSingle(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolat
 			c.SetError(err)
 			return
 		}
+		d := timeouts.derive(ctx, opExec)
+		defer d.cancel()
 		var resultWrap vresult.InsertResulter
 		if c.QueryExecTransactioner() != nil {
-			resultWrap, err = c.QueryExecTransactioner().Insert(ctx, c.Query())
-			if err != nil {
+			if err = r.checkBound(ctx, boundDatabase(c.QueryExecTransactioner())); err != nil {
 				c.SetError(err)
 				return
 			}
+			resultWrap, err = c.QueryExecTransactioner().Insert(d.ctx, c.Query())
+			if err != nil {
+				c.SetError(d.classify(err))
+				return
+			}
 		} else {
-			goResOut, err := db.ExecContext(ctx, sqlQ, args...)
+			db, err := r.route(ctx, opExec)
 			if err != nil {
 				c.SetError(err)
 				return
 			}
+			goResOut, err := db.ExecContext(d.ctx, sqlQ, args...)
+			if err != nil {
+				c.SetError(d.classify(err))
+				return
+			}
 			resultWrap = &goInsertResult{
 				result: goResOut,
 			}
@@ -129,19 +202,30 @@ func InstallSingle(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolat
 			c.SetError(err)
 			return
 		}
+		d := timeouts.derive(ctx, opExec)
+		defer d.cancel()
 		var resultWrap vresult.Resulter
 		if c.QueryExecTransactioner() != nil {
-			resultWrap, err = c.QueryExecTransactioner().Exec(ctx, c.Query())
-			if err != nil {
+			if err = r.checkBound(ctx, boundDatabase(c.QueryExecTransactioner())); err != nil {
 				c.SetError(err)
 				return
 			}
+			resultWrap, err = c.QueryExecTransactioner().Exec(d.ctx, c.Query())
+			if err != nil {
+				c.SetError(d.classify(err))
+				return
+			}
 		} else {
-			goResOut, err := db.ExecContext(ctx, sqlQ, args...)
+			db, err := r.route(ctx, opExec)
 			if err != nil {
 				c.SetError(err)
 				return
 			}
+			goResOut, err := db.ExecContext(d.ctx, sqlQ, args...)
+			if err != nil {
+				c.SetError(d.classify(err))
+				return
+			}
 			resultWrap = &goInsertResult{
 				result: goResOut,
 			}
@@ -152,10 +236,14 @@ func InstallSingle(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolat
 
 	// Ping performs a liveness/connectivity test of the database server
 	engine.PingMW().Prepend(func(ctx context.Context, c engine_context.Er) {
-		err := db.Ping()
-		if err != nil {
-			c.SetError(err)
-			return
+		d := timeouts.derive(ctx, opPing)
+		defer d.cancel()
+		for _, db := range r.all() {
+			err := db.PingContext(d.ctx)
+			if err != nil {
+				c.SetError(d.classify(err))
+				return
+			}
 		}
 		c.Next(ctx)
 	})
@@ -172,34 +260,52 @@ func InstallSingle(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolat
 
 	// Callback when a query is performed on a statement.
 	engine.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
-		goRowsOut, err := c.Statement().Query(ctx, c.Parameterer())
-		if err != nil {
+		if err := r.checkBound(ctx, boundDatabase(c.Statement())); err != nil {
 			c.SetError(err)
 			return
 		}
-		c.SetRows(goRowsOut)
+		d := timeouts.derive(ctx, opQuery)
+		goRowsOut, err := c.Statement().Query(d.ctx, c.Parameterer())
+		if err != nil {
+			d.cancel()
+			c.SetError(d.classify(err))
+			return
+		}
+		c.SetRows(cancelOnClose(goRowsOut, d.cancel))
 		c.Next(ctx)
 	})
 	engine.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
-		goInsertResult, err := c.Statement().Insert(ctx, c.Parameterer())
-		if err != nil {
+		if err := r.checkBound(ctx, boundDatabase(c.Statement())); err != nil {
 			c.SetError(err)
 			return
 		}
+		d := timeouts.derive(ctx, opExec)
+		defer d.cancel()
+		goInsertResult, err := c.Statement().Insert(d.ctx, c.Parameterer())
+		if err != nil {
+			c.SetError(d.classify(err))
+			return
+		}
 		c.SetInsertResult(goInsertResult)
 		c.Next(ctx)
 	})
 	engine.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
-		goResult, err := c.Statement().Exec(ctx, c.Parameterer())
-		if err != nil {
+		if err := r.checkBound(ctx, boundDatabase(c.Statement())); err != nil {
 			c.SetError(err)
 			return
 		}
+		d := timeouts.derive(ctx, opExec)
+		defer d.cancel()
+		goResult, err := c.Statement().Exec(d.ctx, c.Parameterer())
+		if err != nil {
+			c.SetError(d.classify(err))
+			return
+		}
 		c.SetResult(goResult)
 		c.Next(ctx)
 	})
 	engine.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
-		err := c.QueryExecTransactioner().Commit()
+		err := timeouts.end(startedWith(c.QueryExecTransactioner()), "commit", c.QueryExecTransactioner().Commit)
 		if err != nil {
 			c.SetError(err)
 			return
@@ -207,7 +313,7 @@ func InstallSingle(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolat
 		c.Next(ctx)
 	})
 	engine.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
-		err := c.QueryExecTransactioner().Rollback()
+		err := timeouts.end(startedWith(c.QueryExecTransactioner()), "rollback", c.QueryExecTransactioner().Rollback)
 		if err != nil {
 			c.SetError(err)
 			return
@@ -228,7 +334,15 @@ func InstallSingle(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolat
 		c.Next(ctx)
 	})
 	engine.ConnCloseMW().Prepend(func(ctx context.Context, c engine_context.Er) {
-		err := db.Close()
+		var err error
+		for _, db := range r.all() {
+			if cfg.StatementCache != nil {
+				cfg.StatementCache.Purge(db)
+			}
+			if closeErr := db.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
 		if err != nil {
 			c.SetError(err)
 			return
@@ -236,3 +350,28 @@ func InstallSingle(engine vsql_engine.SingleTXer, db *sql.DB, factory interpolat
 		c.Next(ctx)
 	})
 }
+
+// prepareOnDB prepares the query outside of a transaction, reusing a statement from the cache if one is given
+func prepareOnDB(ctx context.Context, db *sql.DB, query vparam.Queryer, factory interpolation_strategy.InterpolationStrategyFactory, cache *StatementCache) (*statement, error) {
+	sqlQ := query.SQLQueryInterpolated(factory())
+	var stmtWrap *statement
+	if cache != nil {
+		entry, err := cache.prepare(ctx, db, sqlQ)
+		if err != nil {
+			return nil, err
+		}
+		stmtWrap = newStatement(entry.stmt, factory)
+		stmtWrap.release = func() error {
+			return cache.release(entry)
+		}
+	} else {
+		goStmt, err := db.PrepareContext(ctx, sqlQ)
+		if err != nil {
+			return nil, err
+		}
+		stmtWrap = newStatement(goStmt, factory)
+	}
+	stmtWrap.originalQuery = query
+	stmtWrap.db = db
+	return stmtWrap, nil
+}
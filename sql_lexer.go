@@ -0,0 +1,266 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"strings"
+)
+
+type sqlTokenKind int
+
+const (
+	// tokenWord is a keyword or an un-quoted identifier
+	tokenWord sqlTokenKind = iota
+	// tokenQuotedIdentifier is an identifier wrapped in double quotes, back ticks or square brackets
+	tokenQuotedIdentifier
+	// tokenString is a string literal in single quotes or a postgres dollar-quoted string
+	tokenString
+	// tokenNumber is a numeric literal
+	tokenNumber
+	// tokenPlaceholder is a parameter placeholder: ?, $1 or :name
+	tokenPlaceholder
+	// tokenPunct is an operator, parenthesis, comma, semi-colon, etc.
+	tokenPunct
+	// tokenComment is a -- line comment or a /* block */ comment
+	tokenComment
+)
+
+// sqlToken is a single lexeme of a SQL query. start and end are byte offsets into the original query
+type sqlToken struct {
+	kind  sqlTokenKind
+	text  string
+	start int
+	end   int
+}
+
+// is returns true if the token is a word or punctuation that matches s, ignoring case
+func (t sqlToken) is(s string) bool {
+	return (t.kind == tokenWord || t.kind == tokenPunct) && strings.EqualFold(t.text, s)
+}
+
+// tokenizeSQL splits a query into tokens. It is not a SQL parser, it only knows enough about quoting and comments to
+// reliably find literals, placeholders and statement boundaries across MySQL, Postgres and SQLite. Whitespace is dropped.
+func tokenizeSQL(s string) (tokens []sqlToken) {
+	tokens = make([]sqlToken, 0, len(s)/4)
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		start := i
+		switch {
+		case isSQLSpace(c):
+			i++
+			continue
+		case c == '-' && i+1 < len(s) && s[i+1] == '-':
+			i = indexOrEnd(s, i, "\n")
+			tokens = append(tokens, sqlToken{kind: tokenComment, text: s[start:i], start: start, end: i})
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				i = len(s)
+			} else {
+				i = i + 2 + end + 2
+			}
+			tokens = append(tokens, sqlToken{kind: tokenComment, text: s[start:i], start: start, end: i})
+		case c == '\'':
+			i = scanQuoted(s, i, '\'')
+			tokens = append(tokens, sqlToken{kind: tokenString, text: s[start:i], start: start, end: i})
+		case c == '"' || c == '`':
+			i = scanQuoted(s, i, c)
+			tokens = append(tokens, sqlToken{kind: tokenQuotedIdentifier, text: s[start:i], start: start, end: i})
+		case c == '[':
+			i = indexOrEnd(s, i, "]")
+			if i < len(s) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenQuotedIdentifier, text: s[start:i], start: start, end: i})
+		case c == '$':
+			if tag, ok := dollarQuoteTag(s, i); ok {
+				end := strings.Index(s[i+len(tag):], tag)
+				if end < 0 {
+					i = len(s)
+				} else {
+					i = i + len(tag) + end + len(tag)
+				}
+				tokens = append(tokens, sqlToken{kind: tokenString, text: s[start:i], start: start, end: i})
+			} else if i+1 < len(s) && isSQLDigit(s[i+1]) {
+				i++
+				for i < len(s) && isSQLDigit(s[i]) {
+					i++
+				}
+				tokens = append(tokens, sqlToken{kind: tokenPlaceholder, text: s[start:i], start: start, end: i})
+			} else {
+				i++
+				tokens = append(tokens, sqlToken{kind: tokenPunct, text: s[start:i], start: start, end: i})
+			}
+		case c == '?':
+			i++
+			tokens = append(tokens, sqlToken{kind: tokenPlaceholder, text: s[start:i], start: start, end: i})
+		case c == ':' && i+1 < len(s) && isSQLWordStart(s[i+1]) && (i == 0 || s[i-1] != ':'):
+			i++
+			for i < len(s) && isSQLWordPart(s[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenPlaceholder, text: s[start:i], start: start, end: i})
+		case isSQLDigit(c) || (c == '.' && i+1 < len(s) && isSQLDigit(s[i+1])):
+			i = scanNumber(s, i)
+			tokens = append(tokens, sqlToken{kind: tokenNumber, text: s[start:i], start: start, end: i})
+		case isSQLWordStart(c):
+			for i < len(s) && isSQLWordPart(s[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenWord, text: s[start:i], start: start, end: i})
+		default:
+			i = scanPunct(s, i)
+			tokens = append(tokens, sqlToken{kind: tokenPunct, text: s[start:i], start: start, end: i})
+		}
+	}
+	return
+}
+
+// SplitStatements splits a script into its statements at the semi-colons that are not inside of literals, quoted
+// identifiers or comments. Statements are trimmed and empty statements are dropped. Scripts that change the delimiter,
+// such as MySQL's DELIMITER command, are not supported
+func SplitStatements(script string) (statements []string) {
+	start := 0
+	for _, t := range tokenizeSQL(script) {
+		if t.kind != tokenPunct || t.text != ";" {
+			continue
+		}
+		statements = appendStatement(statements, script[start:t.start])
+		start = t.end
+	}
+	return appendStatement(statements, script[start:])
+}
+
+func appendStatement(statements []string, statement string) []string {
+	statement = strings.TrimSpace(statement)
+	if len(withoutComments(tokenizeSQL(statement))) == 0 {
+		return statements
+	}
+	return append(statements, statement)
+}
+
+// withoutComments filters comment tokens out of the token list
+func withoutComments(tokens []sqlToken) (out []sqlToken) {
+	out = make([]sqlToken, 0, len(tokens))
+	for _, t := range tokens {
+		if t.kind != tokenComment {
+			out = append(out, t)
+		}
+	}
+	return
+}
+
+func scanQuoted(s string, i int, quote byte) int {
+	i++
+	for i < len(s) {
+		if s[i] == '\\' && quote == '\'' {
+			i += 2
+			continue
+		}
+		if s[i] == quote {
+			// doubled quotes are an escaped quote
+			if i+1 < len(s) && s[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return len(s)
+}
+
+func scanNumber(s string, i int) int {
+	if s[i] == '0' && i+1 < len(s) && (s[i+1] == 'x' || s[i+1] == 'X') {
+		i += 2
+		for i < len(s) && isSQLHexDigit(s[i]) {
+			i++
+		}
+		return i
+	}
+	for i < len(s) && (isSQLDigit(s[i]) || s[i] == '.') {
+		i++
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && isSQLDigit(s[j]) {
+			i = j
+			for i < len(s) && isSQLDigit(s[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+// multiCharOperators are checked longest-first so that "<=" is not split into "<" and "="
+var multiCharOperators = []string{"<=>", "::", "<=", ">=", "<>", "!=", "||", ":=", "->>", "->"}
+
+func scanPunct(s string, i int) int {
+	for _, op := range multiCharOperators {
+		if strings.HasPrefix(s[i:], op) {
+			return i + len(op)
+		}
+	}
+	return i + 1
+}
+
+// dollarQuoteTag returns the opening tag of a postgres dollar-quoted string ($$ or $tag$) if one starts at i
+func dollarQuoteTag(s string, i int) (tag string, ok bool) {
+	j := i + 1
+	if j < len(s) && isSQLDigit(s[j]) {
+		return "", false
+	}
+	for j < len(s) && (isSQLWordStart(s[j]) || isSQLDigit(s[j])) {
+		j++
+	}
+	if j < len(s) && s[j] == '$' {
+		return s[i : j+1], true
+	}
+	return "", false
+}
+
+func indexOrEnd(s string, i int, sub string) int {
+	end := strings.Index(s[i:], sub)
+	if end < 0 {
+		return len(s)
+	}
+	return i + end
+}
+
+func isSQLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isSQLDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSQLHexDigit(c byte) bool {
+	return isSQLDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isSQLWordStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c >= 0x80
+}
+
+func isSQLWordPart(c byte) bool {
+	return isSQLWordStart(c) || isSQLDigit(c) || c == '$'
+}
@@ -0,0 +1,49 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql_engine"
+	"testing"
+)
+
+// questionMark is the interpolation strategy for SQLite and MySQL
+type questionMark struct {
+}
+
+func (q *questionMark) InsertPlaceholderIntoSQL() string {
+	return "?"
+}
+
+func questionMarkFactory() interpolation_strategy.InterpolateStrategy {
+	return &questionMark{}
+}
+
+// newSQLiteEngine creates an engine backed by a private in-memory SQLite database
+func newSQLiteEngine(t *testing.T, cfg Config) (engine vsql_engine.SingleTXer, db *sql.DB) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each connection to :memory: is a different database, so only allow one
+	db.SetMaxOpenConns(1)
+	engine = vsql_engine.NewSingle()
+	InstallSingleWithConfig(engine, db, questionMarkFactory, cfg)
+	return engine, db
+}
@@ -18,16 +18,26 @@ package vsql_engine_go
 import (
 	"context"
 	"database/sql"
+	"github.com/wojnosystems/vsql"
 	"github.com/wojnosystems/vsql/interpolation_strategy"
 	"github.com/wojnosystems/vsql/vparam"
 	"github.com/wojnosystems/vsql/vresult"
 	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql/vstmt"
 )
 
 type statement struct {
 	stmt                       *sql.Stmt
 	interpolateStrategyFactory interpolation_strategy.InterpolationStrategyFactory
 	originalQuery              vparam.Queryer
+	// db is the database the statement was prepared on
+	db *sql.DB
+	// transaction is the transaction the statement was prepared in, nil if it was prepared on the database
+	transaction *queryExecTransaction
+	// release, if set, is called instead of closing stmt, used when stmt is shared through a StatementCache
+	release func() error
+	// engine is the engine the statement was prepared through, nil if it was prepared in a transaction or session
+	engine vsql.QueryExecer
 }
 
 func newStatement(s *sql.Stmt, interpolateStrategyFactory interpolation_strategy.InterpolationStrategyFactory) *statement {
@@ -38,6 +48,12 @@ func newStatement(s *sql.Stmt, interpolateStrategyFactory interpolation_strategy
 }
 
 func (s *statement) Close() error {
+	if s.release != nil {
+		release := s.release
+		// releasing twice would let the cache close the statement while others are still using it
+		s.release = func() error { return nil }
+		return release()
+	}
 	return s.stmt.Close()
 }
 
@@ -71,3 +87,46 @@ func (s *statement) Insert(ctx context.Context, query vparam.Parameterer) (resul
 func (s *statement) Exec(ctx context.Context, query vparam.Parameterer) (result vresult.Resulter, err error) {
 	return s.Insert(ctx, query)
 }
+
+// ExecBatch runs the statement once per parameter set, see BatchExecer.
+// A statement prepared through the engine is prepared again through it for the batch, in the implicit transaction if
+// one is requested, so the transaction and every item go through the engine's middleware. A statement prepared in a
+// transaction or session runs each item itself and ignores Transaction, as the transaction already is all-or-nothing
+func (s *statement) ExecBatch(ctx context.Context, params []vparam.Parameterer, opts BatchExecOptions) (BatchExecResult, error) {
+	return s.runBatch(ctx, params, opts, execItem)
+}
+
+// InsertBatch is ExecBatch that also collects the insert id of each item, see BatchExecer
+func (s *statement) InsertBatch(ctx context.Context, params []vparam.Parameterer, opts BatchExecOptions) (BatchExecResult, error) {
+	return s.runBatch(ctx, params, opts, insertItem)
+}
+
+func (s *statement) runBatch(ctx context.Context, params []vparam.Parameterer, opts BatchExecOptions, run batchItemRunner) (BatchExecResult, error) {
+	if s.engine != nil {
+		return queryBatch(ctx, s.engine, s.originalQuery, params, opts, run)
+	}
+	return runBatch(params, opts, func(p vparam.Parameterer) BatchItemResult {
+		return run(ctx, s, p)
+	})
+}
+
+// statementQuery returns the query a statement was prepared from, or nil if the statement was not created by this package
+func statementQuery(s vstmt.Statementer) vparam.Queryer {
+	if st, ok := s.(*statement); ok {
+		return st.originalQuery
+	}
+	return nil
+}
+
+// boundDatabase returns the database a transaction or statement created by this package is tied to, nil if unknown
+func boundDatabase(v interface{}) *sql.DB {
+	switch b := v.(type) {
+	case *queryExecTransaction:
+		return b.db
+	case *queryExecSession:
+		return b.db
+	case *statement:
+		return b.db
+	}
+	return nil
+}
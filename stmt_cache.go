@@ -0,0 +1,199 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// StatementCache keeps prepared statements open so that preparing the same SQL again reuses the statement instead of
+// making a round trip to the database. Statements are keyed by their interpolated SQL and the database they were
+// prepared on. Closing a statement returned from the cache only releases it; the underlying statement is closed once
+// it has been evicted and every user has released it. Pass it to the installers using Config.StatementCache
+type StatementCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[statementCacheKey]*list.Element
+	// lru holds *cachedStatement, most recently used at the front
+	lru   *list.List
+	stats StatementCacheStats
+}
+
+// StatementCacheStats are the counters of a StatementCache
+type StatementCacheStats struct {
+	// Hits is the number of prepares that reused a cached statement
+	Hits uint64
+	// Misses is the number of prepares that went to the database
+	Misses uint64
+	// Evictions is the number of statements removed to make room for others
+	Evictions uint64
+	// Size is the number of statements currently cached
+	Size int
+}
+
+type statementCacheKey struct {
+	db    *sql.DB
+	query string
+}
+
+type cachedStatement struct {
+	key  statementCacheKey
+	stmt *sql.Stmt
+	// refs is the number of users that have not released the statement yet
+	refs int
+	// evicted is true once the statement has been removed from the cache, it is closed when refs reaches zero
+	evicted bool
+}
+
+// NewStatementCache creates a cache that holds at most capacity statements. Capacity less than 1 is treated as 1
+func NewStatementCache(capacity int) *StatementCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &StatementCache{
+		capacity: capacity,
+		entries:  make(map[statementCacheKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *StatementCache) Stats() StatementCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Size = c.lru.Len()
+	return s
+}
+
+// prepare returns the cached statement for the query, preparing and caching it on a miss. Every successful call must
+// be followed by a call to release
+func (c *StatementCache) prepare(ctx context.Context, db *sql.DB, query string) (*cachedStatement, error) {
+	key := statementCacheKey{db: db, query: query}
+	if entry, ok := c.acquire(key); ok {
+		return entry, nil
+	}
+
+	// prepare without holding the lock so a slow prepare does not block other callers
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		// another caller prepared the same statement in the meantime, use theirs
+		c.lru.MoveToFront(el)
+		entry := el.Value.(*cachedStatement)
+		entry.refs++
+		c.mu.Unlock()
+		_ = stmt.Close()
+		return entry, nil
+	}
+	entry := &cachedStatement{key: key, stmt: stmt, refs: 1}
+	c.entries[key] = c.lru.PushFront(entry)
+	toClose := c.evictLocked()
+	c.mu.Unlock()
+	closeStatements(toClose)
+	return entry, nil
+}
+
+// acquire returns the cached statement for the key, if any, counting a hit or a miss
+func (c *StatementCache) acquire(key statementCacheKey) (*cachedStatement, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.acquireLocked(key)
+	if !ok {
+		c.stats.Misses++
+	}
+	return entry, ok
+}
+
+// borrow is acquire for transactions, which only use statements that are already cached. A statement that is not
+// cached is prepared in the transaction and never added to the cache, so it is not counted as a miss
+func (c *StatementCache) borrow(key statementCacheKey) (*cachedStatement, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.acquireLocked(key)
+}
+
+// acquireLocked returns the cached statement for the key, if any, counting a hit. Caller must hold the lock
+func (c *StatementCache) acquireLocked(key statementCacheKey) (*cachedStatement, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+	entry := el.Value.(*cachedStatement)
+	entry.refs++
+	return entry, true
+}
+
+// release gives back a statement obtained from prepare. The statement is closed if it was evicted and this was the last user
+func (c *StatementCache) release(entry *cachedStatement) error {
+	c.mu.Lock()
+	entry.refs--
+	closeNow := entry.evicted && entry.refs == 0
+	c.mu.Unlock()
+	if closeNow {
+		return entry.stmt.Close()
+	}
+	return nil
+}
+
+// evictLocked removes the least recently used statements until the cache is within capacity, returning the statements
+// that are no longer in use and must be closed. Caller must hold the lock
+func (c *StatementCache) evictLocked() (toClose []*sql.Stmt) {
+	for c.lru.Len() > c.capacity {
+		entry := c.lru.Remove(c.lru.Back()).(*cachedStatement)
+		delete(c.entries, entry.key)
+		entry.evicted = true
+		c.stats.Evictions++
+		if entry.refs == 0 {
+			toClose = append(toClose, entry.stmt)
+		}
+	}
+	return
+}
+
+// Purge evicts every statement prepared on the database. Statements still in use are closed when released
+func (c *StatementCache) Purge(db *sql.DB) {
+	c.mu.Lock()
+	var toClose []*sql.Stmt
+	for key, el := range c.entries {
+		if key.db != db {
+			continue
+		}
+		entry := c.lru.Remove(el).(*cachedStatement)
+		delete(c.entries, key)
+		entry.evicted = true
+		if entry.refs == 0 {
+			toClose = append(toClose, entry.stmt)
+		}
+	}
+	c.mu.Unlock()
+	closeStatements(toClose)
+}
+
+func closeStatements(stmts []*sql.Stmt) {
+	for _, stmt := range stmts {
+		_ = stmt.Close()
+	}
+}
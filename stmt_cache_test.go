@@ -0,0 +1,108 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"testing"
+)
+
+func TestStatementCache(t *testing.T) {
+	cache := NewStatementCache(1)
+	engine, _ := newSQLiteEngine(t, Config{StatementCache: cache})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		stmt, err := engine.Prepare(ctx, vparam.New("SELECT 1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = stmt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := cache.Stats(); s.Hits != 1 || s.Misses != 1 || s.Size != 1 {
+		t.Errorf("unexpected stats after re-preparing: %+v", s)
+	}
+
+	// hold the first statement while it is evicted, it must remain usable until closed
+	held, err := engine.Prepare(ctx, vparam.New("SELECT 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := engine.Prepare(ctx, vparam.New("SELECT 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := cache.Stats(); s.Evictions != 1 || s.Size != 1 {
+		t.Errorf("unexpected stats after eviction: %+v", s)
+	}
+	rows, err := held.Query(ctx, vparam.NewAppendData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() == nil {
+		t.Error("expected the evicted statement to still return rows")
+	}
+	_ = rows.Close()
+	_ = held.Close()
+	_ = other.Close()
+}
+
+func TestStatementCache_Transaction(t *testing.T) {
+	cache := NewStatementCache(4)
+	engine, _ := newSQLiteEngine(t, Config{StatementCache: cache})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+
+	stmt, err := engine.Prepare(ctx, vparam.New("SELECT 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = stmt.Close()
+
+	tx, err := engine.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txStmt, err := tx.Prepare(ctx, vparam.New("SELECT 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := txStmt.Query(ctx, vparam.NewAppendData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() == nil {
+		t.Error("expected the transaction statement to return rows")
+	}
+	_ = rows.Close()
+	_ = txStmt.Close()
+	// statements prepared only in the transaction are never cached, so they are not misses
+	localStmt, err := tx.Prepare(ctx, vparam.New("SELECT 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = localStmt.Close()
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if s := cache.Stats(); s.Hits != 1 || s.Misses != 1 || s.Size != 1 {
+		t.Errorf("expected the transaction to reuse the cached statement: %+v", s)
+	}
+}
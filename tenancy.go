@@ -0,0 +1,196 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"strings"
+)
+
+// ErrTenantSessionVariableUnsupported is returned by InstallTenancy when a SessionVariable is configured for a
+// dialect that has no session variables
+var ErrTenantSessionVariableUnsupported = errors.New("the dialect does not support setting the tenant in a session variable")
+
+// WithTenant returns a context.Context that scopes the queries run with it to the tenant. An empty tenant counts as
+// no tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
+// allTenants is stored instead of a tenant by WithAllTenants
+type allTenants struct{}
+
+// WithAllTenants returns a context.Context that lets queries run with it use the tenant-scoped tables without a
+// tenant, such as for migrations, reports and other jobs that work across tenants
+func WithAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantContextKey, allTenants{})
+}
+
+// TenantFromContext returns the tenant set with WithTenant
+// @return ok is false if no tenant was set, or it was set to the empty string, which scopes to no tenant at all
+func TenantFromContext(ctx context.Context) (tenant string, ok bool) {
+	if ctx == nil {
+		return
+	}
+	tenant, _ = ctx.Value(tenantContextKey).(string)
+	return tenant, tenant != ""
+}
+
+func isAllTenants(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	_, ok := ctx.Value(tenantContextKey).(allTenants)
+	return ok
+}
+
+// MissingTenantError is returned for queries that use a tenant-scoped table without a tenant in their context.Context
+type MissingTenantError struct {
+	// Table is the first tenant-scoped table found in the query
+	Table string
+}
+
+func (e *MissingTenantError) Error() string {
+	return fmt.Sprintf("query uses the tenant-scoped table %s without a tenant", e.Table)
+}
+
+// TenancyConfig sets up the tenancy middleware
+type TenancyConfig struct {
+	// Tables are the names of the tenant-scoped tables. Unquoted names are matched case-insensitively and schema-qualified
+	// names are matched by their table name
+	Tables []string
+	// SessionVariable, when set, is set to the tenant at the start of every transaction, so that row-level security
+	// policies can read it. In postgres, it is a setting such as "app.tenant_id", local to the transaction and read with
+	// current_setting('app.tenant_id'). In SQL Server, it is a SESSION_CONTEXT key. In MySQL, it is a user variable.
+	// The SQL Server key and the MySQL variable are cleared when the transaction commits or rolls back, so that they do
+	// not stay set on the pooled connection. The variable is only set inside transactions: queries run outside of one,
+	// or in a Session, see BeginSession, find it unset, so row-level security policies must treat an unset tenant as
+	// no access
+	SessionVariable string
+	// Dialect picks how the SessionVariable is set
+	Dialect Dialect
+}
+
+// InstallTenancy adds middleware that refuses queries, inserts, execs and prepared statements that use a
+// tenant-scoped table when their context.Context has neither a tenant, see WithTenant, nor WithAllTenants. A table is
+// used if its name appears anywhere in the query as an identifier, which also refuses columns and aliases that share
+// its name rather than risk missing a use. The middleware does not add the tenant to the query: that is left to the
+// query itself or to row-level security with a SessionVariable. Nothing is installed if the configuration is invalid
+func InstallTenancy(engine vsql_engine.SingleTXer, cfg TenancyConfig) error {
+	setTenant, supported := tenantSessionSQL(cfg.Dialect, cfg.SessionVariable)
+	if cfg.SessionVariable != "" && !supported {
+		return ErrTenantSessionVariableUnsupported
+	}
+	scoped := make(map[string]bool, len(cfg.Tables))
+	for _, table := range cfg.Tables {
+		if tokens := tokenizeSQL(table); len(tokens) != 0 {
+			scoped[unquoteIdentifier(tokens[len(tokens)-1])] = true
+		}
+	}
+	check := func(ctx context.Context, query vparam.Queryer) error {
+		if query == nil || isAllTenants(ctx) {
+			return nil
+		}
+		if _, ok := TenantFromContext(ctx); ok {
+			return nil
+		}
+		if table, ok := scopedTable(query.SQLQueryUnInterpolated(), scoped); ok {
+			return &MissingTenantError{Table: table}
+		}
+		return nil
+	}
+
+	installQueryCheck(engine, check)
+	// statements are checked again when they are run, as they may be prepared and run with different tenants
+	installStatementCheck(engine, check)
+
+	if cfg.SessionVariable == "" {
+		return nil
+	}
+	engine.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		c.Next(ctx)
+		tenant, ok := TenantFromContext(ctx)
+		if c.Error() != nil || !ok {
+			return
+		}
+		qet, ok := c.QueryExecTransactioner().(*queryExecTransaction)
+		if !ok {
+			return
+		}
+		if _, err := qet.goTransaction.ExecContext(ctx, setTenant, tenantSessionArgs(cfg.Dialect, cfg.SessionVariable, tenant)...); err != nil {
+			_ = qet.Rollback()
+			c.SetError(err)
+			return
+		}
+		if clearTenant, args, ok := tenantClearSQL(cfg.Dialect, cfg.SessionVariable); ok {
+			// the connection goes back to the pool once the transaction ends, without the tenant
+			qet.onEnd(func() error {
+				_, err := qet.goTransaction.Exec(clearTenant, args...)
+				return err
+			})
+		}
+	})
+	return nil
+}
+
+// scopedTable returns the first identifier of the query that names a scoped table
+func scopedTable(sqlQuery string, scoped map[string]bool) (table string, ok bool) {
+	for _, t := range tokenizeSQL(sqlQuery) {
+		if t.kind != tokenWord && t.kind != tokenQuotedIdentifier {
+			continue
+		}
+		if name := unquoteIdentifier(t); scoped[name] {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// tenantSessionSQL returns the statement that sets the session variable to the tenant, in the driver's placeholder style
+func tenantSessionSQL(dialect Dialect, variable string) (sqlQuery string, ok bool) {
+	switch dialect {
+	case PostgresDialect:
+		return "SELECT set_config($1, $2, true)", true
+	case SQLServerDialect:
+		return "EXEC sp_set_session_context @key = @p1, @value = @p2", true
+	case MySQLDialect:
+		return "SET @`" + strings.Replace(variable, "`", "``", -1) + "` = ?", true
+	}
+	return "", false
+}
+
+func tenantSessionArgs(dialect Dialect, variable, tenant string) []interface{} {
+	if dialect == MySQLDialect {
+		return []interface{}{tenant}
+	}
+	return []interface{}{variable, tenant}
+}
+
+// tenantClearSQL returns the statement that unsets the session variable, if it outlives the transaction
+func tenantClearSQL(dialect Dialect, variable string) (sqlQuery string, args []interface{}, ok bool) {
+	switch dialect {
+	case SQLServerDialect:
+		return "EXEC sp_set_session_context @key = @p1, @value = NULL", []interface{}{variable}, true
+	case MySQLDialect:
+		return "SET @`" + strings.Replace(variable, "`", "``", -1) + "` = NULL", nil, true
+	}
+	return "", nil, false
+}
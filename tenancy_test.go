@@ -0,0 +1,89 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"testing"
+)
+
+func TestInstallTenancy(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	for _, q := range []string{"CREATE TABLE invoices (id INTEGER, tenant_id TEXT)", "CREATE TABLE plans (id INTEGER)"} {
+		if _, err := engine.Exec(ctx, vparam.New(q)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := InstallTenancy(engine, TenancyConfig{Tables: []string{"app.Invoices"}}); err != nil {
+		t.Fatal(err)
+	}
+	acme := WithTenant(ctx, "acme")
+
+	if _, err := engine.Exec(acme, vparam.NewAppendWithData("INSERT INTO invoices VALUES (?, ?)", 1, "acme")); err != nil {
+		t.Errorf("expected the insert with a tenant to run: %v", err)
+	}
+	rows, err := engine.Query(ctx, vparam.New("SELECT id FROM plans"))
+	if err != nil {
+		t.Errorf("expected tables that are not scoped to be queried without a tenant: %v", err)
+	} else {
+		_ = rows.Close()
+	}
+	_, err = engine.Query(ctx, vparam.New("SELECT p.id FROM plans p JOIN \"invoices\" i ON i.id = p.id"))
+	if e, ok := err.(*MissingTenantError); !ok || e.Table != "invoices" {
+		t.Errorf("expected the join without a tenant to be refused, got: %v", err)
+	}
+	// an empty tenant, such as an unset field, does not scope anything
+	if _, err = engine.Query(WithTenant(ctx, ""), vparam.New("SELECT id FROM invoices")); err == nil {
+		t.Error("expected the query with an empty tenant to be refused")
+	}
+	if _, err = engine.Exec(WithAllTenants(ctx), vparam.New("DELETE FROM INVOICES WHERE id = 2")); err != nil {
+		t.Errorf("expected the cross-tenant delete to run: %v", err)
+	}
+
+	// a statement prepared for a tenant is checked again when it is run
+	stmt, err := engine.Prepare(acme, vparam.NewNamed("UPDATE invoices SET id = :id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = stmt.Close() }()
+	if _, err = stmt.Exec(ctx, vparam.NewNamedData(map[string]interface{}{"id": 3})); err == nil {
+		t.Error("expected running the statement without a tenant to be refused")
+	}
+	if _, err = stmt.Exec(acme, vparam.NewNamedData(map[string]interface{}{"id": 3})); err != nil {
+		t.Errorf("expected running the statement with a tenant to succeed: %v", err)
+	}
+}
+
+func TestInstallTenancy_SessionVariable(t *testing.T) {
+	engine, _ := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	err := InstallTenancy(engine, TenancyConfig{Tables: []string{"invoices"}, SessionVariable: "app.tenant_id", Dialect: SQLiteDialect})
+	if err != ErrTenantSessionVariableUnsupported {
+		t.Errorf("expected SQLite to be unsupported, got: %v", err)
+	}
+	if q, _ := tenantSessionSQL(MySQLDialect, "app`tenant"); q != "SET @`app``tenant` = ?" {
+		t.Errorf("expected the user variable to be quoted, got: %s", q)
+	}
+	if q, _, ok := tenantClearSQL(MySQLDialect, "app`tenant"); !ok || q != "SET @`app``tenant` = NULL" {
+		t.Errorf("expected the user variable to be cleared when the transaction ends, got: %s", q)
+	}
+	if _, _, ok := tenantClearSQL(PostgresDialect, "app.tenant_id"); ok {
+		t.Error("expected the transaction-local postgres setting to be left to the transaction")
+	}
+}
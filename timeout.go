@@ -0,0 +1,274 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Timeouts are the default deadlines for each kind of database call. A zero duration means no default deadline is
+// applied. Defaults never extend a deadline: if the caller's context.Context already expires sooner, it is used as-is
+type Timeouts struct {
+	// Query bounds Query and prepared statement Query calls, including reading the rows: the deadline is released when the rows are closed
+	Query time.Duration
+	// Exec bounds Exec and Insert calls and their prepared statement counterparts
+	Exec time.Duration
+	// Begin bounds how long starting a transaction may take
+	Begin time.Duration
+	// Commit bounds how long ending a transaction with Commit or Rollback may take. The deadline of the context.Context the
+	// transaction was started with applies as well, see OutcomePendingError
+	Commit time.Duration
+	// Ping bounds connectivity checks
+	Ping time.Duration
+	// Prepare bounds preparing statements
+	Prepare time.Duration
+}
+
+func (t Timeouts) forOperation(op operation) time.Duration {
+	switch op {
+	case opQuery:
+		return t.Query
+	case opExec:
+		return t.Exec
+	case opBegin:
+		return t.Begin
+	case opCommit:
+		return t.Commit
+	case opPing:
+		return t.Ping
+	case opPrepare:
+		return t.Prepare
+	}
+	return 0
+}
+
+// TimeoutError is returned when a call ran past the default deadline configured in Timeouts. Calls that were
+// canceled, or that ran past a deadline the caller set on their own context.Context, return the context error instead
+type TimeoutError struct {
+	// Operation is the kind of call that timed out: query, exec, begin, commit, ping or prepare
+	Operation string
+	// Timeout is the default deadline that was exceeded
+	Timeout time.Duration
+	// Err is the error reported by the driver
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s: %v", e.Operation, e.Timeout, e.Err)
+}
+
+// Unwrap returns the error reported by the driver
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// OutcomePendingError is returned when Commit or Rollback did not finish within the Commit timeout, or before the
+// context.Context the transaction was started with ended. database/sql cannot interrupt either call, so it keeps
+// running in the background: use Wait to learn whether the transaction was committed or rolled back
+type OutcomePendingError struct {
+	// Operation is the call that did not finish: commit or rollback
+	Operation string
+	// Err is the TimeoutError, or the error of the transaction's context.Context, that ended the wait
+	Err  error
+	call *pendingEnd
+}
+
+func (e *OutcomePendingError) Error() string {
+	return fmt.Sprintf("%s outcome unknown: %v", e.Operation, e.Err)
+}
+
+// Unwrap returns the error that ended the wait
+func (e *OutcomePendingError) Unwrap() error {
+	return e.Err
+}
+
+// Wait blocks until the Commit or Rollback finishes and returns its result
+// @return nil if the call succeeded, the error reported by the driver otherwise
+func (e *OutcomePendingError) Wait() error {
+	<-e.call.done
+	return e.call.err
+}
+
+// pendingEnd is a Commit or Rollback running in the background. err is set before done is closed
+type pendingEnd struct {
+	done chan struct{}
+	err  error
+}
+
+// IsTimeout returns true if the error was caused by a default timeout or by a deadline on the caller's context.Context
+func IsTimeout(err error) bool {
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// IsCanceled returns true if the error was caused by the caller canceling their context.Context
+func IsCanceled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// deadline is the context.Context used for a single database call along with what is needed to tell whether that call
+// failed because of the default timeout
+type deadline struct {
+	parent  context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	op      operation
+	timeout time.Duration
+}
+
+// applicable returns the default timeout for the operation, or zero if it does not apply because ctx already has an earlier deadline
+func (t Timeouts) applicable(ctx context.Context, op operation) time.Duration {
+	timeout := t.forOperation(op)
+	if timeout <= 0 {
+		return 0
+	}
+	if ctx == nil {
+		return timeout
+	}
+	if existing, ok := ctx.Deadline(); ok && time.Until(existing) <= timeout {
+		return 0
+	}
+	return timeout
+}
+
+// derive creates the context.Context for a call. Always call cancel once the call, and for queries, the rows, are done
+func (t Timeouts) derive(ctx context.Context, op operation) *deadline {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	d := &deadline{
+		parent:  ctx,
+		ctx:     ctx,
+		cancel:  func() {},
+		op:      op,
+		timeout: t.applicable(ctx, op),
+	}
+	if d.timeout > 0 {
+		d.ctx, d.cancel = context.WithTimeout(ctx, d.timeout)
+	}
+	return d
+}
+
+// classify converts errors caused by the default timeout into a TimeoutError. All other errors are returned as-is
+func (d *deadline) classify(err error) error {
+	if err == nil || d.timeout == 0 {
+		return err
+	}
+	if d.ctx.Err() == context.DeadlineExceeded && d.parent.Err() == nil {
+		return &TimeoutError{
+			Operation: d.op.String(),
+			Timeout:   d.timeout,
+			Err:       err,
+		}
+	}
+	return err
+}
+
+// beginTx starts a transaction, giving up after the Begin timeout. database/sql rolls a transaction back when the
+// context.Context passed to BeginTx ends, so the timeout cannot be applied to that context. Instead, BeginTx is given a
+// context.Context that is only canceled when the timeout elapses first, which aborts the call, or rolls the
+// transaction back if it was started in the meantime
+// @return release must be called once the transaction has ended, nil if the transaction could not be started
+func (t Timeouts) beginTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions) (tx *sql.Tx, release context.CancelFunc, err error) {
+	timeout := t.applicable(ctx, opBegin)
+	if timeout == 0 {
+		tx, err = db.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tx, func() {}, nil
+	}
+	type beginResult struct {
+		tx  *sql.Tx
+		err error
+	}
+	beginCtx, cancel := context.WithCancel(ctx)
+	done := make(chan beginResult, 1)
+	go func() {
+		tx, err := db.BeginTx(beginCtx, opts)
+		done <- beginResult{tx: tx, err: err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			cancel()
+			return nil, nil, r.err
+		}
+		return r.tx, cancel, nil
+	case <-timer.C:
+		cancel()
+		return nil, nil, &TimeoutError{
+			Operation: opBegin.String(),
+			Timeout:   timeout,
+			Err:       context.DeadlineExceeded,
+		}
+	}
+}
+
+// end runs Commit or Rollback, giving up after the Commit timeout or once ctx ends. database/sql does not accept a
+// context.Context for either, so the call continues in the background when the wait ends early, and its result is
+// made available through the returned OutcomePendingError
+// @param ctx is the context.Context the transaction was started with
+// @param name is the call being made: commit or rollback
+func (t Timeouts) end(ctx context.Context, name string, ender func() error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := t.applicable(ctx, opCommit)
+	if timeout == 0 && ctx.Done() == nil {
+		return ender()
+	}
+	call := &pendingEnd{done: make(chan struct{})}
+	go func() {
+		call.err = ender()
+		close(call.done)
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-call.done:
+		return call.err
+	case <-expired:
+		return &OutcomePendingError{
+			Operation: name,
+			Err: &TimeoutError{
+				Operation: opCommit.String(),
+				Timeout:   timeout,
+				Err:       context.DeadlineExceeded,
+			},
+			call: call,
+		}
+	case <-ctx.Done():
+		return &OutcomePendingError{
+			Operation: name,
+			Err:       ctx.Err(),
+			call:      call,
+		}
+	}
+}
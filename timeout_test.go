@@ -0,0 +1,130 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimeouts_DeriveKeepsEarlierDeadline(t *testing.T) {
+	timeouts := Timeouts{Query: time.Hour}
+	parent, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	d := timeouts.derive(parent, opQuery)
+	defer d.cancel()
+	if d.ctx != parent {
+		t.Error("expected the caller's earlier deadline to be used")
+	}
+}
+
+func TestTimeouts_ClassifiesDefaultTimeout(t *testing.T) {
+	timeouts := Timeouts{Exec: time.Millisecond}
+	d := timeouts.derive(context.Background(), opExec)
+	defer d.cancel()
+	<-d.ctx.Done()
+	err := d.classify(d.ctx.Err())
+	if terr, ok := err.(*TimeoutError); !ok || terr.Operation != "exec" {
+		t.Errorf("expected an exec TimeoutError, got: %v", err)
+	}
+	if !IsTimeout(err) {
+		t.Error("expected IsTimeout to be true")
+	}
+}
+
+func TestTimeouts_CancellationIsNotTimeout(t *testing.T) {
+	timeouts := Timeouts{Exec: time.Hour}
+	parent, cancel := context.WithCancel(context.Background())
+	d := timeouts.derive(parent, opExec)
+	defer d.cancel()
+	cancel()
+	err := d.classify(d.ctx.Err())
+	if IsTimeout(err) || !IsCanceled(err) {
+		t.Errorf("expected a cancellation, got: %v", err)
+	}
+}
+
+func TestIsTimeout_Unwraps(t *testing.T) {
+	wrapped := fmt.Errorf("saving: %w", &TimeoutError{Operation: "exec", Err: context.DeadlineExceeded})
+	if !IsTimeout(wrapped) {
+		t.Error("expected a wrapped TimeoutError to be a timeout")
+	}
+	if !IsCanceled(fmt.Errorf("saving: %w", context.Canceled)) {
+		t.Error("expected a wrapped cancellation to be canceled")
+	}
+}
+
+func TestTimeouts_EndHonorsTransactionDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	commitErr := errors.New("commit failed")
+	err := Timeouts{Commit: time.Hour}.end(ctx, "commit", func() error {
+		<-release
+		return commitErr
+	})
+	pending, ok := err.(*OutcomePendingError)
+	if !ok {
+		t.Fatalf("expected an OutcomePendingError, got: %v", err)
+	}
+	if !IsTimeout(err) || pending.Operation != "commit" {
+		t.Errorf("expected a commit timeout, got: %v", err)
+	}
+	close(release)
+	if waitErr := pending.Wait(); waitErr != commitErr {
+		t.Errorf("expected Wait to return the commit's error, got: %v", waitErr)
+	}
+}
+
+func TestTimeouts_EndTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	err := Timeouts{Commit: time.Millisecond}.end(context.Background(), "rollback", func() error {
+		<-release
+		return nil
+	})
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Operation != "commit" {
+		t.Fatalf("expected a commit TimeoutError, got: %v", err)
+	}
+	close(release)
+	if waitErr := err.(*OutcomePendingError).Wait(); waitErr != nil {
+		t.Errorf("expected the rollback to succeed, got: %v", waitErr)
+	}
+}
+
+func TestTimeouts_BeginTxGivesUpWhenPoolIsBusy(t *testing.T) {
+	engine, db := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = Timeouts{Begin: time.Millisecond}.beginTx(context.Background(), db, nil)
+	if !IsTimeout(err) {
+		t.Errorf("expected a begin timeout, got: %v", err)
+	}
+	_ = conn.Close()
+	// the abandoned BeginTx must not keep the only connection
+	tx, release, err := Timeouts{Begin: time.Second}.beginTx(context.Background(), db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = tx.Rollback()
+	release()
+}
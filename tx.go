@@ -18,36 +18,110 @@ package vsql_engine_go
 import (
 	"context"
 	"database/sql"
+	"github.com/wojnosystems/vsql"
 	"github.com/wojnosystems/vsql/interpolation_strategy"
 	"github.com/wojnosystems/vsql/vparam"
 	"github.com/wojnosystems/vsql/vresult"
 	"github.com/wojnosystems/vsql/vrows"
 	"github.com/wojnosystems/vsql/vstmt"
+	"sync/atomic"
 )
 
 type queryExecTransaction struct {
-	goTransaction              *sql.Tx
+	goTransaction *sql.Tx
+	// ctx is the context.Context the transaction was started with
+	ctx context.Context
+	// release frees the context.Context the transaction was started with once it ends, if set
+	release context.CancelFunc
+	// id identifies the transaction within the process, see AuditRecord.TransactionID
+	id uint64
+	// db is the database the transaction was started on
+	db                         *sql.DB
 	interpolateStrategyFactory interpolation_strategy.InterpolationStrategyFactory
+	// statementCache, if set, is checked for statements already prepared on db before preparing in the transaction
+	statementCache *StatementCache
+	// heldStatements are the cached statements used by this transaction, released when it ends
+	heldStatements []*cachedStatement
+	// pendingTags are the table tags written by this transaction for each QueryCache, guarded by the cache's lock
+	pendingTags map[*QueryCache]map[string]struct{}
+	endHooks
 }
 
-func newQueryExecTransaction(goTransaction *sql.Tx, interpolateStrategyFactory interpolation_strategy.InterpolationStrategyFactory) *queryExecTransaction {
+// lastTransactionID is the id of the most recently started transaction
+var lastTransactionID uint64
+
+func newQueryExecTransaction(goTransaction *sql.Tx, db *sql.DB, interpolateStrategyFactory interpolation_strategy.InterpolationStrategyFactory) *queryExecTransaction {
 	return &queryExecTransaction{
 		goTransaction:              goTransaction,
+		id:                         atomic.AddUint64(&lastTransactionID, 1),
+		db:                         db,
 		interpolateStrategyFactory: interpolateStrategyFactory,
 	}
 }
 
-// Commit ends a transaction by persisting the requested changes
-func (q *queryExecTransaction) Commit() error {
+// Commit ends a transaction by persisting the requested changes. If an end hook fails, the transaction is rolled back
+// instead, so that an error always means that nothing was committed
+func (q *queryExecTransaction) Commit() (err error) {
+	defer q.releaseStatements()
+	defer q.ended()
+	if err = q.runEndHooks(); err != nil {
+		_ = q.goTransaction.Rollback()
+		return err
+	}
 	return q.goTransaction.Commit()
 }
 
 // Rollback ends a transaction by not persisting the changes made via queries while within the transaction
 func (q *queryExecTransaction) Rollback() error {
-	return q.goTransaction.Rollback()
+	defer q.releaseStatements()
+	hookErr := q.runEndHooks()
+	err := q.goTransaction.Rollback()
+	q.ended()
+	if err != nil {
+		return err
+	}
+	return hookErr
+}
+
+// ended frees the context.Context the transaction was started with
+func (q *queryExecTransaction) ended() {
+	if q.release != nil {
+		q.release()
+	}
+}
+
+// endHooks are run right before a transaction or session ends, while its connection is still usable. The hooks of a
+// transaction run in the transaction, so their changes are committed or rolled back with it
+type endHooks struct {
+	hooks []func() error
+}
+
+func (h *endHooks) onEnd(hook func() error) {
+	h.hooks = append(h.hooks, hook)
+}
+
+// runEndHooks runs the hooks, most recent first, and returns the first error
+func (h *endHooks) runEndHooks() (err error) {
+	for i := len(h.hooks) - 1; i >= 0; i-- {
+		if hookErr := h.hooks[i](); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}
+	h.hooks = nil
+	return
+}
+
+// releaseStatements gives back the cached statements borrowed by the transaction. Transaction statements made from
+// them are closed by database/sql when the transaction ends
+func (q *queryExecTransaction) releaseStatements() {
+	for _, entry := range q.heldStatements {
+		_ = q.statementCache.release(entry)
+	}
+	q.heldStatements = nil
 }
 
 func (q *queryExecTransaction) Query(ctx context.Context, query vparam.Queryer) (rows vrows.Rowser, err error) {
+	recordScope(ctx, q)
 	queryString, values, err := query.Interpolate(query.SQLQueryUnInterpolated(), q.interpolateStrategyFactory())
 	if err != nil {
 		return nil, err
@@ -62,6 +136,7 @@ func (q *queryExecTransaction) Query(ctx context.Context, query vparam.Queryer)
 	return r, err
 }
 func (q *queryExecTransaction) Insert(ctx context.Context, query vparam.Queryer) (result vresult.InsertResulter, err error) {
+	recordScope(ctx, q)
 	queryString, values, err := query.Interpolate(query.SQLQueryUnInterpolated(), q.interpolateStrategyFactory())
 	if err != nil {
 		return nil, err
@@ -76,11 +151,40 @@ func (q *queryExecTransaction) Exec(ctx context.Context, query vparam.Queryer) (
 	return q.Insert(ctx, query)
 }
 func (q *queryExecTransaction) Prepare(ctx context.Context, query vparam.Queryer) (stmt vstmt.Statementer, err error) {
-	goStmt, err := q.goTransaction.PrepareContext(ctx, query.SQLQueryInterpolated(q.interpolateStrategyFactory()))
-	if err != nil {
-		return
+	sqlQ := query.SQLQueryInterpolated(q.interpolateStrategyFactory())
+	var goStmt *sql.Stmt
+	if entry, ok := q.cachedStatement(sqlQ); ok {
+		// re-use the statement already prepared on the database instead of preparing it again
+		goStmt = q.goTransaction.StmtContext(ctx, entry.stmt)
+		q.heldStatements = append(q.heldStatements, entry)
+	} else {
+		goStmt, err = q.goTransaction.PrepareContext(ctx, sqlQ)
+		if err != nil {
+			return
+		}
 	}
 	stmtWrapper := newStatement(goStmt, q.interpolateStrategyFactory)
 	stmtWrapper.originalQuery = query
+	stmtWrapper.db = q.db
+	stmtWrapper.transaction = q
 	return stmtWrapper, err
 }
+
+// cachedStatement returns the statement prepared on the database for the query, if it is cached
+func (q *queryExecTransaction) cachedStatement(sqlQ string) (*cachedStatement, bool) {
+	if q.statementCache == nil {
+		return nil, false
+	}
+	return q.statementCache.borrow(statementCacheKey{db: q.db, query: sqlQ})
+}
+
+// startedWith returns the context.Context the transaction or session was started with
+func startedWith(qet vsql.QueryExecTransactioner) context.Context {
+	switch t := qet.(type) {
+	case *queryExecTransaction:
+		return t.ctx
+	case *queryExecSession:
+		return t.ctx
+	}
+	return nil
+}
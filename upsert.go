@@ -0,0 +1,356 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vrows"
+	"strings"
+	"time"
+)
+
+// ErrUpsertKeyColumns is returned when an upsert has no key columns, or a key column is not one of the inserted columns
+var ErrUpsertKeyColumns = errors.New("upsert key columns must be a non-empty subset of the columns")
+
+// UpsertOutcome is what happened to a single row of an upsert
+type UpsertOutcome int
+
+const (
+	// UpsertUnknown is reported when the database does not tell what happened to the row
+	UpsertUnknown UpsertOutcome = iota
+	UpsertInserted
+	UpsertUpdated
+	// UpsertUnchanged is reported by MySQL when the row existed and already had the new values
+	UpsertUnchanged
+)
+
+// String returns the name of the outcome
+func (o UpsertOutcome) String() string {
+	switch o {
+	case UpsertInserted:
+		return "inserted"
+	case UpsertUpdated:
+		return "updated"
+	case UpsertUnchanged:
+		return "unchanged"
+	default:
+		return "unknown"
+	}
+}
+
+// Upsert describes rows that are inserted, or update the existing row with the same key
+type Upsert struct {
+	// Dialect selects the statement: ON DUPLICATE KEY UPDATE for MySQL, ON CONFLICT for postgres and SQLite and MERGE
+	// for SQL Server. GenericDialect is not supported as standard SQL has no portable upsert
+	Dialect Dialect
+	// Table is the name of the table, optionally qualified with the schema
+	Table string
+	// Columns are the names of all inserted columns, in the same order as the values of each row
+	Columns []string
+	// KeyColumns identify the existing row. For postgres and SQLite they must match a unique index, MySQL uses every unique index regardless
+	KeyColumns []string
+	// UpdateColumns are the columns set from the new values when the row exists. Empty updates all columns that are not keys
+	UpdateColumns []string
+	// Outcomes asks MySQL to tell whether each row was inserted or updated, which requires a statement per row. Postgres
+	// and SQL Server always tell, SQLite never does
+	Outcomes bool
+}
+
+// UpsertResult is the outcome of an upsert
+type UpsertResult struct {
+	// RowsAffected is the number of rows the database reported as affected. MySQL counts updated rows twice
+	RowsAffected int64
+	// Outcomes has what happened to each row, in the order of the rows
+	Outcomes []UpsertOutcome
+}
+
+// UpsertRows inserts or updates the rows using the dialect's upsert statement. Rows are chunked like InsertBatch, and a
+// row whose key is repeated in the same chunk starts the next one, so rows are applied in order and each has its own
+// outcome. Placeholders are rendered by the interpolation strategy of the engine. Statements that report outcomes go through
+// the query middleware, the others through the insert middleware
+// @param db the engine or transaction to upsert into
+// @return result has the rows of every statement that succeeded, even when err is not nil
+func UpsertRows(ctx context.Context, db vsql.QueryExecer, upsert Upsert, rows BatchRows) (result UpsertResult, err error) {
+	keys, err := upsert.keyIndexes()
+	if err != nil {
+		return result, err
+	}
+	if upsert.Dialect == GenericDialect {
+		return result, fmt.Errorf("upsert is not supported by the %s dialect", upsert.Dialect)
+	}
+	chunkSize, err := BatchInsert{Dialect: upsert.Dialect, Columns: upsert.Columns}.rowsPerStatement()
+	if err != nil {
+		return result, err
+	}
+	if upsert.Dialect == MySQLDialect && upsert.Outcomes {
+		chunkSize = 1
+	}
+	chunk := make([][]interface{}, 0, chunkSize)
+	inChunk := make(map[string]bool, chunkSize)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		err := upsert.upsertChunk(ctx, db, chunk, keys, &result)
+		chunk = chunk[:0]
+		inChunk = make(map[string]bool, chunkSize)
+		return err
+	}
+	for row := 0; ; row++ {
+		values, ok, err := rows.Next()
+		if err != nil {
+			return result, err
+		}
+		if !ok {
+			return result, flush()
+		}
+		if len(values) != len(upsert.Columns) {
+			return result, fmt.Errorf("row %d has %d values, expected %d", row, len(values), len(upsert.Columns))
+		}
+		// postgres and SQL Server cannot change a row twice in one statement, so a repeated key starts the next one,
+		// which also gives each row its own outcome
+		key := upsertKey(keyValues(values, keys))
+		if inChunk[key] {
+			if err = flush(); err != nil {
+				return result, err
+			}
+		}
+		chunk = append(chunk, values)
+		inChunk[key] = true
+		if len(chunk) == chunkSize {
+			if err = flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+}
+
+// keyIndexes returns the position of each key column among the columns
+func (u Upsert) keyIndexes() (indexes []int, err error) {
+	if len(u.KeyColumns) == 0 {
+		return nil, ErrUpsertKeyColumns
+	}
+	for _, key := range u.KeyColumns {
+		index := indexOfColumn(u.Columns, key)
+		if index < 0 {
+			return nil, ErrUpsertKeyColumns
+		}
+		indexes = append(indexes, index)
+	}
+	return
+}
+
+func indexOfColumn(columns []string, column string) int {
+	for i, c := range columns {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
+
+// updateColumns returns the columns set when the row exists
+func (u Upsert) updateColumns() []string {
+	if len(u.UpdateColumns) != 0 {
+		return u.UpdateColumns
+	}
+	columns := make([]string, 0, len(u.Columns))
+	for _, column := range u.Columns {
+		if indexOfColumn(u.KeyColumns, column) < 0 {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+func (u Upsert) upsertChunk(ctx context.Context, db vsql.QueryExecer, chunk [][]interface{}, keys []int, result *UpsertResult) error {
+	params := make([]interface{}, 0, len(chunk)*len(u.Columns))
+	for _, values := range chunk {
+		params = append(params, values...)
+	}
+	query := newPositionalQuery(u.upsertSQL(len(chunk)), params...)
+
+	if u.Dialect == PostgresDialect || u.Dialect == SQLServerDialect {
+		rows, err := db.Query(ctx, query)
+		if err != nil {
+			return err
+		}
+		outcomes, err := readUpsertOutcomes(rows, keyValues(chunk[0], keys))
+		if err != nil {
+			return err
+		}
+		for _, values := range chunk {
+			result.Outcomes = append(result.Outcomes, outcomes[upsertKey(keyValues(values, keys))])
+		}
+		result.RowsAffected += int64(len(outcomes))
+		return nil
+	}
+
+	res, err := db.Insert(ctx, query)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	result.RowsAffected += int64(affected)
+	outcome := UpsertUnknown
+	if u.Dialect == MySQLDialect && len(chunk) == 1 {
+		// MySQL reports 1 for an inserted row, 2 for an updated row and 0 for a row that was left as it was
+		switch affected {
+		case 0:
+			outcome = UpsertUnchanged
+		case 1:
+			outcome = UpsertInserted
+		default:
+			outcome = UpsertUpdated
+		}
+	}
+	for range chunk {
+		result.Outcomes = append(result.Outcomes, outcome)
+	}
+	return nil
+}
+
+// readUpsertOutcomes reads the key columns and the inserted flag returned by postgres and SQL Server, keyed by upsertKey.
+// The keys are converted to the types of the sample's, so that they match the rows they were sent as regardless of how
+// the driver returns them, such as decimals returned as text
+// @param sample the key values of one of the upserted rows
+func readUpsertOutcomes(rows vrows.Rowser, sample []interface{}) (outcomes map[string]UpsertOutcome, err error) {
+	defer func() {
+		if closeErr := rows.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	outcomes = make(map[string]UpsertOutcome)
+	keyCount := len(sample)
+	for row := rows.Next(); row != nil; row = rows.Next() {
+		values, err := scanRow(row, keyCount+1)
+		if err != nil {
+			return nil, err
+		}
+		outcome := UpsertUpdated
+		switch flag := values[keyCount].(type) {
+		case bool:
+			if flag {
+				outcome = UpsertInserted
+			}
+		default:
+			// SQL Server's $action
+			if asString(flag) == "INSERT" {
+				outcome = UpsertInserted
+			}
+		}
+		for i := range sample {
+			values[i] = convertLike(values[i], sample[i])
+		}
+		outcomes[upsertKey(values[:keyCount])] = outcome
+	}
+	return outcomes, nil
+}
+
+// keyValues returns the values of the key columns of a row
+func keyValues(values []interface{}, keys []int) []interface{} {
+	keyed := make([]interface{}, len(keys))
+	for i, key := range keys {
+		keyed[i] = values[key]
+	}
+	return keyed
+}
+
+// upsertKey identifies a row by the values of its key columns
+func upsertKey(keyValues []interface{}) string {
+	parts := make([]string, len(keyValues))
+	for i, v := range keyValues {
+		if t, ok := v.(time.Time); ok {
+			// the same instant is the same key, whatever the location it was read in
+			v = t.UTC()
+		}
+		parts[i] = asString(v)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// upsertSQL renders the dialect's upsert statement for the number of rows
+func (u Upsert) upsertSQL(rows int) string {
+	d := u.Dialect
+	columns := u.quoted(u.Columns, "")
+	values := valuesTuples(len(u.Columns), rows)
+	updates := u.updateColumns()
+	switch d {
+	case MySQLDialect:
+		set := make([]string, 0, len(updates))
+		for _, column := range updates {
+			set = append(set, fmt.Sprintf("%s = VALUES(%s)", d.QuoteIdentifier(column), d.QuoteIdentifier(column)))
+		}
+		if len(set) == 0 {
+			// a no-op update leaves existing rows alone without failing on them
+			key := d.QuoteIdentifier(u.KeyColumns[0])
+			set = append(set, key+" = "+key)
+		}
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON DUPLICATE KEY UPDATE %s",
+			d.QuoteIdentifier(u.Table), strings.Join(columns, ", "), values, strings.Join(set, ", "))
+	case PostgresDialect, SQLiteDialect:
+		set := make([]string, 0, len(updates))
+		for _, column := range updates {
+			set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", d.QuoteIdentifier(column), d.QuoteIdentifier(column)))
+		}
+		sqlQ := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON CONFLICT (%s) ",
+			d.QuoteIdentifier(u.Table), strings.Join(columns, ", "), values, strings.Join(u.quoted(u.KeyColumns, ""), ", "))
+		if d == SQLiteDialect {
+			if len(set) == 0 {
+				return sqlQ + "DO NOTHING"
+			}
+			return sqlQ + "DO UPDATE SET " + strings.Join(set, ", ")
+		}
+		if len(set) == 0 {
+			// DO NOTHING would not return the existing rows, so touch them instead
+			key := d.QuoteIdentifier(u.KeyColumns[0])
+			set = append(set, key+" = EXCLUDED."+key)
+		}
+		// xmax is zero for rows created by this transaction, and set for rows it updated
+		return sqlQ + "DO UPDATE SET " + strings.Join(set, ", ") + " RETURNING " + strings.Join(u.quoted(u.KeyColumns, ""), ", ") + ", (xmax = 0)"
+	default:
+		on := make([]string, len(u.KeyColumns))
+		for i, key := range u.KeyColumns {
+			on[i] = fmt.Sprintf("target.%s = source.%s", d.QuoteIdentifier(key), d.QuoteIdentifier(key))
+		}
+		sqlQ := fmt.Sprintf("MERGE INTO %s WITH (HOLDLOCK) AS target USING (VALUES %s) AS source (%s) ON %s",
+			d.QuoteIdentifier(u.Table), values, strings.Join(columns, ", "), strings.Join(on, " AND "))
+		if len(updates) > 0 {
+			set := make([]string, len(updates))
+			for i, column := range updates {
+				set[i] = fmt.Sprintf("target.%s = source.%s", d.QuoteIdentifier(column), d.QuoteIdentifier(column))
+			}
+			sqlQ += " WHEN MATCHED THEN UPDATE SET " + strings.Join(set, ", ")
+		}
+		return sqlQ + fmt.Sprintf(" WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s) OUTPUT %s, $action;",
+			strings.Join(columns, ", "), strings.Join(u.quoted(u.Columns, "source."), ", "), strings.Join(u.quoted(u.KeyColumns, "inserted."), ", "))
+	}
+}
+
+// quoted quotes each column and prefixes it, such as with a table alias
+func (u Upsert) quoted(columns []string, prefix string) []string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = prefix + u.Dialect.QuoteIdentifier(column)
+	}
+	return quoted
+}
@@ -0,0 +1,133 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine_go
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"testing"
+)
+
+func TestUpsertRows_SQLite(t *testing.T) {
+	engine, db := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE stock (sku TEXT PRIMARY KEY, quantity INTEGER, name TEXT)")); err != nil {
+		t.Fatal(err)
+	}
+	upsert := Upsert{
+		Dialect:       SQLiteDialect,
+		Table:         "stock",
+		Columns:       []string{"sku", "quantity", "name"},
+		KeyColumns:    []string{"sku"},
+		UpdateColumns: []string{"quantity"},
+	}
+	if _, err := UpsertRows(ctx, engine, upsert, BatchRowsFromSlice([][]interface{}{{"a", 1, "apple"}, {"b", 2, "banana"}})); err != nil {
+		t.Fatal(err)
+	}
+	result, err := UpsertRows(ctx, engine, upsert, BatchRowsFromSlice([][]interface{}{{"a", 5, "avocado"}, {"c", 3, "cherry"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Outcomes) != 2 || result.Outcomes[0] != UpsertUnknown {
+		t.Errorf("expected unknown outcomes from SQLite: %+v", result)
+	}
+	var quantity int
+	var name string
+	if err = db.QueryRow("SELECT quantity, name FROM stock WHERE sku = 'a'").Scan(&quantity, &name); err != nil {
+		t.Fatal(err)
+	}
+	if quantity != 5 || name != "apple" {
+		t.Errorf("expected only the quantity to be updated, got %d %s", quantity, name)
+	}
+
+	upsert.KeyColumns = []string{"missing"}
+	if _, err = UpsertRows(ctx, engine, upsert, BatchRowsFromSlice(nil)); err != ErrUpsertKeyColumns {
+		t.Errorf("expected ErrUpsertKeyColumns, got %v", err)
+	}
+}
+
+func TestUpsert_SQL(t *testing.T) {
+	upsert := Upsert{
+		Table:      "stock",
+		Columns:    []string{"sku", "quantity"},
+		KeyColumns: []string{"sku"},
+	}
+	cases := map[Dialect]string{
+		MySQLDialect:     "INSERT INTO `stock` (`sku`, `quantity`) VALUES (?, ?), (?, ?) ON DUPLICATE KEY UPDATE `quantity` = VALUES(`quantity`)",
+		PostgresDialect:  `INSERT INTO "stock" ("sku", "quantity") VALUES (?, ?), (?, ?) ON CONFLICT ("sku") DO UPDATE SET "quantity" = EXCLUDED."quantity" RETURNING "sku", (xmax = 0)`,
+		SQLiteDialect:    `INSERT INTO "stock" ("sku", "quantity") VALUES (?, ?), (?, ?) ON CONFLICT ("sku") DO UPDATE SET "quantity" = EXCLUDED."quantity"`,
+		SQLServerDialect: "MERGE INTO [stock] WITH (HOLDLOCK) AS target USING (VALUES (?, ?), (?, ?)) AS source ([sku], [quantity]) ON target.[sku] = source.[sku] WHEN MATCHED THEN UPDATE SET target.[quantity] = source.[quantity] WHEN NOT MATCHED THEN INSERT ([sku], [quantity]) VALUES (source.[sku], source.[quantity]) OUTPUT inserted.[sku], $action;",
+	}
+	for dialect, expected := range cases {
+		upsert.Dialect = dialect
+		if actual := upsert.upsertSQL(2); actual != expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", dialect, expected, actual)
+		}
+	}
+}
+
+func TestReadUpsertOutcomes(t *testing.T) {
+	rows := newMemoryRows([]string{"sku", "inserted"}, [][]interface{}{{"b", false}, {"a", true}})
+	outcomes, err := readUpsertOutcomes(rows, []interface{}{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcomes["a"] != UpsertInserted || outcomes["b"] != UpsertUpdated {
+		t.Errorf("unexpected outcomes: %v", outcomes)
+	}
+	rows = newMemoryRows([]string{"sku", "$action"}, [][]interface{}{{int64(1), "INSERT"}})
+	if outcomes, err = readUpsertOutcomes(rows, []interface{}{1}); err != nil || outcomes[upsertKey([]interface{}{1})] != UpsertInserted {
+		t.Errorf("expected SQL Server's INSERT action to match the int key: %v, %v", outcomes, err)
+	}
+	// a decimal key returned as text in the scale of the column
+	rows = newMemoryRows([]string{"price", "inserted"}, [][]interface{}{{[]byte("1.50"), true}})
+	if outcomes, err = readUpsertOutcomes(rows, []interface{}{1.5}); err != nil || outcomes[upsertKey([]interface{}{1.5})] != UpsertInserted {
+		t.Errorf("expected the returned key to be read as the type of the upserted key: %v, %v", outcomes, err)
+	}
+}
+
+func TestUpsertRows_RepeatedKeys(t *testing.T) {
+	engine, db := newSQLiteEngine(t, Config{})
+	defer func() { _ = engine.Close() }()
+	statements := 0
+	engine.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		statements++
+		c.Next(ctx)
+	})
+	ctx := context.Background()
+	if _, err := engine.Exec(ctx, vparam.New("CREATE TABLE stock (sku TEXT PRIMARY KEY, quantity INTEGER)")); err != nil {
+		t.Fatal(err)
+	}
+	upsert := Upsert{
+		Dialect:    SQLiteDialect,
+		Table:      "stock",
+		Columns:    []string{"sku", "quantity"},
+		KeyColumns: []string{"sku"},
+	}
+	result, err := UpsertRows(ctx, engine, upsert, BatchRowsFromSlice([][]interface{}{{"a", 1}, {"b", 2}, {"a", 3}, {"c", 4}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statements != 2 || len(result.Outcomes) != 4 {
+		t.Errorf("expected the repeated key to start a second statement, got %d statements: %+v", statements, result)
+	}
+	var quantity int
+	if err = db.QueryRow("SELECT quantity FROM stock WHERE sku = 'a'").Scan(&quantity); err != nil || quantity != 3 {
+		t.Errorf("expected the last row of a repeated key to win, got %d, %v", quantity, err)
+	}
+}